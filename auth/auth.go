@@ -1,5 +1,7 @@
 package auth
 
+import "errors"
+
 type BasicVerifier interface {
 	Verify(string, string) error
 }
@@ -12,3 +14,24 @@ type BasicPrinciple interface {
 	ID() string
 	Permissions() []string
 }
+
+// BasicAuthenticators tries each BasicAuthenticator in order and returns the
+// first principle that authenticates, or all of the errors joined together.
+// Use it to accept, for example, both csvauth credentials and JWTs.
+type BasicAuthenticators []BasicAuthenticator
+
+func (as BasicAuthenticators) Authenticate(name, secret string) (BasicPrinciple, error) {
+	if len(as) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	var errs []error
+	for _, a := range as {
+		p, err := a.Authenticate(name, secret)
+		if err == nil {
+			return p, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"crypto/rand"
 	"encoding/base64"
-	"encoding/csv"
 	"encoding/hex"
 	"errors"
 	"flag"
@@ -35,12 +34,24 @@ func showHelp() {
 EXAMPLES
    csvauth store --token 'my-new-token'
    csvauth store --ask-password 'my-new-user'
-   csvauth verify 'my-new-user'
+   csvauth check 'my-new-user'
+   csvauth list
+   csvauth add-role --dry-run 'my-new-user' 'triage'
+   csvauth rm 'my-new-token~xxxxxxxx'
 
 USAGE
    csvauth help
+   csvauth init
    csvauth store [--help] [FLAGS] <username>
-   csvauth verify [--help] [FLAGS] <username>
+   csvauth check [--help] [FLAGS] <username>
+   csvauth list [--purpose <purpose>]
+   csvauth rm [--dry-run] [--purpose <purpose>] <name|token-id>
+   csvauth rename [--dry-run] [--purpose <purpose>] <name|token-id> <new-name>
+   csvauth set-roles [--dry-run] [--purpose <purpose>] <name|token-id> [role...]
+   csvauth add-role [--dry-run] [--purpose <purpose>] <name|token-id> <role...>
+   csvauth rm-role [--dry-run] [--purpose <purpose>] <name|token-id> <role...>
+   csvauth export [--format json|csv|tsv]
+   csvauth import [--dry-run] [--overwrite] <other-credentials.csv>
 
 `)
 
@@ -62,7 +73,12 @@ func main() {
 	case 1:
 		fallthrough
 	case 2:
-		os.Args = append(os.Args, "--help")
+		switch subcmd {
+		case "list", "export":
+			// no arguments required
+		default:
+			os.Args = append(os.Args, "--help")
+		}
 	default:
 		switch os.Args[2] {
 		case "", "help":
@@ -107,8 +123,22 @@ func main() {
 		}
 
 		fmt.Fprintf(os.Stderr, "\n")
+	case "list", "rm", "rename", "set-roles", "add-role", "rm-role", "export", "import":
+		// the AES key is not needed to read or rewrite rows as-is
+		var csvErr error
+		csvFile, csvErr = getCSVFile(csvPath)
+		if csvErr != nil {
+			if os.IsNotExist(csvErr) {
+				fmt.Fprintf(os.Stderr, "no credentials file found, run 'csvauth init' to create it, or provide %s or %s\n", defaultCSVFileENVName, csvPath)
+			} else {
+				fmt.Fprintf(os.Stderr, "%v\n", csvErr)
+			}
+			os.Exit(1)
+		}
+		defer func() { _ = csvFile.Close() }()
 	}
 
+	var cmdErr error
 	switch subcmd {
 	case "init":
 		if err := handleInit(defaultAESKeyENVName, filename, csvPath); err != nil {
@@ -119,6 +149,18 @@ func main() {
 		handleSet(os.Args[2:], aesKey, csvFile)
 	case "check":
 		handleCheck(os.Args[2:], aesKey, csvFile)
+	case "list":
+		cmdErr = handleList(os.Args[2:], csvFile)
+	case "rm":
+		cmdErr = handleRemove(os.Args[2:], csvFile)
+	case "rename":
+		cmdErr = handleRename(os.Args[2:], csvFile)
+	case "set-roles", "add-role", "rm-role":
+		cmdErr = handleRoles(subcmd, os.Args[2:], csvFile)
+	case "export":
+		cmdErr = handleExport(os.Args[2:], csvFile)
+	case "import":
+		cmdErr = handleImport(os.Args[2:], csvFile)
 	case "--help", "-help", "help", "":
 		showHelp()
 		return
@@ -138,6 +180,11 @@ func main() {
 			os.Exit(1)
 		}
 	}
+
+	if cmdErr != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", cmdErr)
+		os.Exit(1)
+	}
 }
 
 func getCSVPath() string {
@@ -499,22 +546,7 @@ func handleCheck(args []string, aesKey []byte, csvFile csvauth.NamedReadCloser)
 }
 
 func writeCSV(csvPath string, records [][]string) {
-	f, err := os.Create(csvPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating CSV: %v\n", err)
-		os.Exit(1)
-	}
-	defer func() { _ = f.Close() }()
-
-	writer := csv.NewWriter(f)
-	writer.Comma = '\t'
-
-	_ = writer.Write([]string{"purpose", "name", "algo", "salt", "derived", "roles", "extra"})
-	for _, record := range records {
-		_ = writer.Write(record)
-	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
+	if err := replaceCSV(csvPath, records); err != nil {
 		fmt.Fprintf(os.Stderr, "Error writing CSV: %v\n", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+var errUsage = errors.New("usage error")
+
+var csvHeader = []string{"purpose", "name", "algo", "salt", "derived", "roles", "extra"}
+
+// exportedCredential mirrors the columns of Credential.ToRecord
+type exportedCredential struct {
+	Purpose   string   `json:"purpose"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Algorithm string   `json:"algorithm"`
+	Salt      string   `json:"salt,omitempty"`
+	Derived   string   `json:"derived,omitempty"`
+	Roles     []string `json:"roles"`
+	Extra     string   `json:"extra,omitempty"`
+}
+
+func handleList(args []string, csvFile csvauth.NamedReadCloser) error {
+	listFlags := flag.NewFlagSet("csvauth-list", flag.ContinueOnError)
+	purpose := listFlags.String("purpose", "", "only list credentials with this purpose, such as 'login', 'token', or 'basecamp_api_key'")
+	if err := listFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if listFlags.NArg() > 0 {
+		return fmt.Errorf("%w: list takes no arguments, got %q", errUsage, strings.Join(listFlags.Args(), " "))
+	}
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "PURPOSE\tID\tALGORITHM\tROLES\n")
+	for _, c := range creds {
+		if *purpose != "" && c.Purpose != *purpose {
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Purpose, c.ID(), strings.Join(c.Params, " "), strings.Join(c.Roles, " "))
+	}
+	return tw.Flush()
+}
+
+func handleRemove(args []string, csvFile csvauth.NamedReadCloser) error {
+	rmFlags := flag.NewFlagSet("csvauth-rm", flag.ContinueOnError)
+	purpose := rmFlags.String("purpose", "login", "'login' for users and tokens, or a service account name, such as 'basecamp_api_key'")
+	dryRun := rmFlags.Bool("dry-run", false, "show what would change without writing the file")
+	if err := rmFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if rmFlags.NArg() > 1 {
+		return fmt.Errorf("%w: too many arguments: %q (flags should come before arguments)", errUsage, strings.Join(rmFlags.Args(), " "))
+	}
+	id := rmFlags.Arg(0)
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	i, err := findCredential(creds, *purpose, id)
+	if err != nil {
+		return err
+	}
+	c := creds[i]
+	creds = slices.Delete(creds, i, i+1)
+
+	return saveCredentials(csvFile.Name(), creds, *dryRun, fmt.Sprintf("removed %s %q", c.Purpose, c.ID()))
+}
+
+func handleRename(args []string, csvFile csvauth.NamedReadCloser) error {
+	renameFlags := flag.NewFlagSet("csvauth-rename", flag.ContinueOnError)
+	purpose := renameFlags.String("purpose", "login", "'login' for users and tokens, or a service account name, such as 'basecamp_api_key'")
+	dryRun := renameFlags.Bool("dry-run", false, "show what would change without writing the file")
+	if err := renameFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if renameFlags.NArg() != 2 {
+		return fmt.Errorf("%w: rename takes exactly 2 arguments: <name|token-id> <new-name>", errUsage)
+	}
+	id, newName := renameFlags.Arg(0), renameFlags.Arg(1)
+	switch newName {
+	case "", "id", "name", "purpose":
+		return fmt.Errorf("invalid new name %q", newName)
+	}
+	if strings.Contains(newName, "~") {
+		return fmt.Errorf("invalid new name %q: must not contain '~'", newName)
+	}
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	i, err := findCredential(creds, *purpose, id)
+	if err != nil {
+		return err
+	}
+	oldID := creds[i].ID()
+
+	renamed := creds[i]
+	renamed.Name = newName
+	for j, c := range creds {
+		if j != i && credentialKey(c) == credentialKey(renamed) {
+			return fmt.Errorf("%s %q already exists", c.Purpose, c.ID())
+		}
+	}
+	creds[i] = renamed
+
+	return saveCredentials(csvFile.Name(), creds, *dryRun, fmt.Sprintf("renamed %s %q to %q", renamed.Purpose, oldID, renamed.ID()))
+}
+
+// handleRoles implements set-roles, add-role, and rm-role
+func handleRoles(subcmd string, args []string, csvFile csvauth.NamedReadCloser) error {
+	rolesFlags := flag.NewFlagSet("csvauth-"+subcmd, flag.ContinueOnError)
+	purpose := rolesFlags.String("purpose", "login", "'login' for users and tokens, or a service account name, such as 'basecamp_api_key'")
+	dryRun := rolesFlags.Bool("dry-run", false, "show what would change without writing the file")
+	if err := rolesFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if rolesFlags.NArg() < 1 {
+		return fmt.Errorf("%w: %s takes <name|token-id> [role...]", errUsage, subcmd)
+	}
+	id := rolesFlags.Arg(0)
+	roles := parseRoles(strings.Join(rolesFlags.Args()[1:], " "))
+	if subcmd != "set-roles" && len(roles) == 0 {
+		return fmt.Errorf("%w: %s requires at least one role", errUsage, subcmd)
+	}
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	i, err := findCredential(creds, *purpose, id)
+	if err != nil {
+		return err
+	}
+	c := &creds[i]
+
+	switch subcmd {
+	case "set-roles":
+		c.Roles = roles
+	case "add-role":
+		for _, role := range roles {
+			if !slices.Contains(c.Roles, role) {
+				c.Roles = append(c.Roles, role)
+			}
+		}
+	case "rm-role":
+		c.Roles = slices.DeleteFunc(c.Roles, func(role string) bool {
+			return slices.Contains(roles, role)
+		})
+	default:
+		panic(fmt.Errorf("unknown roles subcommand %q", subcmd))
+	}
+
+	return saveCredentials(csvFile.Name(), creds, *dryRun, fmt.Sprintf("set roles of %s %q to %q", c.Purpose, c.ID(), strings.Join(c.Roles, " ")))
+}
+
+func handleExport(args []string, csvFile csvauth.NamedReadCloser) error {
+	exportFlags := flag.NewFlagSet("csvauth-export", flag.ContinueOnError)
+	format := exportFlags.String("format", "json", "output format: json, csv, or tsv (salt and derived values are included as stored)")
+	if err := exportFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if exportFlags.NArg() > 0 {
+		return fmt.Errorf("%w: export takes no arguments, got %q", errUsage, strings.Join(exportFlags.Args(), " "))
+	}
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		exported := make([]exportedCredential, 0, len(creds))
+		for _, c := range creds {
+			record := c.ToRecord()
+			exported = append(exported, exportedCredential{
+				Purpose:   record[0],
+				ID:        c.ID(),
+				Name:      c.Name,
+				Algorithm: record[2],
+				Salt:      record[3],
+				Derived:   record[4],
+				Roles:     c.Roles,
+				Extra:     record[6],
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exported)
+	case "csv", "tsv":
+		comma := ','
+		if *format == "tsv" {
+			comma = '\t'
+		}
+		return writeRecords(os.Stdout, comma, creds)
+	default:
+		return fmt.Errorf("%w: unknown export format %q", errUsage, *format)
+	}
+}
+
+func handleImport(args []string, csvFile csvauth.NamedReadCloser) error {
+	importFlags := flag.NewFlagSet("csvauth-import", flag.ContinueOnError)
+	overwrite := importFlags.Bool("overwrite", false, "replace existing credentials with the same purpose and name (or token id)")
+	dryRun := importFlags.Bool("dry-run", false, "show what would change without writing the file")
+	if err := importFlags.Parse(args); err != nil {
+		return parseErr(err)
+	}
+	if importFlags.NArg() != 1 {
+		return fmt.Errorf("%w: import takes exactly 1 argument: <other-credentials.csv>", errUsage)
+	}
+	srcPath := importFlags.Arg(0)
+
+	creds, err := readCredentials(csvFile)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	imported, err := readCredentials(src)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", srcPath, err)
+	}
+
+	var added, replaced, skipped int
+	for _, c := range imported {
+		switch c.Params[0] {
+		case "aes-128-gcm":
+			fmt.Fprintf(os.Stderr, "warn: %s %q is aes-128-gcm encrypted and requires the same AES key\n", c.Purpose, c.ID())
+		default:
+			if c.Purpose == csvauth.PurposeToken {
+				fmt.Fprintf(os.Stderr, "warn: token %q can only be looked up with the same AES key\n", c.ID())
+			}
+		}
+
+		i := slices.IndexFunc(creds, func(known csvauth.Credential) bool {
+			return credentialKey(known) == credentialKey(c)
+		})
+		switch {
+		case i == -1:
+			creds = append(creds, c)
+			added += 1
+		case *overwrite:
+			creds[i] = c
+			replaced += 1
+		default:
+			fmt.Fprintf(os.Stderr, "skipping existing %s %q (use --overwrite to replace)\n", c.Purpose, c.ID())
+			skipped += 1
+		}
+	}
+
+	summary := fmt.Sprintf("imported from %s: %d added, %d replaced, %d skipped", srcPath, added, replaced, skipped)
+	return saveCredentials(csvFile.Name(), creds, *dryRun, summary)
+}
+
+// readCredentials parses every row with FromRecord, keeping file order.
+// The comma is inferred from the first line (tab if it has any, comma otherwise).
+func readCredentials(r io.Reader) ([]csvauth.Credential, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	comma := ','
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	if strings.Contains(firstLine, "\t") {
+		comma = '\t'
+	}
+
+	csvr := csv.NewReader(strings.NewReader(string(data)))
+	csvr.Comma = comma
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = -1 // ignore short rows
+	_, _ = csvr.Read()        // strip header row
+
+	var creds []csvauth.Credential
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || len(record) == 1 && len(record[0]) == 0 {
+			continue
+		}
+		if len(record) < 5 {
+			line, _ := csvr.FieldPos(0)
+			return nil, fmt.Errorf("invalid format on line %d: %d fields (expected at least 5)", line, len(record))
+		}
+
+		c, err := csvauth.FromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+
+	return creds, nil
+}
+
+// findCredential returns the index of the credential with the given name or token id.
+// Service accounts are found by purpose alone, and tokens may be found by name when
+// the name is unambiguous.
+func findCredential(creds []csvauth.Credential, purpose, id string) (int, error) {
+	switch purpose {
+	case csvauth.PurposeDefault, csvauth.PurposeToken:
+		if id == "" {
+			return -1, fmt.Errorf("%w: missing <name|token-id>", errUsage)
+		}
+
+		i := slices.IndexFunc(creds, func(c csvauth.Credential) bool {
+			return isLogin(c) && c.ID() == id
+		})
+		if i >= 0 {
+			return i, nil
+		}
+
+		var matches []int
+		for i, c := range creds {
+			if c.Purpose == csvauth.PurposeToken && c.Name == id {
+				matches = append(matches, i)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return -1, fmt.Errorf("%q: %w", id, csvauth.ErrNotFound)
+		case 1:
+			return matches[0], nil
+		default:
+			var ids []string
+			for _, i := range matches {
+				ids = append(ids, creds[i].ID())
+			}
+			return -1, fmt.Errorf("%q matches multiple tokens, use one of: %s", id, strings.Join(ids, ", "))
+		}
+	default:
+		i := slices.IndexFunc(creds, func(c csvauth.Credential) bool {
+			return c.Purpose == purpose && (id == "" || c.Name == id)
+		})
+		if i == -1 {
+			return -1, fmt.Errorf("%s %q: %w", purpose, id, csvauth.ErrNotFound)
+		}
+		return i, nil
+	}
+}
+
+// credentialKey is the uniqueness key of a row, the same as used by Auth.LoadCSV
+func credentialKey(c csvauth.Credential) string {
+	if isLogin(c) {
+		return csvauth.PurposeDefault + "\t" + c.ID()
+	}
+	return c.Purpose
+}
+
+func isLogin(c csvauth.Credential) bool {
+	return c.Purpose == csvauth.PurposeDefault || c.Purpose == csvauth.PurposeToken
+}
+
+func parseRoles(roleList string) []string {
+	roleList = strings.ReplaceAll(roleList, ",", " ")
+	return strings.Fields(roleList)
+}
+
+// saveCredentials atomically replaces csvPath, or only reports the change on a dry run
+func saveCredentials(csvPath string, creds []csvauth.Credential, dryRun bool, summary string) error {
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "dry run: %s (%q not changed)\n", summary, csvPath)
+		return nil
+	}
+
+	var records [][]string
+	for _, c := range creds {
+		records = append(records, c.ToRecord())
+	}
+	if err := replaceCSV(csvPath, records); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s in %q\n", summary, csvPath)
+	return nil
+}
+
+func writeRecords(w io.Writer, comma rune, creds []csvauth.Credential) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+
+	_ = writer.Write(csvHeader)
+	for _, c := range creds {
+		_ = writer.Write(c.ToRecord())
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// replaceCSV writes the records to a temporary file beside csvPath
+// and renames it over the original, so readers never see a partial file
+func replaceCSV(csvPath string, records [][]string) error {
+	mode := os.FileMode(0640)
+	if fi, err := os.Stat(csvPath); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(csvPath), "."+filepath.Base(csvPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	tmpPath := f.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+	defer func() { _ = f.Close() }()
+
+	writer := csv.NewWriter(f)
+	writer.Comma = '\t'
+	_ = writer.Write(csvHeader)
+	for _, record := range records {
+		_ = writer.Write(record)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("could not write CSV: %w", err)
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, csvPath)
+}
+
+func parseErr(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", errUsage, err)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// testCSVPath copies the example credentials to a temporary file
+func testCSVPath(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile("../../credentials.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvPath := filepath.Join(t.TempDir(), "credentials.tsv")
+	if err := os.WriteFile(csvPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return csvPath
+}
+
+// manage runs a subcommand against the file, as main does
+func manage(t *testing.T, csvPath string, handle func([]string, csvauth.NamedReadCloser) error, args ...string) error {
+	t.Helper()
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	return handle(args, f)
+}
+
+func roles(subcmd string) func([]string, csvauth.NamedReadCloser) error {
+	return func(args []string, csvFile csvauth.NamedReadCloser) error {
+		return handleRoles(subcmd, args, csvFile)
+	}
+}
+
+// captureStdout returns what fn writes to os.Stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	fnErr := fn()
+	os.Stdout = stdout
+
+	out, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), fnErr
+}
+
+func readTestCredentials(t *testing.T, csvPath string) []csvauth.Credential {
+	t.Helper()
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	creds, err := readCredentials(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creds
+}
+
+func findTestCredential(creds []csvauth.Credential, purpose, id string) (csvauth.Credential, bool) {
+	i := slices.IndexFunc(creds, func(c csvauth.Credential) bool {
+		return c.Purpose == purpose && c.ID() == id
+	})
+	if i == -1 {
+		return csvauth.Credential{}, false
+	}
+	return creds[i], true
+}
+
+func TestReadCredentialsRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("../../credentials.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := readCredentials(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csvr := csv.NewReader(bytes.NewReader(data))
+	csvr.Comma = '\t'
+	records, err := csvr.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = records[1:]
+	if len(creds) != len(records) {
+		t.Fatalf("read %d credentials from %d rows", len(creds), len(records))
+	}
+	for i, c := range creds {
+		if got := c.ToRecord(); !slices.Equal(got, records[i]) {
+			t.Errorf("row %d:\n got %q\nwant %q", i+1, got, records[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeRecords(&buf, '\t', creds); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(data) {
+		t.Errorf("rewritten file differs:\n%s", buf.String())
+	}
+}
+
+func TestList(t *testing.T) {
+	csvPath := testCSVPath(t)
+	out, err := captureStdout(t, func() error {
+		return manage(t, csvPath, handleList)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range readTestCredentials(t, csvPath) {
+		if !strings.Contains(out, c.ID()) {
+			t.Errorf("missing %s %q", c.Purpose, c.ID())
+		}
+		record := c.ToRecord()
+		for _, secret := range []string{record[3], record[4]} {
+			if secret != "" && strings.Contains(out, secret) {
+				t.Errorf("list printed the salt or derived value of %q: %q", c.ID(), secret)
+			}
+		}
+	}
+
+	out, err = captureStdout(t, func() error {
+		return manage(t, csvPath, handleList, "--purpose", "token")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 3 || strings.Contains(out, "user1") {
+		t.Errorf("--purpose token:\n%s", out)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	csvPath := testCSVPath(t)
+	importPath := filepath.Join(t.TempDir(), "import.tsv")
+	importTSV := "purpose\tname\talgo\tsalt\tderived\troles\textra\nlogin\tuser5\tplain\t\tpass5\t\t\n"
+	if err := os.WriteFile(importPath, []byte(importTSV), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		handle func([]string, csvauth.NamedReadCloser) error
+		args   []string
+	}{
+		{"rm", handleRemove, []string{"--dry-run", "user1"}},
+		{"rename", handleRename, []string{"--dry-run", "user1", "alice"}},
+		{"set-roles", roles("set-roles"), []string{"--dry-run", "user1", "admin"}},
+		{"add-role", roles("add-role"), []string{"--dry-run", "user1", "admin"}},
+		{"rm-role", roles("rm-role"), []string{"--dry-run", "user1", "admin"}},
+		{"import", handleImport, []string{"--dry-run", "--overwrite", importPath}},
+	}
+	for _, tt := range tests {
+		if err := manage(t, csvPath, tt.handle, tt.args...); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		after, err := os.ReadFile(csvPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(before, after) {
+			t.Fatalf("%s --dry-run changed the file", tt.name)
+		}
+	}
+	assertNoTempFiles(t, csvPath)
+}
+
+func TestManage(t *testing.T) {
+	csvPath := testCSVPath(t)
+
+	// rm
+	if err := manage(t, csvPath, handleRemove, "user4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manage(t, csvPath, handleRemove, "--purpose", "service2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manage(t, csvPath, handleRemove, "user4"); !errors.Is(err, csvauth.ErrNotFound) {
+		t.Errorf("rm of a missing user: %v", err)
+	}
+	if err := manage(t, csvPath, handleRemove, "api"); err == nil || !strings.Contains(err.Error(), "multiple tokens") {
+		t.Errorf("rm of an ambiguous token name: %v", err)
+	}
+	if err := manage(t, csvPath, handleRemove, "api~vkdAIZ2O"); err != nil {
+		t.Fatal(err)
+	}
+	creds := readTestCredentials(t, csvPath)
+	if len(creds) != 7 {
+		t.Errorf("expected 7 credentials after rm, got %d", len(creds))
+	}
+	for _, gone := range [][2]string{{"login", "user4"}, {"service2", "acme"}, {"token", "api~vkdAIZ2O"}} {
+		if _, ok := findTestCredential(creds, gone[0], gone[1]); ok {
+			t.Errorf("%s %q was not removed", gone[0], gone[1])
+		}
+	}
+	// and by its name, now that it's the only one
+	if err := manage(t, csvPath, handleRemove, "api"); err != nil {
+		t.Fatal(err)
+	}
+
+	// rename
+	if err := manage(t, csvPath, handleRename, "user1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]string{{"alice", "user2"}, {"alice", "a~b"}, {"alice", "id"}} {
+		if err := manage(t, csvPath, handleRename, bad...); err == nil {
+			t.Errorf("rename %q should fail", bad)
+		}
+	}
+	if err := manage(t, csvPath, handleRename, "alice"); !errors.Is(err, errUsage) {
+		t.Errorf("rename with one argument: %v", err)
+	}
+	creds = readTestCredentials(t, csvPath)
+	alice, ok := findTestCredential(creds, "login", "alice")
+	if !ok {
+		t.Fatal("user1 was not renamed to alice")
+	}
+	if err := alice.Verify("alice", "pass1"); err != nil {
+		t.Errorf("alice's password was not kept: %v", err)
+	}
+
+	// roles
+	steps := []struct {
+		subcmd string
+		args   []string
+		want   string
+	}{
+		{"set-roles", []string{"user2", "admin,ops"}, "admin ops"},
+		{"add-role", []string{"user2", "ops", "audit"}, "admin ops audit"},
+		{"rm-role", []string{"user2", "ops"}, "admin audit"},
+		{"set-roles", []string{"user2"}, ""},
+	}
+	for _, step := range steps {
+		if err := manage(t, csvPath, roles(step.subcmd), step.args...); err != nil {
+			t.Fatalf("%s: %v", step.subcmd, err)
+		}
+		user2, _ := findTestCredential(readTestCredentials(t, csvPath), "login", "user2")
+		if got := strings.Join(user2.Roles, " "); got != step.want {
+			t.Errorf("%s %q: roles = %q, want %q", step.subcmd, step.args, got, step.want)
+		}
+	}
+	if err := manage(t, csvPath, roles("add-role"), "user2"); !errors.Is(err, errUsage) {
+		t.Errorf("add-role without a role: %v", err)
+	}
+
+	// import
+	importPath := filepath.Join(t.TempDir(), "import.tsv")
+	importTSV := strings.Join([]string{
+		"purpose\tname\talgo\tsalt\tderived\troles\textra",
+		"login\tuser5\tplain\t\tpass5\tops\t",
+		"login\tuser2\tplain\t\tnew-pass2\tadmin\t",
+		"",
+	}, "\n")
+	if err := os.WriteFile(importPath, []byte(importTSV), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := manage(t, csvPath, handleImport, importPath); err != nil {
+		t.Fatal(err)
+	}
+	creds = readTestCredentials(t, csvPath)
+	user2, _ := findTestCredential(creds, "login", "user2")
+	if user2.Params[0] != "bcrypt" {
+		t.Errorf("import without --overwrite replaced user2")
+	}
+	if user5, ok := findTestCredential(creds, "login", "user5"); !ok || user5.Secret() != "pass5" {
+		t.Errorf("user5 was not imported")
+	}
+	if err := manage(t, csvPath, handleImport, "--overwrite", importPath); err != nil {
+		t.Fatal(err)
+	}
+	creds = readTestCredentials(t, csvPath)
+	user2, _ = findTestCredential(creds, "login", "user2")
+	if user2.Secret() != "new-pass2" || strings.Join(user2.Roles, " ") != "admin" {
+		t.Errorf("import --overwrite did not replace user2: %q", user2.ToRecord())
+	}
+	if n := len(creds); n != 7 {
+		t.Errorf("expected 7 credentials after import, got %d", n)
+	}
+
+	// every rewrite kept the mode, and cleaned up after itself
+	fi, err := os.Stat(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	assertNoTempFiles(t, csvPath)
+}
+
+func TestReplaceCSV(t *testing.T) {
+	csvPath := testCSVPath(t)
+	if err := os.Chmod(csvPath, 0604); err != nil {
+		t.Fatal(err)
+	}
+	records := [][]string{{"login", "user9", "plain", "", "pass9", "", ""}}
+	if err := replaceCSV(csvPath, records); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0604 {
+		t.Errorf("mode = %v, want 0604", fi.Mode().Perm())
+	}
+	data, err := os.ReadFile(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(csvHeader, "\t") + "\nlogin\tuser9\tplain\t\tpass9\t\t\n"; string(data) != want {
+		t.Errorf("file:\n%s", data)
+	}
+	assertNoTempFiles(t, csvPath)
+
+	// a new file gets the default mode
+	newPath := filepath.Join(filepath.Dir(csvPath), "new.tsv")
+	if err := replaceCSV(newPath, records); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(newPath); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("new file: %v %v", fi, err)
+	}
+
+	// a failed write leaves the original alone
+	if err := replaceCSV(filepath.Join(csvPath, "not-a-dir.tsv"), records); err == nil {
+		t.Error("expected an error writing beside a file")
+	}
+	if after, _ := os.ReadFile(csvPath); !bytes.Equal(data, after) {
+		t.Error("the original file was changed")
+	}
+	assertNoTempFiles(t, csvPath)
+}
+
+func assertNoTempFiles(t *testing.T, csvPath string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(csvPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("left behind %s", entry.Name())
+		}
+	}
+}
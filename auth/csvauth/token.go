@@ -44,6 +44,12 @@ func (a *Auth) loadAndVerifyToken(secret string) (*Credential, error) {
 	return &c, nil
 }
 
+// TokenID returns the short, keyed hash by which a token is looked up
+// (the part of a token's name after the '~'), for use by other credential stores
+func (a *Auth) TokenID(secret string) string {
+	return a.tokenCacheID(secret)
+}
+
 func (a *Auth) tokenCacheID(secret string) string {
 	return a.cacheID(secret, tokenHashLen)
 }
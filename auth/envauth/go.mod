@@ -1,3 +1,7 @@
 module github.com/therootcompany/golib/auth/envauth
 
-go 1.24.6
+go 1.25.0
+
+require github.com/therootcompany/golib/auth v1.1.1
+
+replace github.com/therootcompany/golib/auth => ../
@@ -0,0 +1,243 @@
+package envauth
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+// DefaultPrefix is the prefix of the numbered users' env vars:
+//
+//	BASIC_AUTH_USER_1=api
+//	BASIC_AUTH_USER_1_PASSWORD=secret
+//	BASIC_AUTH_USER_1_ROLES="GET:/reports/ admin"
+//	BASIC_AUTH_USER_2=ops
+//	BASIC_AUTH_USER_2_PBKDF2="salt: i63wDd7K-60 iterations: 1000 derived-key: 553ce8846c2304e93021dab03bacb5ca"
+const DefaultPrefix = "BASIC_AUTH_USER_"
+
+var ErrInvalidEnv = errors.New("invalid env credentials")
+
+// Principle is an authenticated user, with their roles as permissions
+type Principle struct {
+	Username string
+	Roles    []string
+}
+
+func (p *Principle) ID() string {
+	return p.Username
+}
+
+func (p *Principle) Permissions() []string {
+	return p.Roles
+}
+
+// User is a username, the credentials that verify it, and its roles
+type User struct {
+	BasicAuthVerifier
+	Username string
+	Roles    []string
+}
+
+// Users authenticates any one of a few users, such as from LoadEnv
+type Users []User
+
+// Authenticate implements auth.BasicAuthenticator.
+// Every user is verified, so that the time taken doesn't reveal which username matched.
+func (us Users) Authenticate(username, password string) (auth.BasicPrinciple, error) {
+	var found *User
+	var errs []error
+	for i := range us {
+		err := us[i].Verify(username, password)
+		if err == nil {
+			if found == nil {
+				found = &us[i]
+			}
+			continue
+		}
+		if !errors.Is(err, ErrUnauthorized) {
+			errs = append(errs, err)
+		}
+	}
+	if found != nil {
+		return &Principle{Username: found.Username, Roles: found.Roles}, nil
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return nil, ErrUnauthorized
+}
+
+// Verify implements BasicAuthVerifier, for any one of the users
+func (us Users) Verify(username, password string) error {
+	_, err := us.Authenticate(username, password)
+	return err
+}
+
+// LoadEnv loads the numbered users from the environment (see DefaultPrefix).
+// An empty prefix means DefaultPrefix.
+func LoadEnv(prefix string) (Users, error) {
+	return ParseEnviron(prefix, os.Environ())
+}
+
+// ParseEnviron loads the numbered users from "KEY=value" pairs, as from os.Environ.
+// Each <prefix><N> names a user, who must have exactly one of <prefix><N>_PASSWORD
+// or <prefix><N>_PBKDF2 (the output of the pbkdf2-sha256 tool), and may have
+// space-separated <prefix><N>_ROLES. Users are returned in the order of N.
+func ParseEnviron(prefix string, environ []string) (Users, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	type envUser struct {
+		username, password, pbkdf2, roles *string
+	}
+	users := map[int]*envUser{}
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		numStr, suffix, _ := strings.Cut(rest, "_")
+		n, err := strconv.Atoi(numStr)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: %s: expected %s<N>", ErrInvalidEnv, key, prefix)
+		}
+		u, ok := users[n]
+		if !ok {
+			u = &envUser{}
+			users[n] = u
+		}
+		switch suffix {
+		case "":
+			u.username = &value
+		case "PASSWORD":
+			u.password = &value
+		case "PBKDF2":
+			u.pbkdf2 = &value
+		case "ROLES":
+			u.roles = &value
+		default:
+			return nil, fmt.Errorf("%w: %s: unknown suffix _%s", ErrInvalidEnv, key, suffix)
+		}
+	}
+
+	var ns []int
+	for n := range users {
+		ns = append(ns, n)
+	}
+	slices.Sort(ns)
+
+	var us Users
+	names := map[string]bool{}
+	for _, n := range ns {
+		u := users[n]
+		key := prefix + strconv.Itoa(n)
+		if u.username == nil {
+			return nil, fmt.Errorf("%w: %s is not set", ErrInvalidEnv, key)
+		}
+		if names[*u.username] {
+			return nil, fmt.Errorf("%w: %s: duplicate username %q", ErrInvalidEnv, key, *u.username)
+		}
+		names[*u.username] = true
+
+		user := User{Username: *u.username}
+		if u.roles != nil {
+			user.Roles = strings.Fields(*u.roles)
+		}
+		switch {
+		case u.password != nil && u.pbkdf2 != nil:
+			return nil, fmt.Errorf("%w: %s: set only one of _PASSWORD or _PBKDF2", ErrInvalidEnv, key)
+		case u.password != nil:
+			if *u.password == "" {
+				return nil, fmt.Errorf("%w: %s_PASSWORD is empty", ErrInvalidEnv, key)
+			}
+			user.BasicAuthVerifier = BasicCredentials{Username: user.Username, Password: *u.password}
+		case u.pbkdf2 != nil:
+			c, err := ParsePBKDF2(*u.pbkdf2)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s_PBKDF2: %w", ErrInvalidEnv, key, err)
+			}
+			c.Username = user.Username
+			user.BasicAuthVerifier = c
+		default:
+			return nil, fmt.Errorf("%w: %s: set %s_PASSWORD or %s_PBKDF2", ErrInvalidEnv, key, key, key)
+		}
+		us = append(us, user)
+	}
+	return us, nil
+}
+
+// ParsePBKDF2 parses the output of the pbkdf2-sha256 tool, as one line or many:
+//
+//	salt       : i63wDd7K-60
+//	iterations : 1000
+//	derived-key: 553ce8846c2304e93021dab03bacb5ca
+//
+// The salt may be hex or url-safe base64, iterations defaults to 1000 (as in the tool),
+// and the key size is the length of the derived key. Any password line is ignored.
+func ParsePBKDF2(s string) (PBKDF2Credentials, error) {
+	c := PBKDF2Credentials{Iterations: 1000}
+
+	fields := strings.Fields(strings.ReplaceAll(s, ":", " : "))
+	for len(fields) > 0 {
+		if len(fields) < 3 || fields[1] != ":" {
+			return c, fmt.Errorf("expected 'name: value', got %q", strings.Join(fields, " "))
+		}
+		name, value := fields[0], fields[2]
+		fields = fields[3:]
+
+		var err error
+		switch name {
+		case "salt":
+			c.Salt, err = parseHexOrBase64(value)
+		case "iterations":
+			c.Iterations, err = strconv.Atoi(value)
+			if err == nil && c.Iterations < 1 {
+				err = errors.New("must be positive")
+			}
+		case "derived-key":
+			c.DerivedKey, err = hex.DecodeString(value)
+		case "key-size", "password":
+			// implied by the derived key, or not to be stored
+		default:
+			err = errors.New("unknown field")
+		}
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if len(c.Salt) == 0 {
+		return c, errors.New("missing salt")
+	}
+	if len(c.DerivedKey) == 0 {
+		return c, errors.New("missing derived-key")
+	}
+	return c, nil
+}
+
+// parseHexOrBase64 decodes the salt the same way as the pbkdf2-sha256 tool
+func parseHexOrBase64(data string) ([]byte, error) {
+	isHex := strings.Trim(data, "0123456789abcdefABCDEF") == ""
+	mixedCase := strings.ContainsAny(data, "ABCDEF") && strings.ContainsAny(data, "abcdef")
+	if isHex && !mixedCase {
+		return hex.DecodeString(data)
+	}
+
+	rfcData := strings.ReplaceAll(data, "-", "+")
+	rfcData = strings.ReplaceAll(rfcData, "_", "/")
+	rfcData = strings.ReplaceAll(rfcData, "=", "")
+	return base64.RawStdEncoding.DecodeString(rfcData)
+}
+
+var _ BasicAuthVerifier = Users(nil)
+var _ auth.BasicAuthenticator = Users(nil)
+var _ auth.BasicPrinciple = (*Principle)(nil)
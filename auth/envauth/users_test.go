@@ -0,0 +1,115 @@
+package envauth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseEnviron(t *testing.T) {
+	dk, _ := pbkdf2.Key(sha256.New, "ops-secret", salt, 1000, 16)
+	environ := []string{
+		"HOME=/root",
+		"BASIC_AUTH_USER_2=ops",
+		"BASIC_AUTH_USER_2_PBKDF2=salt       : " + hex.EncodeToString(salt) + "\niterations : 1000\nderived-key: " + hex.EncodeToString(dk) + "\n",
+		"BASIC_AUTH_USER_1=api",
+		"BASIC_AUTH_USER_1_PASSWORD=api-secret",
+		"BASIC_AUTH_USER_1_ROLES=GET:/reports/  admin",
+		"BASIC_AUTH_USERNAME=legacy",
+	}
+
+	users, err := ParseEnviron("", environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Username != "api" || users[1].Username != "ops" {
+		t.Fatalf("users: %+v", users)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		roles    []string
+		want     error
+	}{
+		{"password", "api", "api-secret", []string{"GET:/reports/", "admin"}, nil},
+		{"pbkdf2", "ops", "ops-secret", nil, nil},
+		{"another user's password", "ops", "api-secret", nil, ErrUnauthorized},
+		{"unknown user", "nobody", "api-secret", nil, ErrUnauthorized},
+		{"empty password", "api", "", nil, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := users.Authenticate(tt.username, tt.password)
+			if err != tt.want {
+				t.Fatalf("Authenticate(%q, %q) = %v; want %v", tt.username, tt.password, err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if p.ID() != tt.username || !slices.Equal(p.Permissions(), tt.roles) {
+				t.Errorf("principle: %q %q", p.ID(), p.Permissions())
+			}
+		})
+	}
+}
+
+func TestParseEnvironErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+	}{
+		{"no secret", []string{"APP_USER_1=api"}},
+		{"both secrets", []string{"APP_USER_1=api", "APP_USER_1_PASSWORD=x", "APP_USER_1_PBKDF2=salt: 00ff derived-key: 00ff"}},
+		{"empty password", []string{"APP_USER_1=api", "APP_USER_1_PASSWORD="}},
+		{"no username", []string{"APP_USER_1_PASSWORD=x"}},
+		{"duplicate", []string{"APP_USER_1=api", "APP_USER_1_PASSWORD=x", "APP_USER_2=api", "APP_USER_2_PASSWORD=y"}},
+		{"typo", []string{"APP_USER_1=api", "APP_USER_1_PASWORD=x"}},
+		{"not numbered", []string{"APP_USER_ONE=api"}},
+		{"bad pbkdf2", []string{"APP_USER_1=api", "APP_USER_1_PBKDF2=derived-key: zz salt: 00ff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEnviron("APP_USER_", tt.environ); !errors.Is(err, ErrInvalidEnv) {
+				t.Errorf("got %v; want ErrInvalidEnv", err)
+			}
+		})
+	}
+
+	users, err := ParseEnviron("APP_USER_", []string{"OTHER=1"})
+	if err != nil || len(users) != 0 {
+		t.Errorf("no users: %v %v", users, err)
+	}
+	if _, err := users.Authenticate("", "x"); err != ErrUnauthorized {
+		t.Errorf("no users: %v", err)
+	}
+}
+
+func TestParsePBKDF2(t *testing.T) {
+	// as in the README
+	tests := []string{
+		"salt       : i63wDd7K-60\niterations : 1000\nderived-key: 553ce8846c2304e93021dab03bacb5ca\n",
+		"derived-key: 553ce8846c2304e93021dab03bacb5ca salt: i63wDd7K-60",
+		"password   : secret\nsalt       : i63wDd7K-60\niterations : 1000\nkey-size   : 16\nderived-key: 553ce8846c2304e93021dab03bacb5ca\n\n",
+	}
+	for _, s := range tests {
+		c, err := ParsePBKDF2(s)
+		if err != nil {
+			t.Fatalf("%q: %v", s, err)
+		}
+		c.Username = "api"
+		if err := c.Verify("api", "secret"); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+
+	for _, s := range []string{"", "salt: i63wDd7K-60", "derived-key: 553c", "iterations: 0 salt: 00 derived-key: 00", "salt i63wDd7K-60"} {
+		if _, err := ParsePBKDF2(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
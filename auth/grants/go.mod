@@ -0,0 +1,3 @@
+module github.com/therootcompany/golib/auth/grants
+
+go 1.26.1
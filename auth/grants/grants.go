@@ -0,0 +1,217 @@
+// Package grants matches requests against grants in the form of ServeMux patterns
+// (though : is used instead of space, since space is used to separate grants):
+//
+//	[!][METHOD[,METHOD...]:][HOST]/[PATH]
+//
+// For example "/" or "*" (everything), "GET:/", "GET,POST:api.example.com/users/{id}",
+// and "/api/{ver}/items/{$}". A grant starting with ! denies what it matches, and a
+// deny always wins over an allow, so "/" with "!DELETE:/admin/" allows everything
+// except deleting things under /admin/.
+package grants
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoGrants         = errors.New("no grants")
+	ErrNoMatch          = errors.New("no grant matches the request")
+	ErrMethodNotAllowed = errors.New("no grant allows the method for the path")
+	ErrDenied           = errors.New("denied by grant")
+)
+
+// Result is the reason a grant does or doesn't match a request
+type Result int
+
+const (
+	NoMatch        Result = iota // the host or path doesn't match (or the grant is invalid)
+	MethodMismatch               // the host and path match, but the method doesn't
+	Matched
+)
+
+func (res Result) String() string {
+	switch res {
+	case Matched:
+		return "match"
+	case MethodMismatch:
+		return "method mismatch"
+	default:
+		return "no match"
+	}
+}
+
+// Decision is the outcome of Authorize
+type Decision struct {
+	Grant string   // the grant that allowed (or denied) the request
+	Allow []string // with ErrMethodNotAllowed, the methods allowed for the path (for the Allow header)
+}
+
+// Authorize checks the request against all of the grants. Deny grants are checked first.
+//
+// It returns the grant that allows the request, or else ErrDenied (wrapped with the grant),
+// ErrMethodNotAllowed (with the methods that are allowed for the path), ErrNoMatch, or ErrNoGrants.
+func Authorize(grants []string, method, host, path string) (Decision, error) {
+	if len(grants) == 0 {
+		return Decision{}, ErrNoGrants
+	}
+
+	var denied []string
+	for _, grant := range grants {
+		grant = strings.TrimSpace(grant)
+		deny, ok := strings.CutPrefix(grant, "!")
+		if !ok {
+			continue
+		}
+		switch Match(deny, method, host, path) {
+		case Matched:
+			return Decision{Grant: grant}, fmt.Errorf("%w %q", ErrDenied, grant)
+		case MethodMismatch:
+			// the methods that this grant denies for the path
+			methods, _, _ := strings.Cut(deny, ":")
+			denied = append(denied, strings.Split(strings.ToUpper(methods), ",")...)
+		}
+	}
+
+	var allow []string
+	for _, grant := range grants {
+		grant = strings.TrimSpace(grant)
+		if strings.HasPrefix(grant, "!") {
+			continue
+		}
+		switch Match(grant, method, host, path) {
+		case Matched:
+			return Decision{Grant: grant}, nil
+		case MethodMismatch:
+			methods, _, _ := strings.Cut(grant, ":")
+			for m := range strings.SplitSeq(strings.ToUpper(methods), ",") {
+				if !slices.Contains(allow, m) && !slices.Contains(denied, m) {
+					allow = append(allow, m)
+				}
+			}
+		}
+	}
+
+	if len(allow) > 0 {
+		return Decision{Allow: allow}, ErrMethodNotAllowed
+	}
+	return Decision{}, ErrNoMatch
+}
+
+// Match checks a single grant (without the ! prefix) against a request.
+//
+// method must be ALL_CAPS, host may be HOSTNAME or HOSTNAME:PORT, and path must start with /
+func Match(grant, method, host, path string) Result {
+	// this should have been done already, but...
+	grant = strings.TrimSpace(grant)
+
+	// must have at least /
+	if grant == "" {
+		return NoMatch
+	}
+	if grant == "*" {
+		return Matched
+	}
+
+	// / => [] "/"
+	// /path => [] "/path"
+	// example.com/path => [] "example.com/path"
+	// GET,POST:example.com/path => ["GET", "POST"] "example.com/path"
+	var methods []string
+	if m, rest, ok := strings.Cut(grant, ":"); ok {
+		methods = strings.Split(strings.ToUpper(m), ",")
+		grant = rest
+	}
+
+	// / => /
+	// /path => /path
+	// example.com/path => /path
+	idx := strings.Index(grant, "/")
+	if idx < 0 {
+		// host without path is invalid
+		return NoMatch
+	}
+	if hostname := grant[:idx]; hostname != "" {
+		// example.com:443 => example.com
+		if h, _, _ := strings.Cut(host, ":"); hostname != h {
+			return NoMatch
+		}
+	}
+
+	if !matchPath(grant[idx:], path) {
+		return NoMatch
+	}
+	if methods != nil && !slices.Contains(methods, method) {
+		return MethodMismatch
+	}
+	return Matched
+}
+
+// matchPath matches in the manner of a ServeMux pattern, by segment:
+//
+//	/path matches /path, /path/, and /path/foo/bar
+//	/path/{var} and /path/{var...} match /path, /path/, and /path/foo/bar
+//	/path/{var}/bar matches /path/foo/bar and /path/foo/bar/baz, but not /path/foo
+//	/path/{var}/{$} matches /path/foo and /path/foo/, but not /path/foo/bar
+func matchPath(pattern, path string) bool {
+	nextGPath, gstop := iter.Pull(strings.SplitSeq(pattern, "/"))
+	nextRPath, rstop := iter.Pull(strings.SplitSeq(path, "/"))
+	defer gstop()
+	defer rstop()
+
+	for {
+		gp, gok := nextGPath()
+		rp, rok := nextRPath()
+		// everything has matched thus far, and the pattern has ended
+		if !gok {
+			return true
+		}
+
+		// false unless the extra length of the pattern signifies the exact match, disregarding trailing /
+		if !rok {
+			// this matches trailing /, {var}, {var}/, {var...}, and {$}
+			if gp == "" || isPlaceholder(gp) {
+				gp2, more := nextGPath()
+				// this allows for one more final trailing /, but nothing else
+				if !more {
+					return true
+				}
+				if gp2 == "" {
+					// two trailing slashes are not allowed
+					_, more := nextGPath()
+					return !more
+				}
+			}
+			return false
+		}
+
+		// path parts are only allowed to disagree for trailing slashes and variables
+		if gp != rp {
+			// this allows for one more final trailing / on the pattern, but nothing else
+			if gp == "" {
+				_, more := nextGPath()
+				return !more
+			}
+			if !isPlaceholder(gp) {
+				return false
+			}
+			// normal variables pass
+			if gp != "{$}" {
+				continue
+			}
+			// trailing slash on exact match passes
+			if rp == "" {
+				_, more := nextRPath()
+				return !more
+			}
+			return false
+		}
+	}
+}
+
+func isPlaceholder(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
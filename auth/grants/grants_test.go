@@ -1,12 +1,14 @@
-package main
+package grants
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"testing"
 )
 
-func TestMatchPattern(t *testing.T) {
+func TestMatch(t *testing.T) {
 	tests := []struct {
 		grant  string
 		method string
@@ -96,17 +98,84 @@ func TestMatchPattern(t *testing.T) {
 		{"example.com", "GET", "example.com", "/", false},
 		{"GET:example.com", "GET", "example.com", "/", false},
 		{"GET:/users ", "GET", "", "/users", true},
+		{"*", "DELETE", "example.com", "/anything", true},
 	}
 
 	for _, tt := range tests {
 		// name := "Pattern " + tt.grant + " vs URI " + strings.TrimSpace(fmt.Sprintf("%s %s%s", tt.method, tt.host, tt.path))
 		name := tt.grant + " vs " + strings.TrimSpace(fmt.Sprintf("%s %s%s", tt.method, tt.host, tt.path))
 		t.Run(name, func(t *testing.T) {
-			got := matchPattern(tt.grant, tt.method, tt.host, tt.path)
+			got := Match(tt.grant, tt.method, tt.host, tt.path) == Matched
 			if got != tt.want {
-				t.Errorf("matchPattern(%q, %q, %q, %q) = %v, want %v",
+				t.Errorf("Match(%q, %q, %q, %q) = %v, want %v",
 					tt.grant, tt.method, tt.host, tt.path, got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMatchResult(t *testing.T) {
+	tests := []struct {
+		grant  string
+		method string
+		path   string
+		want   Result
+	}{
+		{"/", "DELETE", "/admin", Matched},
+		{"GET:/", "POST", "/", MethodMismatch},
+		{"GET,PUT:/users/{id}", "DELETE", "/users/123", MethodMismatch},
+		{"GET:/users/{id}/{$}", "DELETE", "/users/123/friends", NoMatch},
+		{"GET:example.com/", "POST", "/", NoMatch}, // the host doesn't match
+		{"GET:", "POST", "/", NoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.grant+" vs "+tt.method+" "+tt.path, func(t *testing.T) {
+			if got := Match(tt.grant, tt.method, "", tt.path); got != tt.want {
+				t.Errorf("Match(%q, %q, %q) = %s, want %s", tt.grant, tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		grants  []string
+		method  string
+		path    string
+		grant   string
+		allow   []string
+		wantErr error
+	}{
+		{"allow", []string{"GET:/"}, "GET", "/", "GET:/", nil, nil},
+		{"first match", []string{"GET:/api/", "/"}, "GET", "/api/users", "GET:/api/", nil, nil},
+		{"no grants", nil, "GET", "/", "", nil, ErrNoGrants},
+		{"no match", []string{"/public/"}, "GET", "/private", "", nil, ErrNoMatch},
+		{"method not allowed", []string{"GET:/api/", "PUT,GET:/api/users/"}, "DELETE", "/api/users/123", "", []string{"GET", "PUT"}, ErrMethodNotAllowed},
+		{"method not allowed elsewhere", []string{"GET:/api/", "POST:/other/"}, "DELETE", "/api/users", "", []string{"GET"}, ErrMethodNotAllowed},
+		{"deny wins", []string{"/", "!DELETE:/admin/"}, "DELETE", "/admin/users", "!DELETE:/admin/", nil, ErrDenied},
+		{"deny wins in any order", []string{"!DELETE:/admin/", "/"}, "DELETE", "/admin/users", "!DELETE:/admin/", nil, ErrDenied},
+		{"deny other method", []string{"/", "!DELETE:/admin/"}, "GET", "/admin/users", "/", nil, nil},
+		{"deny other path", []string{"/", "!DELETE:/admin/"}, "DELETE", "/users/123", "/", nil, nil},
+		{"deny everything", []string{"*", "!/secret/"}, "GET", "/secret/plans", "!/secret/", nil, ErrDenied},
+		{"denied methods are not allowed", []string{"GET,DELETE:/admin/", "!DELETE:/admin/"}, "POST", "/admin/", "", []string{"GET"}, ErrMethodNotAllowed},
+		{"only denied methods", []string{"DELETE:/admin/", "!DELETE:/admin/"}, "POST", "/admin/", "", nil, ErrNoMatch},
+		{"deny only", []string{"!DELETE:/admin/"}, "GET", "/admin/", "", nil, ErrNoMatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Authorize(tt.grants, tt.method, "example.com", tt.path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if d.Grant != tt.grant {
+				t.Errorf("grant = %q, want %q", d.Grant, tt.grant)
+			}
+			if !slices.Equal(d.Allow, tt.allow) {
+				t.Errorf("allow = %q, want %q", d.Allow, tt.allow)
+			}
+		})
+	}
+}
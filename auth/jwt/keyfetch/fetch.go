@@ -256,6 +256,43 @@ func FetchOIDC(ctx context.Context, baseURL string, client *http.Client) ([]jwt.
 	return FetchURL(ctx, jwksURI, client)
 }
 
+// OIDCDiscovery holds the endpoints of an OIDC discovery document.
+//
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKsURI               string `json:"jwks_uri"`
+}
+
+// FetchOIDCDiscovery fetches the discovery document of an OIDC issuer, from
+// {issuerURL}/.well-known/openid-configuration.
+//
+// Its issuer must be issuerURL, exactly (OIDC Discovery §4.3). Its jwks_uri is
+// required, and it and the other endpoints (if given) must use HTTPS.
+//
+// client is used for the request; if nil, a default 30s-timeout client is used.
+func FetchOIDCDiscovery(ctx context.Context, issuerURL string, client *http.Client) (*OIDCDiscovery, error) {
+	discoveryURL := strings.TrimRight(issuerURL, "/") + "/.well-known/openid-configuration"
+	var doc OIDCDiscovery
+	if err := fetchDiscovery(ctx, discoveryURL, client, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Issuer != issuerURL {
+		return nil, fmt.Errorf("discovery doc issuer %q does not match %q: %w", doc.Issuer, issuerURL, ErrFetchFailed)
+	}
+	if err := checkJWKsURI(doc.JWKsURI); err != nil {
+		return nil, err
+	}
+	for _, endpoint := range []string{doc.AuthorizationEndpoint, doc.TokenEndpoint} {
+		if endpoint != "" && !strings.HasPrefix(endpoint, "https://") {
+			return nil, fmt.Errorf("discovery doc endpoints must be https, got %q: %w", endpoint, ErrFetchFailed)
+		}
+	}
+	return &doc, nil
+}
+
 // FetchOAuth2 fetches JWKS via OAuth 2.0 authorization server metadata from the
 // given base URL.
 //
@@ -278,25 +315,41 @@ func FetchOAuth2(ctx context.Context, baseURL string, client *http.Client) ([]jw
 // jwks_uri from it. The URI is required to use HTTPS to prevent SSRF via a
 // malicious discovery document pointing at an internal endpoint.
 func fetchDiscoveryURI(ctx context.Context, discoveryURL string, client *http.Client) (string, error) {
+	var doc struct {
+		JWKsURI string `json:"jwks_uri"`
+	}
+	if err := fetchDiscovery(ctx, discoveryURL, client, &doc); err != nil {
+		return "", err
+	}
+	if err := checkJWKsURI(doc.JWKsURI); err != nil {
+		return "", err
+	}
+	return doc.JWKsURI, nil
+}
+
+// fetchDiscovery fetches a discovery document and decodes it into doc
+func fetchDiscovery(ctx context.Context, discoveryURL string, client *http.Client, doc any) error {
 	resp, err := doGET(ctx, discoveryURL, client, nil)
 	if err != nil {
-		return "", fmt.Errorf("fetch discovery: %w", err)
+		return fmt.Errorf("fetch discovery: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
-	var doc struct {
-		JWKsURI string `json:"jwks_uri"`
-	}
-	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBody)).Decode(&doc); err != nil {
-		return "", fmt.Errorf("parse discovery doc: %w: %w", ErrFetchFailed, err)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBody)).Decode(doc); err != nil {
+		return fmt.Errorf("parse discovery doc: %w: %w", ErrFetchFailed, err)
 	}
-	if doc.JWKsURI == "" {
-		return "", fmt.Errorf("discovery doc missing jwks_uri: %w", ErrFetchFailed)
+	return nil
+}
+
+// checkJWKsURI requires a jwks_uri, and HTTPS
+func checkJWKsURI(jwksURI string) error {
+	if jwksURI == "" {
+		return fmt.Errorf("discovery doc missing jwks_uri: %w", ErrFetchFailed)
 	}
-	if !strings.HasPrefix(doc.JWKsURI, "https://") {
-		return "", fmt.Errorf("jwks_uri must be https, got %q: %w", doc.JWKsURI, ErrFetchFailed)
+	if !strings.HasPrefix(jwksURI, "https://") {
+		return fmt.Errorf("jwks_uri must be https, got %q: %w", jwksURI, ErrFetchFailed)
 	}
-	return doc.JWKsURI, nil
+	return nil
 }
 
 // doGET performs an HTTP GET request and returns the response. It follows
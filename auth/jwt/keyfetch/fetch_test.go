@@ -177,6 +177,78 @@ func TestFetchOIDC_NonHTTPSJwksURI(t *testing.T) {
 	}
 }
 
+func TestNewOIDCKeyFetcher(t *testing.T) {
+	pub, jwksData := testJWKS(t)
+
+	var srvURL string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			doc := fmt.Sprintf(`{"issuer": "%s/", "jwks_uri": "%s/jwks.json"}`, srvURL, srvURL)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(doc))
+		case "/jwks.json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(jwksData)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	f, err := NewOIDCKeyFetcher(context.Background(), srv.URL+"/", srv.Client())
+	if err != nil {
+		t.Fatalf("NewOIDCKeyFetcher: %v", err)
+	}
+	if f.URL != srvURL+"/jwks.json" {
+		t.Errorf("URL = %q, want %q", f.URL, srvURL+"/jwks.json")
+	}
+
+	v, err := f.Verifier()
+	if err != nil {
+		t.Fatalf("Verifier: %v", err)
+	}
+	if keys := v.PublicKeys(); len(keys) != 1 || keys[0].KID != pub.KID {
+		t.Errorf("expected key %q, got %v", pub.KID, keys)
+	}
+}
+
+func TestFetchOIDCDiscovery(t *testing.T) {
+	var doc string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(doc))
+	}))
+	defer srv.Close()
+
+	doc = fmt.Sprintf(`{"issuer": %q, "authorization_endpoint": "%s/authorize", "token_endpoint": "%s/token", "jwks_uri": "%s/jwks.json"}`,
+		srv.URL, srv.URL, srv.URL, srv.URL)
+	got, err := FetchOIDCDiscovery(context.Background(), srv.URL, srv.Client())
+	if err != nil {
+		t.Fatalf("FetchOIDCDiscovery: %v", err)
+	}
+	if got.AuthorizationEndpoint != srv.URL+"/authorize" || got.TokenEndpoint != srv.URL+"/token" || got.JWKsURI != srv.URL+"/jwks.json" {
+		t.Errorf("got %+v", got)
+	}
+
+	bad := []struct {
+		name string
+		doc  string
+	}{
+		{"other issuer", fmt.Sprintf(`{"issuer": "https://evil.example.com", "jwks_uri": "%s/jwks.json"}`, srv.URL)},
+		{"no issuer", fmt.Sprintf(`{"jwks_uri": "%s/jwks.json"}`, srv.URL)},
+		{"no jwks_uri", fmt.Sprintf(`{"issuer": %q}`, srv.URL)},
+		{"http token_endpoint", fmt.Sprintf(`{"issuer": %q, "token_endpoint": "http://example.com/token", "jwks_uri": "%s/jwks.json"}`, srv.URL, srv.URL)},
+	}
+	for _, tt := range bad {
+		doc = tt.doc
+		if _, err := FetchOIDCDiscovery(context.Background(), srv.URL, srv.Client()); !errorContains(err, ErrFetchFailed) {
+			t.Errorf("%s: expected ErrFetchFailed, got: %v", tt.name, err)
+		}
+	}
+}
+
 // --- FetchOAuth2 tests ---
 
 func TestFetchOAuth2_Success(t *testing.T) {
@@ -143,6 +143,32 @@ func NewKeyFetcher(jwksURL string) (*KeyFetcher, error) {
 	return &KeyFetcher{URL: jwksURL}, nil
 }
 
+// NewOIDCKeyFetcher creates a [KeyFetcher] for an OIDC issuer by reading
+// jwks_uri from its discovery document (see [FetchOIDCDiscovery]). The
+// document is fetched once, here; the keys are fetched lazily as with
+// [NewKeyFetcher].
+//
+// client is used for discovery and is kept as the fetcher's HTTPClient;
+// if nil, a default 30s-timeout client is used.
+func NewOIDCKeyFetcher(ctx context.Context, issuerURL string, client *http.Client) (*KeyFetcher, error) {
+	doc, err := FetchOIDCDiscovery(ctx, issuerURL, client)
+	if err != nil {
+		return nil, err
+	}
+	return doc.NewKeyFetcher(client)
+}
+
+// NewKeyFetcher creates a [KeyFetcher] for the document's jwks_uri, with
+// client as its HTTPClient, for a caller that also needs the other endpoints.
+func (doc *OIDCDiscovery) NewKeyFetcher(client *http.Client) (*KeyFetcher, error) {
+	f, err := NewKeyFetcher(doc.JWKsURI)
+	if err != nil {
+		return nil, err
+	}
+	f.HTTPClient = client
+	return f, nil
+}
+
 // RefreshedAt returns the time the cached keys were last successfully
 // fetched. Returns the zero time if no fetch has completed yet.
 //
@@ -0,0 +1,216 @@
+// Package jwtauth verifies bearer JWTs as an auth.BasicAuthenticator,
+// so that anything built on auth.BasicRequestAuthenticator (and csvauth)
+// can also accept tokens from an OIDC / OAuth2 issuer.
+//
+//	ja, err := jwtauth.NewFromIssuer(ctx, "https://accounts.example.com", nil, nil)
+//	ra := auth.NewBasicRequestAuthenticator(ja)
+//
+// The principle's ID() is the token's sub, and its Permissions() are the
+// token's scope, or the values of RolesClaim when set.
+package jwtauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/jwt"
+	"github.com/therootcompany/golib/auth/jwt/keyfetch"
+)
+
+var (
+	// ErrNotJWT is returned when the credentials are not in the form of a
+	// bearer token (e.g. a username with a password), so that other
+	// authenticators may be tried (see auth.BasicAuthenticators)
+	ErrNotJWT = errors.New("not a JWT")
+
+	// ErrUnauthorized wraps signature and claim validation failures
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// VerifierSource returns the current verifier, such as a *keyfetch.KeyFetcher
+type VerifierSource interface {
+	Verifier() (*jwt.Verifier, error)
+}
+
+type staticVerifier struct {
+	verifier *jwt.Verifier
+}
+
+func (s staticVerifier) Verifier() (*jwt.Verifier, error) {
+	return s.verifier, nil
+}
+
+// Auth verifies and validates JWTs
+type Auth struct {
+	Keys      VerifierSource
+	Validator *jwt.Validator
+
+	// RolesClaim names the claim to use for Permissions() instead of "scope".
+	// Nested claims may be given by path, such as "realm_access.roles".
+	// The claim may be a list of strings, or a space- or comma-delimited string.
+	RolesClaim string
+
+	// BasicAuthTokenNames are the Basic Auth usernames that may carry a JWT
+	// as the password (a JWT as the username with an empty password is always accepted)
+	BasicAuthTokenNames []string
+}
+
+// New returns an Auth that verifies tokens with keys from src
+// (a *keyfetch.KeyFetcher, for example) and validates their claims with v
+func New(src VerifierSource, v *jwt.Validator) *Auth {
+	return &Auth{
+		Keys:                src,
+		Validator:           v,
+		BasicAuthTokenNames: []string{"", "api", "apikey"},
+	}
+}
+
+// NewWithVerifier returns an Auth that verifies tokens with a fixed set of keys
+func NewWithVerifier(verifier *jwt.Verifier, v *jwt.Validator) *Auth {
+	return New(staticVerifier{verifier}, v)
+}
+
+// NewFromIssuer discovers the issuer's keys via OIDC discovery and returns an
+// Auth that requires iss to be issuerURL (exactly as given), a sub, and an
+// unexpired exp. If audiences is non-empty, aud must contain one of them.
+//
+// client is used for discovery and key fetches; if nil, a default is used.
+func NewFromIssuer(ctx context.Context, issuerURL string, audiences []string, client *http.Client) (*Auth, error) {
+	f, err := keyfetch.NewOIDCKeyFetcher(ctx, issuerURL, client)
+	if err != nil {
+		return nil, fmt.Errorf("discover keys for %q: %w", issuerURL, err)
+	}
+
+	v := &jwt.Validator{
+		Checks: jwt.ChecksConfigured | jwt.CheckIss | jwt.CheckSub | jwt.CheckExp | jwt.CheckIAt,
+		Iss:    []string{issuerURL},
+	}
+	if len(audiences) > 0 {
+		v.Checks |= jwt.CheckAud
+		v.Aud = audiences
+	}
+
+	return New(f, v), nil
+}
+
+// Principle is a verified token's claims, and the permissions read from them
+type Principle struct {
+	Claims jwt.StandardClaims
+	Roles  []string
+}
+
+// ID returns the token's sub
+func (p *Principle) ID() string {
+	return p.Claims.Sub
+}
+
+// Permissions returns the token's scope, or the values of the roles claim
+func (p *Principle) Permissions() []string {
+	return p.Roles
+}
+
+// Authenticate verifies a JWT given as the secret (with an empty name, or one of
+// BasicAuthTokenNames), or as the name with an empty secret.
+func (a *Auth) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	if secret == "" {
+		secret, name = name, secret
+	}
+	if secret == "" || !slices.Contains(a.BasicAuthTokenNames, name) {
+		return nil, ErrNotJWT
+	}
+	if strings.Count(secret, ".") != 2 {
+		return nil, ErrNotJWT
+	}
+
+	verifier, err := a.Keys.Verifier()
+	if err != nil {
+		return nil, err
+	}
+	jws, err := verifier.VerifyJWT(secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	p := &Principle{}
+	if err := jws.UnmarshalClaims(&p.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	if err := a.Validator.Validate(nil, &p.Claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	switch a.RolesClaim {
+	case "", "scope":
+		p.Roles = p.Claims.Scope
+	default:
+		p.Roles, err = ClaimStrings(jws.GetPayload(), a.RolesClaim)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Verify is the same as Authenticate, but without returning the principle
+func (a *Auth) Verify(name, secret string) error {
+	_, err := a.Authenticate(name, secret)
+	return err
+}
+
+// ClaimStrings reads a list of strings, or a space- or comma-delimited string,
+// from a (verified) JWT's base64url payload (see jwt.RawJWT.GetPayload).
+// The claim is found by its exact name (which may itself contain dots, such as
+// "https://example.com/roles") or else by its dot-separated path (such as
+// "realm_access.roles"). A missing claim is not an error.
+func ClaimStrings(payload64 []byte, claim string) ([]string, error) {
+	payload, err := base64.RawURLEncoding.AppendDecode(nil, payload64)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+
+	v, ok := claims[claim]
+	if !ok {
+		var node any = claims
+		for key := range strings.SplitSeq(claim, ".") {
+			m, _ := node.(map[string]any)
+			node = m[key]
+		}
+		v = node
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' }), nil
+	case []any:
+		roles := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("claim %q: expected a list of strings, got %T", claim, item)
+			}
+			roles = append(roles, s)
+		}
+		return roles, nil
+	default:
+		return nil, fmt.Errorf("claim %q: expected a string or list of strings, got %T", claim, v)
+	}
+}
+
+var _ auth.BasicAuthenticator = (*Auth)(nil)
+var _ auth.BasicVerifier = (*Auth)(nil)
+var _ VerifierSource = (*keyfetch.KeyFetcher)(nil)
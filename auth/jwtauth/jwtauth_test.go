@@ -0,0 +1,231 @@
+package jwtauth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/jwt"
+	"github.com/therootcompany/golib/auth/jwtauth"
+)
+
+type testClaims struct {
+	jwt.StandardClaims
+	RealmAccess struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access,omitzero"`
+	Groups     string   `json:"groups,omitempty"`
+	Namespaced []string `json:"https://example.com/roles,omitempty"`
+}
+
+func newSigner(t *testing.T) *jwt.Signer {
+	t.Helper()
+	pk, err := jwt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jwt.NewSigner([]*jwt.PrivateKey{pk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newClaims(iss, sub string) *testClaims {
+	now := time.Now()
+	c := &testClaims{}
+	c.Iss = iss
+	c.Sub = sub
+	c.Aud = jwt.Listish{"auth-proxy"}
+	c.IAt = now.Unix()
+	c.Exp = now.Add(time.Hour).Unix()
+	c.Scope = jwt.SpaceDelimited{"GET:/", "POST:/logs"}
+	return c
+}
+
+func sign(t *testing.T, signer *jwt.Signer, c *testClaims) string {
+	t.Helper()
+	token, err := signer.SignToString(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	const iss = "https://accounts.example.com"
+	signer := newSigner(t)
+	validator := jwt.NewIDTokenValidator([]string{iss}, []string{"auth-proxy"}, nil)
+	validator.Checks &^= jwt.CheckAuthTime
+	a := jwtauth.NewWithVerifier(signer.Verifier(), validator)
+
+	c := newClaims(iss, "user-123")
+	c.Email = "jane@example.com"
+	token := sign(t, signer, c)
+
+	for _, creds := range [][2]string{{"", token}, {token, ""}, {"api", token}} {
+		p, err := a.Authenticate(creds[0], creds[1])
+		if err != nil {
+			t.Fatalf("Authenticate(%.8q, %.8q): %v", creds[0], creds[1], err)
+		}
+		if p.ID() != "user-123" {
+			t.Errorf("ID() = %q, want %q", p.ID(), "user-123")
+		}
+		if !slices.Equal(p.Permissions(), []string{"GET:/", "POST:/logs"}) {
+			t.Errorf("Permissions() = %q", p.Permissions())
+		}
+		if email := p.(*jwtauth.Principle).Claims.Email; email != "jane@example.com" {
+			t.Errorf("Email = %q", email)
+		}
+	}
+
+	if _, err := a.Authenticate("jane", "password"); !errors.Is(err, jwtauth.ErrNotJWT) {
+		t.Errorf("username and password: got %v, want ErrNotJWT", err)
+	}
+	if _, err := a.Authenticate("", "not-a-jwt"); !errors.Is(err, jwtauth.ErrNotJWT) {
+		t.Errorf("opaque token: got %v, want ErrNotJWT", err)
+	}
+
+	otherSigner := newSigner(t)
+	if _, err := a.Authenticate("", sign(t, otherSigner, c)); !errors.Is(err, jwtauth.ErrUnauthorized) {
+		t.Errorf("unknown key: got %v, want ErrUnauthorized", err)
+	}
+
+	expired := newClaims(iss, "user-123")
+	expired.Exp = time.Now().Add(-time.Hour).Unix()
+	if _, err := a.Authenticate("", sign(t, signer, expired)); !errors.Is(err, jwt.ErrAfterExp) {
+		t.Errorf("expired: got %v, want ErrAfterExp", err)
+	}
+
+	wrongIss := newClaims("https://evil.example.com", "user-123")
+	if _, err := a.Authenticate("", sign(t, signer, wrongIss)); !errors.Is(err, jwtauth.ErrUnauthorized) {
+		t.Errorf("wrong issuer: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestRolesClaim(t *testing.T) {
+	const iss = "https://accounts.example.com"
+	signer := newSigner(t)
+	validator := jwt.NewAccessTokenValidator([]string{iss}, nil)
+	validator.Checks &^= jwt.CheckJTI | jwt.CheckClientID
+
+	c := newClaims(iss, "svc-1")
+	c.RealmAccess.Roles = []string{"GET:/metrics"}
+	c.Groups = "GET:/a, GET:/b"
+	c.Namespaced = []string{"/"}
+	token := sign(t, signer, c)
+
+	tests := []struct {
+		claim string
+		want  []string
+	}{
+		{"", []string{"GET:/", "POST:/logs"}},
+		{"realm_access.roles", []string{"GET:/metrics"}},
+		{"groups", []string{"GET:/a", "GET:/b"}},
+		{"https://example.com/roles", []string{"/"}},
+		{"missing", nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.claim, func(t *testing.T) {
+			a := jwtauth.NewWithVerifier(signer.Verifier(), validator)
+			a.RolesClaim = tc.claim
+			p, err := a.Authenticate("", token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(p.Permissions(), tc.want) {
+				t.Errorf("Permissions() = %q, want %q", p.Permissions(), tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFromIssuer(t *testing.T) {
+	signer := newSigner(t)
+	jwks, err := json.Marshal(jwt.WellKnownJWKs{Keys: signer.Keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var issuer string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%s/jwks.json"}`, issuer, issuer)
+		case "/jwks.json":
+			_, _ = w.Write(jwks)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	issuer = srv.URL
+
+	a, err := jwtauth.NewFromIssuer(t.Context(), issuer, []string{"auth-proxy"}, srv.Client())
+	if err != nil {
+		t.Fatalf("NewFromIssuer: %v", err)
+	}
+
+	token := sign(t, signer, newClaims(issuer, "user-123"))
+	if _, err := a.Authenticate("", token); err != nil {
+		t.Errorf("Authenticate: %v", err)
+	}
+
+	otherAud := newClaims(issuer, "user-123")
+	otherAud.Aud = jwt.Listish{"someone-else"}
+	if _, err := a.Authenticate("", sign(t, signer, otherAud)); !errors.Is(err, jwtauth.ErrUnauthorized) {
+		t.Errorf("wrong audience: got %v, want ErrUnauthorized", err)
+	}
+}
+
+// staticCreds is a toy username/password store, standing in for csvauth
+type staticCreds map[string]string
+
+type staticPrinciple string
+
+func (p staticPrinciple) ID() string            { return string(p) }
+func (p staticPrinciple) Permissions() []string { return []string{"/"} }
+
+func (s staticCreds) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	if pass, ok := s[name]; ok && pass == secret {
+		return staticPrinciple(name), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestBasicAuthenticators(t *testing.T) {
+	const iss = "https://accounts.example.com"
+	signer := newSigner(t)
+	validator := jwt.NewAccessTokenValidator([]string{iss}, nil)
+	validator.Checks &^= jwt.CheckJTI | jwt.CheckClientID
+
+	ra := auth.NewBasicRequestAuthenticator(auth.BasicAuthenticators{
+		staticCreds{"jane": "secret"},
+		jwtauth.NewWithVerifier(signer.Verifier(), validator),
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("jane", "secret")
+	if p, err := ra.Authenticate(r); err != nil || p.ID() != "jane" {
+		t.Errorf("basic auth: got %v, %v", p, err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, signer, newClaims(iss, "user-123")))
+	if p, err := ra.Authenticate(r); err != nil || p.ID() != "user-123" {
+		t.Errorf("bearer jwt: got %v, %v", p, err)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("jane", "wrong")
+	if _, err := ra.Authenticate(r); !errors.Is(err, jwtauth.ErrNotJWT) {
+		t.Errorf("bad password: got %v, want joined errors including ErrNotJWT", err)
+	}
+}
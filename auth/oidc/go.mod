@@ -0,0 +1,17 @@
+module github.com/therootcompany/golib/auth/oidc
+
+go 1.26.1
+
+require (
+	github.com/therootcompany/golib/auth v1.1.1
+	github.com/therootcompany/golib/auth/jwt v1.0.0
+	github.com/therootcompany/golib/auth/jwtauth v1.0.0
+	github.com/therootcompany/golib/auth/session v1.0.0
+)
+
+replace (
+	github.com/therootcompany/golib/auth => ../
+	github.com/therootcompany/golib/auth/jwt => ../jwt
+	github.com/therootcompany/golib/auth/jwtauth => ../jwtauth
+	github.com/therootcompany/golib/auth/session => ../session
+)
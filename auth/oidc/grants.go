@@ -0,0 +1,77 @@
+package oidc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Grants maps users to permissions (such as auth-proxy's "[METHOD:][HOST]/[PATH]"
+// patterns) by any of these keys:
+//
+//	jane@example.com  # a verified email
+//	@example.com      # any verified email at the domain
+//	group:admins      # a member of the group (see Config.GroupsClaim)
+//	sub:1234567890    # the issuer's subject id
+//	*                 # any user who can log in with the issuer
+//
+// A user's permissions are the union of every matching entry.
+type Grants map[string][]string
+
+// ParseGrants reads one entry per line, as the key followed by its
+// whitespace-separated permissions. Blank lines and # comments are skipped.
+//
+//	jane@example.com  /
+//	group:ops         GET:/ POST:/deploy
+func ParseGrants(r io.Reader) (Grants, error) {
+	g := Grants{}
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n += 1
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("grants line %d: %q has no permissions", n, fields[0])
+		}
+		key := fields[0]
+		if !strings.HasPrefix(key, "group:") && !strings.HasPrefix(key, "sub:") {
+			key = strings.ToLower(key)
+		}
+		g[key] = append(g[key], fields[1:]...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Permissions returns the union of the permissions granted to p
+func (g Grants) Permissions(p *Principle) []string {
+	var keys []string
+	if email := p.VerifiedEmail(); email != "" {
+		keys = append(keys, email)
+		if _, domain, ok := strings.Cut(email, "@"); ok {
+			keys = append(keys, "@"+domain)
+		}
+	}
+	for _, group := range p.Groups {
+		keys = append(keys, "group:"+group)
+	}
+	keys = append(keys, "sub:"+p.Claims.Sub, "*")
+
+	var perms []string
+	for _, key := range keys {
+		for _, perm := range g[key] {
+			if !slices.Contains(perms, perm) {
+				perms = append(perms, perm)
+			}
+		}
+	}
+	return perms
+}
@@ -0,0 +1,361 @@
+// Package oidc is an OpenID Connect relying party: it logs users in with an
+// issuer such as Google or Keycloak (authorization code flow, with PKCE,
+// state, and nonce), verifies the ID Token, and issues a local session.
+//
+//	sm, err := session.NewEncrypted(nil, key)
+//	rp, err := oidc.New(ctx, oidc.Config{
+//		Issuer:       "https://accounts.google.com",
+//		ClientID:     clientID,
+//		ClientSecret: clientSecret,
+//		RedirectURL:  "https://dash.example.com/oidc/callback",
+//	}, sm)
+//	rp.Grants = grants // maps emails, domains, and groups to permissions
+//
+//	mux.Handle("GET /oidc/login", rp.LoginHandler())
+//	mux.Handle("GET /oidc/callback", rp.CallbackHandler())
+//
+// Keys are discovered and cached with keyfetch, and ID Tokens are checked
+// with jwt.NewIDTokenValidator.
+package oidc
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/jwt"
+	"github.com/therootcompany/golib/auth/jwt/keyfetch"
+	"github.com/therootcompany/golib/auth/jwtauth"
+	"github.com/therootcompany/golib/auth/session"
+)
+
+var (
+	// ErrDiscovery is returned when the issuer's discovery document is unusable
+	ErrDiscovery = errors.New("oidc discovery failed")
+
+	// ErrTokenExchange is returned when the code could not be exchanged for an ID Token
+	ErrTokenExchange = errors.New("oidc token exchange failed")
+
+	// ErrIDToken is returned when the ID Token fails verification or validation
+	ErrIDToken = errors.New("invalid id_token")
+)
+
+// loginTTL is how long a user has to log in with the issuer
+const loginTTL = 10 * time.Minute
+
+// maxResponseBody limits token responses
+const maxResponseBody = 1 << 20
+
+// Config describes the relying party, as registered with the issuer
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // sent as client_secret_basic, if set
+	// RedirectURL is the absolute URL of the CallbackHandler. If empty, it is
+	// https://{Host}/{callback path}, where the callback path is the login
+	// path with its last segment replaced by "callback".
+	RedirectURL string
+	// Scopes to request, in addition to "openid" (default: email profile)
+	Scopes []string
+	// GroupsClaim names the ID Token claim that lists the user's groups
+	// (default: groups). Nested claims may be given by path, such as "realm_access.roles".
+	GroupsClaim string
+	// HTTPClient is used for discovery, keys, and token exchange (default: 30s timeout)
+	HTTPClient *http.Client
+}
+
+// RelyingParty runs the login flow with an issuer and issues sessions
+type RelyingParty struct {
+	Config
+	Sessions *session.Manager
+	// Grants maps a verified user to permissions. If nil, users have none.
+	Grants Grants
+	// GroupsAsPermissions also gives users their groups, as is, as permissions.
+	// Only set it for an issuer where groups are written as permissions, since
+	// anyone who can add a user to a group there can then grant access here.
+	GroupsAsPermissions bool
+
+	Validator             *jwt.Validator
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	keys                  jwtauth.VerifierSource
+
+	stateCookieName string
+	now             func() time.Time
+}
+
+// New reads the issuer's discovery document (see keyfetch.FetchOIDCDiscovery)
+// and prepares its keys and an ID Token validator for the client ID.
+func New(ctx context.Context, cfg Config, sessions *session.Manager) (*RelyingParty, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("%w: issuer and client id are required", ErrDiscovery)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.Scopes == nil {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+	cfg.GroupsClaim = cmp.Or(cfg.GroupsClaim, "groups")
+
+	doc, err := keyfetch.FetchOIDCDiscovery(ctx, cfg.Issuer, cfg.HTTPClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w: authorization_endpoint and token_endpoint are required", ErrDiscovery)
+	}
+	keys, err := doc.NewKeyFetcher(cfg.HTTPClient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+
+	// auth_time is only sent when max_age is requested
+	v := jwt.NewIDTokenValidator([]string{cfg.Issuer}, []string{cfg.ClientID}, nil)
+	v.Checks &^= jwt.CheckAuthTime
+
+	return &RelyingParty{
+		Config:                cfg,
+		Sessions:              sessions,
+		Validator:             v,
+		AuthorizationEndpoint: doc.AuthorizationEndpoint,
+		TokenEndpoint:         doc.TokenEndpoint,
+		keys:                  keys,
+		stateCookieName:       sessions.CookieName + "_oidc",
+		now:                   time.Now,
+	}, nil
+}
+
+// loginState is kept in a sealed cookie between the login redirect and the callback
+type loginState struct {
+	State       string `json:"state"`
+	Nonce       string `json:"nonce"`
+	Verifier    string `json:"verifier"`
+	RedirectURL string `json:"redirect_uri"`
+	Next        string `json:"next"`
+	Expires     int64  `json:"exp"`
+}
+
+// LoginHandler redirects to the issuer to log in, then back to the
+// CallbackHandler, and then to "next" (local paths only)
+func (rp *RelyingParty) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expires := rp.now().Add(loginTTL)
+		ls := loginState{
+			State:       rand.Text(),
+			Nonce:       rand.Text(),
+			Verifier:    rand.Text() + rand.Text(), // 52 chars, RFC 7636 §4.1 requires 43+
+			RedirectURL: rp.redirectURL(r),
+			Next:        r.URL.Query().Get("next"),
+			Expires:     expires.Unix(),
+		}
+		if err := rp.Sessions.SetCookie(w, rp.stateCookieName, ls, expires); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		challenge := sha256.Sum256([]byte(ls.Verifier))
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {rp.ClientID},
+			"redirect_uri":          {ls.RedirectURL},
+			"scope":                 {strings.Join(append([]string{"openid"}, rp.Scopes...), " ")},
+			"state":                 {ls.State},
+			"nonce":                 {ls.Nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		sep := "?"
+		if strings.Contains(rp.AuthorizationEndpoint, "?") {
+			sep = "&"
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, rp.AuthorizationEndpoint+sep+query.Encode(), http.StatusSeeOther)
+	})
+}
+
+// CallbackHandler checks the state, exchanges the code (with the PKCE verifier)
+// for an ID Token, verifies it and its nonce, and issues a session
+func (rp *RelyingParty) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		var ls loginState
+		err := rp.Sessions.ReadCookie(r, rp.stateCookieName, &ls)
+		rp.Sessions.ClearCookie(w, rp.stateCookieName)
+		if err != nil || rp.now().Unix() >= ls.Expires {
+			http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(ls.State)) != 1 {
+			http.Error(w, "Login state mismatch, please try again", http.StatusBadRequest)
+			return
+		}
+		if errCode := query.Get("error"); errCode != "" {
+			http.Error(w, "Login failed: "+errCode, http.StatusUnauthorized)
+			return
+		}
+
+		p, err := rp.exchange(r.Context(), query.Get("code"), &ls)
+		if err != nil {
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+			return
+		}
+
+		if _, err := rp.Sessions.Issue(w, p); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, localRedirect(ls.Next, rp.Sessions.Redirect), http.StatusSeeOther)
+	})
+}
+
+// tokenResponse holds the fields of the token response that are used here
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// exchange trades the code for an ID Token and returns its verified principle
+func (rp *RelyingParty) exchange(ctx context.Context, code string, ls *loginState) (*Principle, error) {
+	if code == "" {
+		return nil, fmt.Errorf("%w: missing code", ErrTokenExchange)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {ls.RedirectURL},
+		"client_id":     {rp.ClientID},
+		"code_verifier": {ls.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rp.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if rp.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(rp.ClientID), url.QueryEscape(rp.ClientSecret))
+	}
+
+	resp, err := rp.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBody)).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrTokenExchange, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrTokenExchange, resp.Status, tr.Error)
+	}
+
+	return rp.VerifyIDToken(tr.IDToken, ls.Nonce)
+}
+
+// VerifyIDToken verifies the signature, claims, and nonce of an ID Token,
+// and returns its principle with the permissions given by Grants
+// (and its groups, with GroupsAsPermissions)
+func (rp *RelyingParty) VerifyIDToken(idToken, nonce string) (*Principle, error) {
+	verifier, err := rp.keys.Verifier()
+	if err != nil {
+		return nil, err
+	}
+	jws, err := verifier.VerifyJWT(idToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIDToken, err)
+	}
+
+	p := &Principle{}
+	if err := jws.UnmarshalClaims(&p.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIDToken, err)
+	}
+	if err := rp.Validator.Validate(nil, &p.Claims, rp.now()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIDToken, err)
+	}
+	if subtle.ConstantTimeCompare([]byte(p.Claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrIDToken)
+	}
+
+	p.Groups, err = jwtauth.ClaimStrings(jws.GetPayload(), rp.GroupsClaim)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrIDToken, err)
+	}
+	if rp.Grants != nil {
+		p.Roles = rp.Grants.Permissions(p)
+	}
+	if rp.GroupsAsPermissions {
+		for _, group := range p.Groups {
+			if !slices.Contains(p.Roles, group) {
+				p.Roles = append(p.Roles, group)
+			}
+		}
+	}
+	return p, nil
+}
+
+// redirectURL returns RedirectURL, or the callback URL next to the login URL
+func (rp *RelyingParty) redirectURL(r *http.Request) string {
+	if rp.RedirectURL != "" {
+		return rp.RedirectURL
+	}
+	return "https://" + r.Host + path.Join(path.Dir(r.URL.Path), "callback")
+}
+
+// Principle is a user verified by an ID Token
+type Principle struct {
+	Claims jwt.StandardClaims
+	Groups []string
+	Roles  []string
+}
+
+// ID returns the user's email, if verified by the issuer, otherwise their sub
+func (p *Principle) ID() string {
+	if email := p.VerifiedEmail(); email != "" {
+		return email
+	}
+	return p.Claims.Sub
+}
+
+// Permissions returns the permissions given by Grants (and, with
+// GroupsAsPermissions, the user's groups)
+func (p *Principle) Permissions() []string {
+	return p.Roles
+}
+
+// VerifiedEmail returns the user's email if the issuer has verified it, otherwise ""
+func (p *Principle) VerifiedEmail() string {
+	if p.Claims.Email == "" || !p.Claims.EmailVerified.Valid || !p.Claims.EmailVerified.Bool {
+		return ""
+	}
+	return strings.ToLower(p.Claims.Email)
+}
+
+// localRedirect returns next if it is a local path (not //host or /\host), otherwise def
+func localRedirect(next, def string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return def
+	}
+	return next
+}
+
+var _ auth.BasicPrinciple = (*Principle)(nil)
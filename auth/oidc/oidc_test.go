@@ -0,0 +1,453 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth/jwt"
+	"github.com/therootcompany/golib/auth/session"
+)
+
+const testClientID = "dash"
+
+type idTokenClaims struct {
+	jwt.StandardClaims
+	Groups []string `json:"groups,omitempty"`
+}
+
+type authRequest struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+// fakeIdP is an in-process issuer that logs everyone in as jane@example.com
+type fakeIdP struct {
+	srv    *httptest.Server
+	signer *jwt.Signer
+
+	mu     sync.Mutex
+	codes  map[string]authRequest
+	issued int
+	sub    string
+	email  string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	pk, err := jwt.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := jwt.NewSigner([]*jwt.PrivateKey{pk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{
+		signer: signer,
+		codes:  map[string]authRequest{},
+		sub:    "user-123",
+		email:  "Jane@Example.com",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.srv.URL,
+			"authorization_endpoint": idp.srv.URL + "/authorize",
+			"token_endpoint":         idp.srv.URL + "/token",
+			"jwks_uri":               idp.srv.URL + "/jwks.json",
+		})
+	})
+	mux.HandleFunc("GET /jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jwt.WellKnownJWKs{Keys: idp.signer.Keys})
+	})
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	idp.srv = httptest.NewTLSServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	idp.issued += 1
+	code := fmt.Sprintf("code-%d", idp.issued)
+	idp.codes[code] = authRequest{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	idp.mu.Unlock()
+
+	callback := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, callback, http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, `{"error": %q}`, code)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError("invalid_request")
+		return
+	}
+	if id, secret, _ := r.BasicAuth(); id != testClientID || secret != "shh" {
+		tokenError("invalid_client")
+		return
+	}
+
+	idp.mu.Lock()
+	req, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || req.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != req.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	c := &idTokenClaims{Groups: []string{"ops"}}
+	c.Iss = idp.srv.URL
+	c.Sub = idp.sub
+	c.Aud = jwt.Listish{testClientID}
+	c.IAt = now.Unix()
+	c.Exp = now.Add(5 * time.Minute).Unix()
+	c.Nonce = req.nonce
+	c.Email = idp.email
+	c.EmailVerified = jwt.NullBool{Bool: true, Valid: true}
+	idToken, err := idp.signer.SignToString(c)
+	if err != nil {
+		tokenError("server_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = fmt.Fprintf(w, `{"access_token": "opaque", "token_type": "Bearer", "id_token": %q}`, idToken)
+}
+
+func newRelyingParty(t *testing.T, idp *fakeIdP) *RelyingParty {
+	t.Helper()
+	sm, err := session.NewEncrypted(nil, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := New(t.Context(), Config{
+		Issuer:       idp.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: "shh",
+		HTTPClient:   idp.srv.Client(),
+	}, sm)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return rp
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// startLogin runs the LoginHandler and the fake IdP's authorization endpoint,
+// and returns the callback URL (with code and state) and the state cookie
+func startLogin(t *testing.T, idp *fakeIdP, rp *RelyingParty) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	rp.LoginHandler().ServeHTTP(w, httptest.NewRequest("GET", "https://app.example.com/oidc/login?next=/dashboard", nil))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login: %d", w.Code)
+	}
+	stateCookie := findCookie(w.Result().Cookies(), "session_oidc")
+	if stateCookie == nil {
+		t.Fatal("no state cookie")
+	}
+
+	authorizeURL := w.Header().Get("Location")
+	if !strings.HasPrefix(authorizeURL, idp.srv.URL+"/authorize?") {
+		t.Fatalf("login redirected to %q", authorizeURL)
+	}
+	q, _ := url.ParseQuery(authorizeURL[strings.Index(authorizeURL, "?")+1:])
+	if q.Get("redirect_uri") != "https://app.example.com/oidc/callback" || q.Get("scope") != "openid email profile" {
+		t.Errorf("authorize query: %v", q)
+	}
+
+	client := idp.srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(authorizeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s", resp.Status)
+	}
+	return resp.Header.Get("Location"), stateCookie
+}
+
+func callback(rp *RelyingParty, callbackURL string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", callbackURL, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	rp.CallbackHandler().ServeHTTP(w, r)
+	return w
+}
+
+func TestLogin(t *testing.T) {
+	idp := newFakeIdP(t)
+	rp := newRelyingParty(t, idp)
+	rp.Grants = Grants{
+		"jane@example.com": {"GET:/"},
+		"group:ops":        {"POST:/deploy", "GET:/"},
+	}
+
+	callbackURL, stateCookie := startLogin(t, idp, rp)
+	w := callback(rp, callbackURL, stateCookie)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback: %d %q\n%s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	if c := findCookie(w.Result().Cookies(), "session_oidc"); c == nil || c.MaxAge >= 0 {
+		t.Errorf("expected the state cookie to be cleared, got %v", c)
+	}
+
+	c := findCookie(w.Result().Cookies(), "session")
+	if c == nil {
+		t.Fatal("no session cookie")
+	}
+	p, err := rp.Sessions.Authenticate("", c.Value)
+	if err != nil {
+		t.Fatalf("session: %v", err)
+	}
+	if p.ID() != "jane@example.com" {
+		t.Errorf("ID() = %q, want the verified email", p.ID())
+	}
+	if !slices.Equal(p.Permissions(), []string{"GET:/", "POST:/deploy"}) {
+		t.Errorf("Permissions() = %q", p.Permissions())
+	}
+
+	// the code and the state cookie are single-use
+	if w := callback(rp, callbackURL, stateCookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: %d, want 401", w.Code)
+	}
+}
+
+func TestCallbackState(t *testing.T) {
+	idp := newFakeIdP(t)
+	rp := newRelyingParty(t, idp)
+
+	callbackURL, stateCookie := startLogin(t, idp, rp)
+	u, _ := url.Parse(callbackURL)
+	q := u.Query()
+	q.Set("state", "forged")
+	u.RawQuery = q.Encode()
+	if w := callback(rp, u.String(), stateCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("state mismatch: %d, want 400", w.Code)
+	}
+
+	if w := callback(rp, callbackURL); w.Code != http.StatusBadRequest {
+		t.Errorf("missing state cookie: %d, want 400", w.Code)
+	}
+
+	rp.now = func() time.Time { return time.Now().Add(loginTTL) }
+	if w := callback(rp, callbackURL, stateCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("expired login: %d, want 400", w.Code)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newFakeIdP(t)
+	rp := newRelyingParty(t, idp)
+
+	// readState opens the sealed login state, as the callback would
+	readState := func(c *http.Cookie) loginState {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(c)
+		var ls loginState
+		if err := rp.Sessions.ReadCookie(r, rp.stateCookieName, &ls); err != nil {
+			t.Fatal(err)
+		}
+		return ls
+	}
+	code := func(callbackURL string) string {
+		u, _ := url.Parse(callbackURL)
+		return u.Query().Get("code")
+	}
+
+	callbackURL, stateCookie := startLogin(t, idp, rp)
+	ls := readState(stateCookie)
+	ls.Verifier = strings.Repeat("x", 52)
+	if _, err := rp.exchange(t.Context(), code(callbackURL), &ls); !errors.Is(err, ErrTokenExchange) {
+		t.Errorf("wrong PKCE verifier: got %v, want ErrTokenExchange", err)
+	}
+
+	callbackURL, stateCookie = startLogin(t, idp, rp)
+	ls = readState(stateCookie)
+	ls.Nonce = "replayed"
+	if _, err := rp.exchange(t.Context(), code(callbackURL), &ls); !errors.Is(err, ErrIDToken) {
+		t.Errorf("wrong nonce: got %v, want ErrIDToken", err)
+	}
+
+	// without Grants, there are no permissions
+	callbackURL, stateCookie = startLogin(t, idp, rp)
+	ls = readState(stateCookie)
+	p, err := rp.exchange(t.Context(), code(callbackURL), &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Permissions()) != 0 {
+		t.Errorf("Permissions() = %q, want none", p.Permissions())
+	}
+
+	// unless groups are asked for as permissions
+	rp.GroupsAsPermissions = true
+	callbackURL, stateCookie = startLogin(t, idp, rp)
+	ls = readState(stateCookie)
+	p, err = rp.exchange(t.Context(), code(callbackURL), &ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(p.Permissions(), []string{"ops"}) {
+		t.Errorf("Permissions() = %q, want the groups claim", p.Permissions())
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	rp := newRelyingParty(t, idp)
+
+	sign := func(c *idTokenClaims) string {
+		token, err := idp.signer.SignToString(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	claims := func() *idTokenClaims {
+		now := time.Now()
+		c := &idTokenClaims{}
+		c.Iss = idp.srv.URL
+		c.Sub = "user-123"
+		c.Aud = jwt.Listish{testClientID}
+		c.IAt = now.Unix()
+		c.Exp = now.Add(time.Minute).Unix()
+		c.Nonce = "n-0"
+		c.Email = "jane@example.com"
+		return c
+	}
+
+	// an unverified email is not used as the ID
+	p, err := rp.VerifyIDToken(sign(claims()), "n-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID() != "user-123" {
+		t.Errorf("unverified email: ID() = %q, want the sub", p.ID())
+	}
+
+	otherAud := claims()
+	otherAud.Aud = jwt.Listish{"someone-else"}
+	if _, err := rp.VerifyIDToken(sign(otherAud), "n-0"); !errors.Is(err, ErrIDToken) {
+		t.Errorf("wrong audience: got %v, want ErrIDToken", err)
+	}
+
+	otherIss := claims()
+	otherIss.Iss = "https://evil.example.com"
+	if _, err := rp.VerifyIDToken(sign(otherIss), "n-0"); !errors.Is(err, ErrIDToken) {
+		t.Errorf("wrong issuer: got %v, want ErrIDToken", err)
+	}
+
+	expired := claims()
+	expired.Exp = time.Now().Add(-time.Hour).Unix()
+	if _, err := rp.VerifyIDToken(sign(expired), "n-0"); !errors.Is(err, ErrIDToken) {
+		t.Errorf("expired: got %v, want ErrIDToken", err)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"issuer": "https://evil.example.com", "authorization_endpoint": "https://evil.example.com/a", "token_endpoint": "https://evil.example.com/t"}`)
+	}))
+	defer srv.Close()
+
+	sm, err := session.NewEncrypted(nil, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(t.Context(), Config{Issuer: srv.URL, ClientID: testClientID, HTTPClient: srv.Client()}, sm)
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("got %v, want ErrDiscovery", err)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	g, err := ParseGrants(strings.NewReader(`
+# who can do what
+Jane@Example.com   /
+@example.com       GET:/
+group:ops          POST:/deploy GET:/
+sub:robot-1        GET:/metrics
+*                  GET:/health
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := jwt.NullBool{Bool: true, Valid: true}
+	for _, tc := range []struct {
+		name   string
+		email  string
+		valid  jwt.NullBool
+		sub    string
+		groups []string
+		want   []string
+	}{
+		{"email", "jane@example.com", verified, "1", nil, []string{"/", "GET:/", "GET:/health"}},
+		{"domain", "joe@example.com", verified, "2", nil, []string{"GET:/", "GET:/health"}},
+		{"group", "ann@other.com", verified, "3", []string{"ops"}, []string{"POST:/deploy", "GET:/", "GET:/health"}},
+		{"unverified email", "jane@example.com", jwt.NullBool{}, "4", nil, []string{"GET:/health"}},
+		{"sub", "", jwt.NullBool{}, "robot-1", nil, []string{"GET:/metrics", "GET:/health"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &Principle{Groups: tc.groups}
+			p.Claims.Sub = tc.sub
+			p.Claims.Email = tc.email
+			p.Claims.EmailVerified = tc.valid
+			if got := g.Permissions(p); !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseGrants(strings.NewReader("jane@example.com\n")); err == nil {
+		t.Error("expected an error for an entry without permissions")
+	}
+}
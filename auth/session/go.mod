@@ -0,0 +1,7 @@
+module github.com/therootcompany/golib/auth/session
+
+go 1.26.1
+
+require github.com/therootcompany/golib/auth v1.1.1
+
+replace github.com/therootcompany/golib/auth => ../
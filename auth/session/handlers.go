@@ -0,0 +1,158 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// csrfTTL is how long a login form may be left open before it must be reloaded
+const csrfTTL = time.Hour
+
+// LoginPage holds the fields given to Manager.LoginTemplate.
+// The form must POST "username", "password", "next", and "csrf_token".
+type LoginPage struct {
+	Action    string
+	Next      string
+	CSRFToken string
+	Error     string
+}
+
+var defaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Log in</title>
+</head>
+<body>
+<form method="POST" action="{{ .Action }}">
+{{- if .Error }}
+<p role="alert">{{ .Error }}</p>
+{{- end }}
+<input type="hidden" name="next" value="{{ .Next }}">
+<input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+<p><label>Username <input type="text" name="username" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+type csrfCookie struct {
+	Token   string `json:"csrf"`
+	Expires int64  `json:"exp"`
+}
+
+func (m *Manager) csrfCookieName() string {
+	return m.CookieName + "_csrf"
+}
+
+// LoginHandler serves the login form on GET, and on POST verifies the form's
+// CSRF token and the username and password with m.Authenticator, then issues
+// a session and redirects to "next" (local paths only) or m.Redirect.
+//
+// The form is protected by a signed (or encrypted) double-submit token,
+// as well as by http.CrossOriginProtection.
+func (m *Manager) LoginHandler() http.Handler {
+	return http.NewCrossOriginProtection().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			m.renderLogin(w, r, http.StatusOK, r.URL.Query().Get("next"), "")
+		case http.MethodPost:
+			m.handleLogin(w, r)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func (m *Manager) handleLogin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	next := r.PostForm.Get("next")
+
+	if !m.verifyCSRF(r, r.PostForm.Get("csrf_token")) {
+		m.renderLogin(w, r, http.StatusForbidden, next, "The form expired. Please try again.")
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	password := r.PostForm.Get("password")
+	if username == "" {
+		m.renderLogin(w, r, http.StatusUnauthorized, next, "Incorrect username or password.")
+		return
+	}
+	p, err := m.Authenticator.Authenticate(username, password)
+	if err != nil {
+		m.renderLogin(w, r, http.StatusUnauthorized, next, "Incorrect username or password.")
+		return
+	}
+
+	if _, err := m.Issue(w, p); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	m.ClearCookie(w, m.csrfCookieName())
+	http.Redirect(w, r, m.localRedirect(next), http.StatusSeeOther)
+}
+
+// LogoutHandler clears the session cookie and redirects to "next" (local paths only) or m.Redirect
+func (m *Manager) LogoutHandler() http.Handler {
+	return http.NewCrossOriginProtection().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		m.Clear(w)
+		http.Redirect(w, r, m.localRedirect(r.FormValue("next")), http.StatusSeeOther)
+	}))
+}
+
+func (m *Manager) renderLogin(w http.ResponseWriter, r *http.Request, status int, next, errMsg string) {
+	token := rand.Text()
+	expires := m.now().Add(csrfTTL)
+	if err := m.SetCookie(w, m.csrfCookieName(), csrfCookie{Token: token, Expires: expires.Unix()}, expires); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	page := LoginPage{
+		Action:    r.URL.Path,
+		Next:      m.localRedirect(next),
+		CSRFToken: token,
+		Error:     errMsg,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = m.LoginTemplate.Execute(w, page)
+}
+
+func (m *Manager) verifyCSRF(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	var csrf csrfCookie
+	if err := m.ReadCookie(r, m.csrfCookieName(), &csrf); err != nil {
+		return false
+	}
+	if m.now().Unix() >= csrf.Expires {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(csrf.Token), []byte(token)) == 1
+}
+
+// localRedirect returns next if it is a local path (not //host or /\host), otherwise m.Redirect
+func (m *Manager) localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return m.Redirect
+	}
+	return next
+}
@@ -0,0 +1,326 @@
+// Package session issues and verifies session cookies for principles
+// authenticated by any auth.BasicAuthenticator (such as csvauth), so that
+// browsers can log in once with a form rather than with a Basic Auth popup.
+//
+// The cookie value holds the principle's ID, permissions, and expiry, either
+// HMAC-SHA256 signed (see NewSigned) or AES-GCM encrypted (see NewEncrypted).
+// No server-side state is kept.
+//
+//	sm, err := session.NewEncrypted(csvAuth, key)
+//	mux.Handle("/login", sm.LoginHandler())
+//	mux.Handle("/logout", sm.LogoutHandler())
+//
+//	ra := auth.NewBasicRequestAuthenticator(auth.BasicAuthenticators{sm, csvAuth})
+//	ra.TokenCookies = []string{sm.CookieName}
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+var (
+	// ErrNoSession is returned when the request has no session cookie
+	ErrNoSession = errors.New("no session")
+
+	// ErrInvalidSession is returned when a cookie value was not issued by
+	// this Manager (or its key), or was tampered with
+	ErrInvalidSession = errors.New("invalid session")
+
+	// ErrExpired is returned when a session is past its expiry
+	ErrExpired = errors.New("session expired")
+)
+
+// Session is the content of a session cookie. It implements auth.BasicPrinciple.
+type Session struct {
+	Subject  string   `json:"sub"`
+	Roles    []string `json:"roles,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
+}
+
+// ID returns the ID of the principle that logged in
+func (s *Session) ID() string {
+	return s.Subject
+}
+
+// Permissions returns the permissions the principle had when it logged in
+func (s *Session) Permissions() []string {
+	return s.Roles
+}
+
+// sealer protects a cookie value, bound to the cookie's name
+type sealer interface {
+	seal(name string, plaintext []byte) string
+	open(name, value string) ([]byte, error)
+}
+
+// Manager issues, verifies, and refreshes session cookies.
+// Fields may be changed after construction, but not concurrently with use.
+type Manager struct {
+	// Authenticator verifies the username and password posted to the login form
+	Authenticator auth.BasicAuthenticator
+
+	CookieName string
+	Path       string
+	Domain     string
+	// Secure should only be turned off for local development over http
+	Secure bool
+
+	// TTL is how long a session lasts without use. A session is extended
+	// by Refresh once less than half of its TTL remains.
+	TTL time.Duration
+	// MaxAge is how long a session may be extended to after login (0 for no limit)
+	MaxAge time.Duration
+
+	// Redirect is where to go after login or logout when no (local) "next" is given
+	Redirect string
+	// LoginTemplate renders the login form (see LoginPage for the fields it is given)
+	LoginTemplate *template.Template
+
+	sealer sealer
+	now    func() time.Time
+}
+
+func newManager(a auth.BasicAuthenticator, s sealer) *Manager {
+	return &Manager{
+		Authenticator: a,
+		CookieName:    "session",
+		Path:          "/",
+		Secure:        true,
+		TTL:           12 * time.Hour,
+		MaxAge:        7 * 24 * time.Hour,
+		Redirect:      "/",
+		LoginTemplate: defaultLoginTemplate,
+		sealer:        s,
+		now:           time.Now,
+	}
+}
+
+// NewSigned returns a Manager whose cookies are HMAC-SHA256 signed,
+// but readable by the client. The key must be at least 32 bytes.
+func NewSigned(a auth.BasicAuthenticator, key []byte) (*Manager, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("session: signing key must be at least 32 bytes, got %d", len(key))
+	}
+	return newManager(a, hmacSealer{key: slices.Clone(key)}), nil
+}
+
+// NewEncrypted returns a Manager whose cookies are AES-GCM encrypted,
+// with a 16-, 24-, or 32-byte key.
+func NewEncrypted(a auth.BasicAuthenticator, key []byte) (*Manager, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	aead, err := cipher.NewGCMWithRandomNonce(block)
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	return newManager(a, gcmSealer{aead: aead}), nil
+}
+
+// Issue starts a session for p and sets its cookie
+func (m *Manager) Issue(w http.ResponseWriter, p auth.BasicPrinciple) (*Session, error) {
+	now := m.now()
+	s := &Session{
+		Subject:  p.ID(),
+		Roles:    p.Permissions(),
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(m.TTL).Unix(),
+	}
+	if err := m.write(w, m.CookieName, s, s.Expires); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh extends s (and resets its cookie) once less than half of the TTL
+// remains, but not beyond MaxAge after login. It is a no-op otherwise.
+func (m *Manager) Refresh(w http.ResponseWriter, s *Session) error {
+	now := m.now()
+	if time.Unix(s.Expires, 0).Sub(now) >= m.TTL/2 {
+		return nil
+	}
+
+	expires := now.Add(m.TTL)
+	if m.MaxAge > 0 {
+		if limit := time.Unix(s.IssuedAt, 0).Add(m.MaxAge); expires.After(limit) {
+			expires = limit
+		}
+	}
+	if expires.Unix() <= s.Expires {
+		return nil
+	}
+
+	s.Expires = expires.Unix()
+	return m.write(w, m.CookieName, s, s.Expires)
+}
+
+// Clear expires the session cookie
+func (m *Manager) Clear(w http.ResponseWriter) {
+	m.ClearCookie(w, m.CookieName)
+}
+
+// SetCookie seals v as JSON (with the same key and attributes as the session
+// cookie) into a cookie that expires at the given time, for short-lived state
+// such as CSRF tokens or the state of a login redirect
+func (m *Manager) SetCookie(w http.ResponseWriter, name string, v any, expires time.Time) error {
+	return m.write(w, name, v, expires.Unix())
+}
+
+// ReadCookie opens a cookie set by SetCookie into v, returning ErrNoSession
+// if it is missing, or ErrInvalidSession if it was not sealed by m for this name.
+// Any expiry stored inside v must be checked by the caller.
+func (m *Manager) ReadCookie(r *http.Request, name string, v any) error {
+	c, err := r.Cookie(name)
+	if err != nil || c.Value == "" {
+		return ErrNoSession
+	}
+	return m.read(name, c.Value, v)
+}
+
+// ClearCookie expires a cookie
+func (m *Manager) ClearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, m.cookie(name, "", -1, time.Unix(0, 0)))
+}
+
+// Load reads and verifies the session cookie from r
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(m.CookieName)
+	if err != nil || c.Value == "" {
+		return nil, ErrNoSession
+	}
+	return m.verify(c.Value)
+}
+
+// Authenticate verifies a session cookie value given as the secret (with an
+// empty name), or as the name (with an empty secret), as for
+// auth.BasicRequestAuthenticator.TokenCookies. It does not extend the session.
+func (m *Manager) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	if secret == "" {
+		secret, name = name, secret
+	}
+	if name != "" || secret == "" {
+		return nil, ErrInvalidSession
+	}
+	return m.verify(secret)
+}
+
+func (m *Manager) verify(value string) (*Session, error) {
+	var s Session
+	if err := m.read(m.CookieName, value, &s); err != nil {
+		return nil, err
+	}
+	if s.Subject == "" {
+		return nil, ErrInvalidSession
+	}
+	if m.now().Unix() >= s.Expires {
+		return nil, ErrExpired
+	}
+	if m.MaxAge > 0 && m.now().After(time.Unix(s.IssuedAt, 0).Add(m.MaxAge)) {
+		return nil, ErrExpired
+	}
+	return &s, nil
+}
+
+func (m *Manager) write(w http.ResponseWriter, name string, v any, expires int64) error {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	exp := time.Unix(expires, 0)
+	maxAge := int(exp.Sub(m.now()).Seconds())
+	http.SetCookie(w, m.cookie(name, m.sealer.seal(name, plaintext), max(maxAge, 1), exp))
+	return nil
+}
+
+func (m *Manager) read(name, value string, v any) error {
+	plaintext, err := m.sealer.open(name, value)
+	if err != nil {
+		return ErrInvalidSession
+	}
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+func (m *Manager) cookie(name, value string, maxAge int, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// hmacSealer signs (but does not encrypt) values as base64(plaintext).base64(mac)
+type hmacSealer struct {
+	key []byte
+}
+
+func (h hmacSealer) mac(name, payload string) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	_, _ = mac.Write([]byte(name + "." + payload))
+	return mac.Sum(nil)
+}
+
+func (h hmacSealer) seal(name string, plaintext []byte) string {
+	payload := base64.RawURLEncoding.EncodeToString(plaintext)
+	sig := base64.RawURLEncoding.EncodeToString(h.mac(name, payload))
+	return payload + "." + sig
+}
+
+func (h hmacSealer) open(name, value string) ([]byte, error) {
+	payload, sig64, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidSession
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sig64)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	if !hmac.Equal(sig, h.mac(name, payload)) {
+		return nil, ErrInvalidSession
+	}
+	return base64.RawURLEncoding.DecodeString(payload)
+}
+
+// gcmSealer encrypts values as base64(nonce|ciphertext), with the cookie name as additional data
+type gcmSealer struct {
+	aead cipher.AEAD
+}
+
+func (g gcmSealer) seal(name string, plaintext []byte) string {
+	return base64.RawURLEncoding.EncodeToString(g.aead.Seal(nil, nil, plaintext, []byte(name)))
+}
+
+func (g gcmSealer) open(name, value string) ([]byte, error) {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	return g.aead.Open(nil, nil, ciphertext, []byte(name))
+}
+
+var _ auth.BasicAuthenticator = (*Manager)(nil)
+var _ auth.BasicPrinciple = (*Session)(nil)
@@ -0,0 +1,250 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+type testPrinciple struct {
+	id    string
+	roles []string
+}
+
+func (p testPrinciple) ID() string            { return p.id }
+func (p testPrinciple) Permissions() []string { return p.roles }
+
+// testCreds is a toy username/password store, standing in for csvauth
+type testCreds map[string]string
+
+func (c testCreds) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	if pass, ok := c[name]; ok && pass == secret {
+		return testPrinciple{name, []string{"GET:/"}}, nil
+	}
+	return nil, errors.New("unauthorized")
+}
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func newManagers(t *testing.T) map[string]*Manager {
+	t.Helper()
+	creds := testCreds{"jane": "secret"}
+	signed, err := NewSigned(creds, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := NewEncrypted(creds, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Manager{"signed": signed, "encrypted": encrypted}
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestIssueAndLoad(t *testing.T) {
+	for name, m := range newManagers(t) {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if _, err := m.Issue(w, testPrinciple{"jane", []string{"GET:/", "POST:/logs"}}); err != nil {
+				t.Fatal(err)
+			}
+			c := findCookie(w.Result().Cookies(), "session")
+			if c == nil || !c.HttpOnly || !c.Secure {
+				t.Fatalf("expected a secure, httponly session cookie, got %v", c)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(c)
+			s, err := m.Load(r)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if s.ID() != "jane" || !slices.Equal(s.Permissions(), []string{"GET:/", "POST:/logs"}) {
+				t.Errorf("got %q %q", s.ID(), s.Permissions())
+			}
+
+			ra := auth.NewBasicRequestAuthenticator(m)
+			ra.TokenCookies = []string{m.CookieName}
+			if p, err := ra.Authenticate(r); err != nil || p.ID() != "jane" {
+				t.Errorf("BasicRequestAuthenticator: %v, %v", p, err)
+			}
+
+			tampered := c.Value[:len(c.Value)-2] + "xx"
+			if _, err := m.Authenticate("", tampered); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("tampered: got %v, want ErrInvalidSession", err)
+			}
+			if _, err := m.Authenticate("jane", "secret"); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("username and password: got %v, want ErrInvalidSession", err)
+			}
+
+			// a value sealed for one cookie is not valid for another
+			w = httptest.NewRecorder()
+			_ = m.write(w, "other", Session{Subject: "jane", Expires: time.Now().Add(time.Hour).Unix()}, 0)
+			other := findCookie(w.Result().Cookies(), "other")
+			if _, err := m.Authenticate("", other.Value); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("other cookie: got %v, want ErrInvalidSession", err)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	m := newManagers(t)["encrypted"]
+	m.TTL = time.Hour
+	m.MaxAge = 2 * time.Hour
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	w := httptest.NewRecorder()
+	s, err := m.Issue(w, testPrinciple{"jane", nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := findCookie(w.Result().Cookies(), "session").Value
+
+	// early on, nothing changes
+	now = now.Add(20 * time.Minute)
+	w = httptest.NewRecorder()
+	if err := m.Refresh(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("refreshed before half of the TTL")
+	}
+
+	// past half-life, extended by the TTL
+	now = now.Add(20 * time.Minute)
+	w = httptest.NewRecorder()
+	if err := m.Refresh(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Expires != now.Add(time.Hour).Unix() || len(w.Result().Cookies()) != 1 {
+		t.Errorf("expected a sliding refresh, got exp %d", s.Expires)
+	}
+
+	// but never past MaxAge
+	now = now.Add(50 * time.Minute)
+	w = httptest.NewRecorder()
+	if err := m.Refresh(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if limit := time.Unix(s.IssuedAt, 0).Add(m.MaxAge).Unix(); s.Expires != limit {
+		t.Errorf("exp %d, want MaxAge limit %d", s.Expires, limit)
+	}
+
+	// the original cookie has expired by now
+	if _, err := m.Authenticate("", value); !errors.Is(err, ErrExpired) {
+		t.Errorf("stale cookie: got %v, want ErrExpired", err)
+	}
+}
+
+var csrfRe = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestLogin(t *testing.T) {
+	for name, m := range newManagers(t) {
+		t.Run(name, func(t *testing.T) {
+			h := m.LoginHandler()
+
+			// GET renders the form with a CSRF token
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/login?next=/dashboard", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET: %d", w.Code)
+			}
+			match := csrfRe.FindStringSubmatch(w.Body.String())
+			if match == nil {
+				t.Fatalf("no csrf_token in form:\n%s", w.Body.String())
+			}
+			token := match[1]
+			csrf := findCookie(w.Result().Cookies(), "session_csrf")
+			if csrf == nil {
+				t.Fatal("no csrf cookie")
+			}
+
+			post := func(form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+				r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			form := url.Values{"username": {"jane"}, "password": {"secret"}, "next": {"/dashboard"}, "csrf_token": {token}}
+			if w := post(form); w.Code != http.StatusForbidden {
+				t.Errorf("missing csrf cookie: %d, want 403", w.Code)
+			}
+			form.Set("csrf_token", "forged")
+			if w := post(form, csrf); w.Code != http.StatusForbidden {
+				t.Errorf("wrong csrf token: %d, want 403", w.Code)
+			}
+			form.Set("csrf_token", token)
+			form.Set("password", "wrong")
+			if w := post(form, csrf); w.Code != http.StatusUnauthorized {
+				t.Errorf("wrong password: %d, want 401", w.Code)
+			}
+
+			form.Set("password", "secret")
+			w = post(form, csrf)
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/dashboard" {
+				t.Fatalf("login: %d %q", w.Code, w.Header().Get("Location"))
+			}
+			c := findCookie(w.Result().Cookies(), "session")
+			if c == nil {
+				t.Fatal("no session cookie after login")
+			}
+			if p, err := m.Authenticate("", c.Value); err != nil || p.ID() != "jane" {
+				t.Errorf("session: %v, %v", p, err)
+			}
+
+			form.Set("next", "//evil.example.com/")
+			if w := post(form, csrf); w.Header().Get("Location") != "/" {
+				t.Errorf("open redirect: Location %q", w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestLoginCrossOrigin(t *testing.T) {
+	m := newManagers(t)["signed"]
+	r := httptest.NewRequest("POST", "/login", strings.NewReader("username=jane&password=secret"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	w := httptest.NewRecorder()
+	m.LoginHandler().ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("cross-site POST: %d, want 403", w.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	m := newManagers(t)["signed"]
+	w := httptest.NewRecorder()
+	m.LogoutHandler().ServeHTTP(w, httptest.NewRequest("POST", "/logout", nil))
+	c := findCookie(w.Result().Cookies(), "session")
+	if c == nil || c.MaxAge >= 0 {
+		t.Errorf("expected session cookie to be cleared, got %v", c)
+	}
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("logout: %d", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+module github.com/therootcompany/golib/auth/sqlauth
+
+go 1.26.1
+
+require (
+	github.com/therootcompany/golib/auth v1.1.1
+	github.com/therootcompany/golib/auth/csvauth v1.2.4
+	github.com/therootcompany/golib/database/sqlmigrate v1.0.2
+	github.com/therootcompany/golib/database/sqlmigrate/litemigrate v1.0.2
+	modernc.org/sqlite v1.48.2
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/crypto v0.42.0 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+	modernc.org/libc v1.70.0 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
+
+replace (
+	github.com/therootcompany/golib/auth => ../
+	github.com/therootcompany/golib/auth/csvauth => ../csvauth
+	github.com/therootcompany/golib/database/sqlmigrate => ../../database/sqlmigrate
+	github.com/therootcompany/golib/database/sqlmigrate/litemigrate => ../../database/sqlmigrate/litemigrate
+)
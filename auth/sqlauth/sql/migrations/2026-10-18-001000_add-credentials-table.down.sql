@@ -0,0 +1,6 @@
+-- add-credentials-table (down)
+DROP INDEX IF EXISTS credentials_token_id_idx;
+DROP TABLE IF EXISTS credentials;
+
+-- leave this as the last line
+DELETE FROM _migrations WHERE id = 'fcc7bfa7';
@@ -0,0 +1,21 @@
+-- add-credentials-table (up)
+-- the same columns as csvauth's credentials.tsv (see csvauth.Credential.ToRecord),
+-- plus token_id (the part of a token's name after the '~') for lookups by secret
+CREATE TABLE credentials (
+   purpose VARCHAR(100) NOT NULL,
+   name VARCHAR(255) NOT NULL,
+   algo VARCHAR(100) NOT NULL,
+   salt VARCHAR(255) NOT NULL DEFAULT '',
+   derived TEXT NOT NULL,
+   roles TEXT NOT NULL DEFAULT '',
+   extra TEXT NOT NULL DEFAULT '',
+   token_id VARCHAR(16) NOT NULL DEFAULT '',
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+   PRIMARY KEY (purpose, name)
+);
+
+CREATE INDEX credentials_token_id_idx ON credentials (token_id);
+
+-- leave this as the last line
+INSERT INTO _migrations (name, id) VALUES ('2026-10-18-001000_add-credentials-table', 'fcc7bfa7');
@@ -0,0 +1,315 @@
+// Package sqlauth stores csvauth credentials in a SQL database
+// (PostgreSQL or SQLite, anything that accepts $1-style placeholders)
+// and verifies them with csvauth's derivation and verification code.
+//
+// The schema is the same as csvauth's credentials.tsv, and ships as
+// sqlmigrate-compatible migrations:
+//
+//	scripts, err := sqlmigrate.Collect(sqlauth.Migrations, sqlauth.MigrationsDir)
+//	_, err = sqlmigrate.Latest(ctx, litemigrate.New(conn), scripts)
+package sqlauth
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// Migrations holds the up and down migrations for the credentials table
+//
+//go:embed sql/migrations/*.sql
+var Migrations embed.FS
+
+// MigrationsDir is the path of the migrations within Migrations
+const MigrationsDir = "sql/migrations"
+
+const (
+	selectColumns = `purpose, name, algo, salt, derived, roles, extra`
+	upsertQuery   = `INSERT INTO credentials (purpose, name, algo, salt, derived, roles, extra, token_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (purpose, name) DO UPDATE SET
+   algo = excluded.algo,
+   salt = excluded.salt,
+   derived = excluded.derived,
+   roles = excluded.roles,
+   extra = excluded.extra,
+   token_id = excluded.token_id,
+   updated_at = CURRENT_TIMESTAMP`
+	hashIDSep = "~"
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Auth holds the database handle and the encryption key used for
+// aes-128-gcm credentials and for the keyed hashes that identify tokens
+type Auth struct {
+	DB                  *sql.DB
+	aes128key           []byte
+	BasicAuthTokenNames []string
+}
+
+// New initializes an Auth with a database handle and an encryption key
+// (the same key used with csvauth.New, for credentials imported from a csv)
+func New(db *sql.DB, aes128key []byte) *Auth {
+	return &Auth{
+		DB:                  db,
+		aes128key:           slices.Clone(aes128key),
+		BasicAuthTokenNames: []string{"", "api", "apikey"},
+	}
+}
+
+// keyring returns a throwaway csvauth.Auth holding only the key,
+// used to derive, decrypt, and verify credentials one at a time
+func (a *Auth) keyring() *csvauth.Auth {
+	return csvauth.New(a.aes128key)
+}
+
+// NewCredential derives a credential exactly as csvauth.Auth.NewCredential does. Use Store to save it.
+func (a *Auth) NewCredential(purpose, name, secret string, params []string, roles []string, extra string) *csvauth.Credential {
+	return a.keyring().NewCredential(purpose, name, secret, params, roles, extra)
+}
+
+// Authenticate verifies credentials the same way as csvauth.Auth.Authenticate
+// (login by name and password, or token by secret), using a background context.
+func (a *Auth) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	return a.AuthenticateContext(context.Background(), name, secret)
+}
+
+// AuthenticateContext is Authenticate with a context for the database queries
+func (a *Auth) AuthenticateContext(ctx context.Context, name, secret string) (auth.BasicPrinciple, error) {
+	if name == "" && secret == "" {
+		return nil, csvauth.ErrUnauthorized
+	}
+
+	c, err := a.LoadCredential(ctx, name)
+	if err == nil {
+		if err := c.Verify(name, secret); err != nil {
+			return nil, err
+		}
+		return &c, nil
+	}
+	if !errors.Is(err, csvauth.ErrNotFound) {
+		return nil, err
+	}
+
+	if secret == "" {
+		secret, name = name, secret
+	}
+	if slices.Contains(a.BasicAuthTokenNames, name) {
+		return a.loadAndVerifyToken(ctx, secret)
+	}
+
+	return nil, csvauth.ErrNotFound
+}
+
+// Verify is the same as Authenticate, but without returning the credential
+func (a *Auth) Verify(name, secret string) error {
+	_, err := a.Authenticate(name, secret)
+	return err
+}
+
+// LoadCredential loads (and decrypts, if aes-128-gcm) a login credential
+// by its name, or a token by its name~id
+func (a *Auth) LoadCredential(ctx context.Context, name csvauth.Name) (csvauth.Credential, error) {
+	c, err := a.queryOne(ctx,
+		`SELECT `+selectColumns+` FROM credentials WHERE purpose IN ($1, $2) AND name = $3`,
+		csvauth.PurposeDefault, csvauth.PurposeToken, name,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	keyring := a.keyring()
+	_ = keyring.CacheCredential(c)
+	return keyring.LoadCredential(c.ID())
+}
+
+// LoadServiceAccount loads (and decrypts, if aes-128-gcm) a service account by its purpose
+func (a *Auth) LoadServiceAccount(ctx context.Context, purpose csvauth.Purpose) (csvauth.Credential, error) {
+	c, err := a.queryOne(ctx,
+		`SELECT `+selectColumns+` FROM credentials WHERE purpose = $1`,
+		purpose,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	keyring := a.keyring()
+	_ = keyring.CacheServiceAccount(c)
+	return keyring.LoadServiceAccount(purpose)
+}
+
+func (a *Auth) loadAndVerifyToken(ctx context.Context, secret string) (*csvauth.Credential, error) {
+	keyring := a.keyring()
+	tokenID := keyring.TokenID(secret)
+
+	c, err := a.queryOne(ctx,
+		`SELECT `+selectColumns+` FROM credentials WHERE purpose = $1 AND token_id = $2`,
+		csvauth.PurposeToken, tokenID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = keyring.CacheCredential(c)
+	c, err = keyring.LoadToken(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (a *Auth) queryOne(ctx context.Context, query string, args ...any) (csvauth.Credential, error) {
+	record := make([]string, 7)
+	err := a.DB.QueryRowContext(ctx, query, args...).Scan(
+		&record[0], &record[1], &record[2], &record[3], &record[4], &record[5], &record[6],
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return csvauth.Credential{}, csvauth.ErrNotFound
+	}
+	if err != nil {
+		return csvauth.Credential{}, err
+	}
+
+	return csvauth.FromRecord(record)
+}
+
+// Store inserts or replaces a credential.
+// As with csvauth, there is at most one service account per purpose.
+func (a *Auth) Store(ctx context.Context, c csvauth.Credential) error {
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := store(ctx, tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func store(ctx context.Context, db execer, c csvauth.Credential) error {
+	record := c.ToRecord()
+	purpose, name := record[0], record[1]
+
+	var tokenID string
+	switch purpose {
+	case csvauth.PurposeDefault:
+		// no token id
+	case csvauth.PurposeToken:
+		if i := strings.LastIndex(name, hashIDSep); i >= 0 {
+			tokenID = name[i+len(hashIDSep):]
+		}
+	default:
+		if _, err := db.ExecContext(ctx,
+			`DELETE FROM credentials WHERE purpose = $1 AND name <> $2`,
+			purpose, name,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, upsertQuery,
+		purpose, name, record[2], record[3], record[4], record[5], record[6], tokenID,
+	); err != nil {
+		return fmt.Errorf("could not store %s %q: %w", purpose, c.ID(), err)
+	}
+	return nil
+}
+
+// Delete removes a login credential by name, a token by name~id,
+// or a service account by purpose (when purpose is not "login" or "token")
+func (a *Auth) Delete(ctx context.Context, purpose csvauth.Purpose, name csvauth.Name) error {
+	var result sql.Result
+	var err error
+	switch purpose {
+	case "", csvauth.PurposeDefault, csvauth.PurposeToken:
+		result, err = a.DB.ExecContext(ctx,
+			`DELETE FROM credentials WHERE purpose IN ($1, $2) AND name = $3`,
+			csvauth.PurposeDefault, csvauth.PurposeToken, name,
+		)
+	default:
+		result, err = a.DB.ExecContext(ctx,
+			`DELETE FROM credentials WHERE purpose = $1`,
+			purpose,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return csvauth.ErrNotFound
+	}
+	return nil
+}
+
+// Import reads a credentials CSV (such as csvauth's credentials.tsv) and stores
+// every row in a single transaction, replacing rows with the same purpose and name.
+// Rows are copied as-is, so aes-128-gcm credentials and tokens require the same
+// key that was used with csvauth.
+func (a *Auth) Import(ctx context.Context, r io.Reader, comma rune) (int, error) {
+	csvr := csv.NewReader(r)
+	csvr.Comma = comma
+	csvr.Comment = '#'
+	csvr.FieldsPerRecord = -1 // ignore short rows
+	_, _ = csvr.Read()        // strip header row
+
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var n int
+	for {
+		record, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if len(record) == 0 || len(record) == 1 && len(record[0]) == 0 {
+			continue
+		}
+		if len(record) < 5 {
+			line, _ := csvr.FieldPos(0)
+			return 0, fmt.Errorf("invalid format on line %d: %d fields (expected at least 5)", line, len(record))
+		}
+
+		c, err := csvauth.FromRecord(record)
+		if err != nil {
+			return 0, err
+		}
+		if err := store(ctx, tx, c); err != nil {
+			return 0, err
+		}
+		n += 1
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+var _ auth.BasicAuthenticator = (*Auth)(nil)
+var _ csvauth.BasicAuthVerifier = (*Auth)(nil)
@@ -0,0 +1,245 @@
+package sqlauth_test
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/therootcompany/golib/auth/csvauth"
+	"github.com/therootcompany/golib/auth/sqlauth"
+	"github.com/therootcompany/golib/database/sqlmigrate"
+	"github.com/therootcompany/golib/database/sqlmigrate/litemigrate"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// openDB opens a migrated SQLite database in a temp dir (rather than
+// :memory:, which would give each pooled connection its own database).
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	ctx := t.Context()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("conn: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	scripts, err := sqlmigrate.Collect(sqlauth.Migrations, sqlauth.MigrationsDir)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if _, err := sqlmigrate.Latest(ctx, litemigrate.New(conn), scripts); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	return db
+}
+
+func TestAuthenticate(t *testing.T) {
+	ctx := t.Context()
+	a := sqlauth.New(openDB(t), testKey)
+
+	type testCase struct {
+		purpose string
+		name    string
+		secret  string
+		params  []string
+		roles   []string
+	}
+	tests := []testCase{
+		{csvauth.PurposeDefault, "pbkdf2-user", "pass1", []string{"pbkdf2"}, []string{"admin"}},
+		{csvauth.PurposeDefault, "bcrypt-user", "pass2", []string{"bcrypt", "4"}, nil},
+		{csvauth.PurposeDefault, "aes-user", "pass3", []string{"aes-128-gcm"}, []string{"audit", "triage"}},
+		{csvauth.PurposeDefault, "plain-user", "pass4", []string{"plain"}, nil},
+		{csvauth.PurposeToken, "ci-bot", "token-plain", []string{"plain"}, []string{"deploy"}},
+		{csvauth.PurposeToken, "ci-bot", "token-aes", []string{"aes-128-gcm"}, []string{"deploy"}},
+	}
+
+	for _, tc := range tests {
+		c := a.NewCredential(tc.purpose, tc.name, tc.secret, tc.params, tc.roles, "")
+		if err := a.Store(ctx, *c); err != nil {
+			t.Fatalf("Store(%s): %v", tc.name, err)
+		}
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.params[0]+"/"+tc.name, func(t *testing.T) {
+			name := tc.name
+			if tc.purpose == csvauth.PurposeToken {
+				name = ""
+			}
+
+			p, err := a.Authenticate(name, tc.secret)
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if !strings.HasPrefix(p.ID(), tc.name) {
+				t.Errorf("ID() = %q, want prefix %q", p.ID(), tc.name)
+			}
+			if !slices.Equal(p.Permissions(), tc.roles) {
+				t.Errorf("Permissions() = %q, want %q", p.Permissions(), tc.roles)
+			}
+
+			if _, err := a.Authenticate(name, tc.secret+"x"); err == nil {
+				t.Errorf("Authenticate with wrong secret: want error")
+			}
+		})
+	}
+
+	if _, err := a.Authenticate("api", "token-aes"); err != nil {
+		t.Errorf("Authenticate(\"api\", token): %v", err)
+	}
+	if _, err := a.Authenticate("token-plain", ""); err != nil {
+		t.Errorf("Authenticate(token, \"\"): %v", err)
+	}
+	if _, err := a.Authenticate("nobody", "pass1"); !errors.Is(err, csvauth.ErrNotFound) {
+		t.Errorf("Authenticate(unknown) = %v, want ErrNotFound", err)
+	}
+	if _, err := a.Authenticate("", ""); !errors.Is(err, csvauth.ErrUnauthorized) {
+		t.Errorf("Authenticate(\"\", \"\") = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestStoreReplaceAndDelete(t *testing.T) {
+	ctx := t.Context()
+	a := sqlauth.New(openDB(t), testKey)
+
+	c := a.NewCredential(csvauth.PurposeDefault, "johndoe", "old", []string{"pbkdf2"}, nil, "")
+	if err := a.Store(ctx, *c); err != nil {
+		t.Fatal(err)
+	}
+	c = a.NewCredential(csvauth.PurposeDefault, "johndoe", "new", []string{"pbkdf2"}, []string{"admin"}, "")
+	if err := a.Store(ctx, *c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Verify("johndoe", "old"); err == nil {
+		t.Errorf("old password still verifies after replace")
+	}
+	if err := a.Verify("johndoe", "new"); err != nil {
+		t.Errorf("new password: %v", err)
+	}
+
+	if err := a.Delete(ctx, csvauth.PurposeDefault, "johndoe"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := a.Verify("johndoe", "new"); !errors.Is(err, csvauth.ErrNotFound) {
+		t.Errorf("Verify after Delete = %v, want ErrNotFound", err)
+	}
+	if err := a.Delete(ctx, csvauth.PurposeDefault, "johndoe"); !errors.Is(err, csvauth.ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestServiceAccount(t *testing.T) {
+	ctx := t.Context()
+	a := sqlauth.New(openDB(t), testKey)
+
+	for _, name := range []string{"acme-1", "acme-2"} {
+		c := a.NewCredential("smtp", name, "secret-"+name, []string{"aes-128-gcm"}, nil, "")
+		if err := a.Store(ctx, *c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := a.LoadServiceAccount(ctx, "smtp")
+	if err != nil {
+		t.Fatalf("LoadServiceAccount: %v", err)
+	}
+	if c.Name != "acme-2" || c.Secret() != "secret-acme-2" {
+		t.Errorf("got %q / %q, want the most recently stored acme-2", c.Name, c.Secret())
+	}
+
+	if _, err := a.LoadServiceAccount(ctx, "s3"); !errors.Is(err, csvauth.ErrNotFound) {
+		t.Errorf("LoadServiceAccount(unknown) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx := t.Context()
+	a := sqlauth.New(openDB(t), testKey)
+
+	// build a credentials.tsv the way the csvauth CLI does
+	csvAuth := csvauth.New(testKey)
+	creds := []*csvauth.Credential{
+		csvAuth.NewCredential(csvauth.PurposeDefault, "janedoe", "jane-pass", []string{"bcrypt", "4"}, []string{"admin"}, `{"foo":"bar"}`),
+		csvAuth.NewCredential(csvauth.PurposeToken, "deploy-bot", "deploy-token", []string{"aes-128-gcm"}, []string{"deploy"}, ""),
+		csvAuth.NewCredential("ntfy_sh", "mytopic", "mytopic-1234", []string{"plain"}, nil, ""),
+	}
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	w.Comma = '\t'
+	_ = w.Write([]string{"purpose", "name", "algo", "salt", "derived", "roles", "extra"})
+	for _, c := range creds {
+		_ = w.Write(c.ToRecord())
+	}
+	w.Flush()
+
+	n, err := a.Import(ctx, strings.NewReader(sb.String()), '\t')
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if n != len(creds) {
+		t.Errorf("Import() = %d, want %d", n, len(creds))
+	}
+
+	p, err := a.Authenticate("janedoe", "jane-pass")
+	if err != nil {
+		t.Fatalf("Authenticate(janedoe): %v", err)
+	}
+	if c := p.(*csvauth.Credential); c.Extra != `{"foo":"bar"}` {
+		t.Errorf("Extra = %q", c.Extra)
+	}
+
+	if _, err := a.Authenticate("", "deploy-token"); err != nil {
+		t.Errorf("Authenticate(token): %v", err)
+	}
+
+	c, err := a.LoadServiceAccount(ctx, "ntfy_sh")
+	if err != nil {
+		t.Fatalf("LoadServiceAccount: %v", err)
+	}
+	if c.Secret() != "mytopic-1234" {
+		t.Errorf("Secret() = %q", c.Secret())
+	}
+
+	if _, err := a.Import(ctx, strings.NewReader("purpose\tname\nlogin\tshort\n"), '\t'); err == nil {
+		t.Errorf("Import of short rows: want error")
+	}
+}
+
+func TestMigrationsDown(t *testing.T) {
+	ctx := t.Context()
+	db := openDB(t)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	scripts, err := sqlmigrate.Collect(sqlauth.Migrations, sqlauth.MigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err := sqlmigrate.Drop(ctx, litemigrate.New(conn), scripts)
+	if err != nil {
+		t.Fatalf("Drop: %v", err)
+	}
+	if len(dropped) != len(scripts) {
+		t.Errorf("dropped %d migrations, want %d", len(dropped), len(scripts))
+	}
+}
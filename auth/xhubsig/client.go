@@ -0,0 +1,51 @@
+package xhubsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SignRequest signs an outgoing request the way Require verifies it: a header
+// for each of the Hashes and, if set, the TimestampHeader (now) and a random
+// DeliveryHeader ID (unless one is already set, as for a retry).
+// The body is buffered, and left re-readable for the client and for redirects.
+func (x *XHubSig) SignRequest(r *http.Request) error {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.ContentLength = int64(len(body))
+
+	if x.TimestampHeader != "" {
+		ts := time.Now().Unix()
+		r.Header.Set(x.TimestampHeader, strconv.FormatInt(ts, 10))
+		for _, h := range x.Hashes {
+			r.Header.Set(h.Header, SignTimestamp(h, x.Secret, ts, body))
+		}
+	} else {
+		for _, h := range x.Hashes {
+			r.Header.Set(h.Header, Sign(h, x.Secret, body))
+		}
+	}
+
+	if x.DeliveryHeader != "" && r.Header.Get(x.DeliveryHeader) == "" {
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+		r.Header.Set(x.DeliveryHeader, hex.EncodeToString(id))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package xhubsig
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(x *XHubSig)
+	}{
+		{"default", func(x *XHubSig) {}},
+		{"both hashes", func(x *XHubSig) { x.Hashes = []Hash{SHA256, SHA1} }},
+		{"timestamped", func(x *XHubSig) {
+			x.TimestampHeader = DefaultTimestampHeader
+			x.DeliveryHeader = DefaultDeliveryHeader
+			x.Nonces = NewMemoryNonceCache()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := New(testSecret)
+			tt.setup(x)
+
+			var got string
+			srv := httptest.NewServer(x.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got = string(b)
+			})))
+			defer srv.Close()
+
+			send := func(r *http.Request) int {
+				t.Helper()
+				res, err := http.DefaultClient.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_ = res.Body.Close()
+				return res.StatusCode
+			}
+
+			r, _ := http.NewRequest("POST", srv.URL, strings.NewReader(string(testBody)))
+			if err := x.SignRequest(r); err != nil {
+				t.Fatal(err)
+			}
+			if code := send(r); code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", code, http.StatusOK)
+			}
+			if got != string(testBody) {
+				t.Errorf("body = %q, want %q", got, testBody)
+			}
+
+			if x.Nonces == nil {
+				return
+			}
+			if r.Header.Get(DefaultDeliveryHeader) == "" {
+				t.Error("expected a delivery ID")
+			}
+			replay, _ := http.NewRequest("POST", srv.URL, strings.NewReader(string(testBody)))
+			replay.Header = r.Header.Clone()
+			if code := send(replay); code != http.StatusConflict {
+				t.Errorf("replay: status = %d, want %d", code, http.StatusConflict)
+			}
+		})
+	}
+}
+
+func TestSignRequestNoBody(t *testing.T) {
+	x := New(testSecret)
+	r, _ := http.NewRequest("POST", "http://example.com/", nil)
+	if err := x.SignRequest(r); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.Header.Get(SHA256.Header), Sign(SHA256, testSecret, nil); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
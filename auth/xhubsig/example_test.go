@@ -3,6 +3,7 @@ package xhubsig_test
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/therootcompany/golib/auth/xhubsig"
 )
@@ -33,3 +34,19 @@ func ExampleXHubSig_Require() {
 		w.WriteHeader(http.StatusNoContent)
 	})))
 }
+
+func ExampleXHubSig_SignRequest() {
+	x := xhubsig.New("webhookSecret")
+	x.TimestampHeader = xhubsig.DefaultTimestampHeader
+
+	req, _ := http.NewRequest("POST", "https://example.com/webhook", strings.NewReader(`{"event":"ping"}`))
+	if err := x.SignRequest(req); err != nil {
+		panic(err)
+	}
+
+	ts := req.Header.Get(xhubsig.DefaultTimestampHeader)
+	err := xhubsig.VerifyTimestamp(xhubsig.SHA256, "webhookSecret", []byte(`{"event":"ping"}`), req.Header.Get("X-Hub-Signature-256"), ts, xhubsig.DefaultTolerance)
+	fmt.Println(err)
+	// Output:
+	// <nil>
+}
@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const DefaultLimit = 256 * 1024
@@ -37,6 +38,15 @@ type XHubSig struct {
 	Hashes    []Hash
 	AcceptAny bool
 	Limit     int64
+
+	// TimestampHeader, if set, switches from the GitHub-compatible signature
+	// of the body to SignTimestamp, and rejects timestamps outside the Tolerance
+	TimestampHeader string
+	Tolerance       time.Duration
+	// Nonces, if set, rejects a signature that has already been seen (in
+	// timestamp mode), or a DeliveryHeader ID that has already been seen
+	Nonces         NonceCache
+	DeliveryHeader string
 }
 
 func New(secret string, hashes ...Hash) *XHubSig {
@@ -48,16 +58,22 @@ func New(secret string, hashes ...Hash) *XHubSig {
 		Hashes:    hashes,
 		AcceptAny: false,
 		Limit:     DefaultLimit,
+		Tolerance: DefaultTolerance,
 	}
 }
 
 // signatureHint builds a pseudocode hint showing how to compute each
 // configured signature header using the webhook secret.
 func (x *XHubSig) signatureHint() string {
-	lines := make([]string, len(x.Hashes))
-	for i, h := range x.Hashes {
+	signed := "body"
+	var lines []string
+	if x.TimestampHeader != "" {
+		signed = `timestamp + "." + body`
+		lines = append(lines, fmt.Sprintf("`%s: timestamp` (unix seconds, within %s)", x.TimestampHeader, x.Tolerance))
+	}
+	for _, h := range x.Hashes {
 		algo := strings.TrimSuffix(h.Prefix, "=")
-		lines[i] = fmt.Sprintf("`%s: %shex(hmac_%s(secret, body))`", h.Header, h.Prefix, algo)
+		lines = append(lines, fmt.Sprintf("`%s: %shex(hmac_%s(secret, %s))`", h.Header, h.Prefix, algo, signed))
 	}
 	return strings.Join(lines, "\n")
 }
@@ -81,6 +97,14 @@ func (x *XHubSig) writeHTTPError(w http.ResponseWriter, r *http.Request, errCode
 		httpCode = http.StatusUnauthorized
 		description = "Signature verification failed."
 		hint = detail + "\n" + x.signatureHint()
+	case "missing_timestamp", "invalid_timestamp":
+		httpCode = http.StatusUnauthorized
+		description = "No valid, current timestamp was found."
+		hint = detail + "\n" + x.signatureHint()
+	case "duplicate_delivery":
+		httpCode = http.StatusConflict
+		description = "This delivery has already been received."
+		hint = detail + "; sign each new delivery with a new timestamp and ID."
 	default:
 		httpCode = http.StatusInternalServerError
 		description = "An unexpected error occurred."
@@ -105,6 +129,31 @@ func (x *XHubSig) readBody(r *http.Request) ([]byte, error) {
 	return body, nil
 }
 
+// checkNonces records the delivery ID and (in timestamp mode) the signatures,
+// and describes the first one that had already been seen
+func (x *XHubSig) checkNonces(r *http.Request, ts int64, sigs []string) string {
+	expires := time.Now().Add(x.Tolerance)
+	if x.TimestampHeader != "" {
+		expires = time.Unix(ts, 0).Add(x.Tolerance)
+	}
+
+	var detail string
+	if x.DeliveryHeader != "" {
+		if id := r.Header.Get(x.DeliveryHeader); id != "" && x.Nonces.Seen("delivery:"+id, expires) {
+			detail = fmt.Sprintf("%s %q has already been received", x.DeliveryHeader, id)
+		}
+	}
+	if x.TimestampHeader != "" {
+		// the delivery ID isn't signed, but the timestamp is
+		for _, sig := range sigs {
+			if x.Nonces.Seen("signature:"+sig, expires) && detail == "" {
+				detail = "the signature has already been received"
+			}
+		}
+	}
+	return detail
+}
+
 func (x *XHubSig) Require(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		body, err := x.readBody(r)
@@ -117,6 +166,20 @@ func (x *XHubSig) Require(next http.Handler) http.Handler {
 			return
 		}
 
+		var ts int64
+		if x.TimestampHeader != "" {
+			ts, err = ParseTimestamp(r.Header.Get(x.TimestampHeader), x.Tolerance)
+			switch {
+			case errors.Is(err, ErrMissingTimestamp):
+				x.writeHTTPError(w, r, "missing_timestamp", fmt.Sprintf("%s is required", x.TimestampHeader))
+				return
+			case err != nil:
+				x.writeHTTPError(w, r, "invalid_timestamp", fmt.Sprintf("%s: %s", x.TimestampHeader, err))
+				return
+			}
+		}
+
+		var sigs []string
 		anyPresent := false
 		for _, h := range x.Hashes {
 			sig := r.Header.Get(h.Header)
@@ -128,7 +191,13 @@ func (x *XHubSig) Require(next http.Handler) http.Handler {
 				continue
 			}
 			anyPresent = true
-			if err := Verify(h, x.Secret, body, sig); err != nil {
+			sigs = append(sigs, sig)
+			if x.TimestampHeader != "" {
+				err = verifySignedAt(h, x.Secret, ts, body, sig)
+			} else {
+				err = Verify(h, x.Secret, body, sig)
+			}
+			if err != nil {
 				x.writeHTTPError(w, r, "invalid_signature", fmt.Sprintf("%s value did not match the expected HMAC", h.Header))
 				return
 			}
@@ -142,6 +211,13 @@ func (x *XHubSig) Require(next http.Handler) http.Handler {
 			return
 		}
 
+		if x.Nonces != nil {
+			if detail := x.checkNonces(r, ts, sigs); detail != "" {
+				x.writeHTTPError(w, r, "duplicate_delivery", detail)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,114 @@
+package xhubsig
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimestampHeader carries the unix time (in seconds) that was signed along with the body
+	DefaultTimestampHeader = "X-Hub-Timestamp"
+	// DefaultDeliveryHeader carries a unique ID per delivery, for rejecting duplicates
+	DefaultDeliveryHeader = "X-Hub-Delivery"
+	// DefaultTolerance is how far a timestamp may be from the current time
+	DefaultTolerance = 5 * time.Minute
+)
+
+var (
+	ErrMissingTimestamp = errors.New("missing timestamp")
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	ErrExpiredTimestamp = errors.New("timestamp outside of tolerance")
+	ErrReplayed         = errors.New("duplicate delivery")
+)
+
+// SignTimestamp signs "<timestamp>.<body>" (as do Stripe and Slack), so that
+// the signature is only good for as long as the timestamp is.
+func SignTimestamp(h Hash, secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(h.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return h.Prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyTimestamp checks that the timestamp is within the tolerance of now,
+// and that sig is the SignTimestamp of the timestamp and body.
+func VerifyTimestamp(h Hash, secret string, body []byte, sig, timestamp string, tolerance time.Duration) error {
+	ts, err := ParseTimestamp(timestamp, tolerance)
+	if err != nil {
+		return err
+	}
+	return verifySignedAt(h, secret, ts, body, sig)
+}
+
+func verifySignedAt(h Hash, secret string, ts int64, body []byte, sig string) error {
+	if sig == "" {
+		return ErrMissingSignature
+	}
+	expected := SignTimestamp(h, secret, ts, body)
+	if hmac.Equal([]byte(expected), []byte(sig)) {
+		return nil
+	}
+	return ErrInvalidSignature
+}
+
+// ParseTimestamp parses unix seconds, and checks that they're within the tolerance of now
+func ParseTimestamp(timestamp string, tolerance time.Duration) (int64, error) {
+	if timestamp == "" {
+		return 0, ErrMissingTimestamp
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidTimestamp
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return 0, ErrExpiredTimestamp
+	}
+	return ts, nil
+}
+
+// NonceCache remembers delivery IDs (or signatures), to reject duplicates.
+// Seen must record the id and report false the first time, and report true
+// for the same id until it expires. Implementations must be safe for concurrent use.
+type NonceCache interface {
+	Seen(id string, expires time.Time) bool
+}
+
+// MemoryNonceCache is a NonceCache for a single process.
+// Expired IDs are dropped as new ones are added.
+type MemoryNonceCache struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+	next    time.Time
+}
+
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{expires: map[string]time.Time{}}
+}
+
+func (c *MemoryNonceCache) Seen(id string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.next) {
+		for k, exp := range c.expires {
+			if now.After(exp) {
+				delete(c.expires, k)
+			}
+		}
+		c.next = now.Add(time.Minute)
+	}
+
+	if exp, ok := c.expires[id]; ok && !now.After(exp) {
+		return true
+	}
+	c.expires[id] = expires
+	return false
+}
+
+var _ NonceCache = (*MemoryNonceCache)(nil)
@@ -0,0 +1,164 @@
+package xhubsig
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignTimestamp(t *testing.T) {
+	ts := int64(1700000000)
+	sig := SignTimestamp(SHA256, testSecret, ts, testBody)
+	if want := Sign(SHA256, testSecret, []byte("1700000000.Hello, World!")); sig != want {
+		t.Errorf("SignTimestamp = %q, want the signature of timestamp.body %q", sig, want)
+	}
+	if sig == SignTimestamp(SHA256, testSecret, ts+1, testBody) {
+		t.Error("the timestamp should change the signature")
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	now := time.Now().Unix()
+	sig := SignTimestamp(SHA256, testSecret, now, testBody)
+	nowStr := strconv.FormatInt(now, 10)
+
+	tests := []struct {
+		name      string
+		sig       string
+		timestamp string
+		want      error
+	}{
+		{"valid", sig, nowStr, nil},
+		{"other timestamp", sig, strconv.FormatInt(now-1, 10), ErrInvalidSignature},
+		{"body-only signature", Sign(SHA256, testSecret, testBody), nowStr, ErrInvalidSignature},
+		{"missing signature", "", nowStr, ErrMissingSignature},
+		{"missing timestamp", sig, "", ErrMissingTimestamp},
+		{"not a number", sig, "yesterday", ErrInvalidTimestamp},
+		{"too old", SignTimestamp(SHA256, testSecret, now-600, testBody), strconv.FormatInt(now-600, 10), ErrExpiredTimestamp},
+		{"too new", SignTimestamp(SHA256, testSecret, now+600, testBody), strconv.FormatInt(now+600, 10), ErrExpiredTimestamp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyTimestamp(SHA256, testSecret, testBody, tt.sig, tt.timestamp, DefaultTolerance)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyTimestamp = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryNonceCache(t *testing.T) {
+	c := NewMemoryNonceCache()
+	later := time.Now().Add(time.Minute)
+	if c.Seen("a", later) {
+		t.Error("a: first time should not be seen")
+	}
+	if !c.Seen("a", later) {
+		t.Error("a: second time should be seen")
+	}
+	if c.Seen("b", later) {
+		t.Error("b: first time should not be seen")
+	}
+
+	past := time.Now().Add(-time.Second)
+	c.Seen("expired", past)
+	if c.Seen("expired", later) {
+		t.Error("an expired id should not be seen")
+	}
+}
+
+func newTimestampedRequest(t *testing.T, ts int64, delivery string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(testBody))
+	r.Header.Set(DefaultTimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(SHA256.Header, SignTimestamp(SHA256, testSecret, ts, testBody))
+	if delivery != "" {
+		r.Header.Set(DefaultDeliveryHeader, delivery)
+	}
+	return r
+}
+
+func TestRequireTimestamp(t *testing.T) {
+	x := New(testSecret)
+	x.TimestampHeader = DefaultTimestampHeader
+	x.DeliveryHeader = DefaultDeliveryHeader
+	x.Nonces = NewMemoryNonceCache()
+	handler := x.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	now := time.Now().Unix()
+	bodyOnly := newSignedRequest(t, testBody, SHA256)
+	bodyOnly.Header.Set(DefaultTimestampHeader, strconv.FormatInt(now, 10))
+	noTimestamp := newTimestampedRequest(t, now, "")
+	noTimestamp.Header.Del(DefaultTimestampHeader)
+
+	tests := []struct {
+		name  string
+		r     *http.Request
+		want  int
+		error string
+	}{
+		{"valid", newTimestampedRequest(t, now, "d1"), http.StatusNoContent, ""},
+		{"replayed", newTimestampedRequest(t, now, "d1"), http.StatusConflict, "duplicate_delivery"},
+		{"replayed with a new delivery ID", newTimestampedRequest(t, now, "d2"), http.StatusConflict, "duplicate_delivery"},
+		{"retried with a new timestamp", newTimestampedRequest(t, now-1, "d1"), http.StatusConflict, "duplicate_delivery"},
+		{"new delivery", newTimestampedRequest(t, now-2, "d3"), http.StatusNoContent, ""},
+		{"no delivery ID", newTimestampedRequest(t, now-3, ""), http.StatusNoContent, ""},
+		{"expired", newTimestampedRequest(t, now-3600, "d4"), http.StatusUnauthorized, "invalid_timestamp"},
+		{"missing timestamp", noTimestamp, http.StatusUnauthorized, "missing_timestamp"},
+		{"body-only signature", bodyOnly, http.StatusUnauthorized, "invalid_signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.error != "" && !strings.Contains(w.Body.String(), tt.error) {
+				t.Errorf("body = %q, want %s", w.Body.String(), tt.error)
+			}
+		})
+	}
+
+	// the hint explains what to sign
+	r := newTimestampedRequest(t, now-3600, "")
+	r.Header.Set("Accept", "text/markdown")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `timestamp + "." + body`) {
+		t.Errorf("hint = %q, want the timestamped scheme", w.Body.String())
+	}
+}
+
+func TestRequireDeliveryWithoutTimestamp(t *testing.T) {
+	x := New(testSecret)
+	x.DeliveryHeader = "X-GitHub-Delivery"
+	x.Nonces = NewMemoryNonceCache()
+	handler := x.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusConflict} {
+		r := newSignedRequest(t, testBody, SHA256)
+		r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("delivery %d: status = %d, want %d", i, w.Code, want)
+		}
+	}
+
+	// the same body, without a delivery ID, is not a duplicate in body-only mode
+	for range 2 {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newSignedRequest(t, testBody, SHA256))
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+}
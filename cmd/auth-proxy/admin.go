@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+// AdminCredential is a credential, as shown by the admin listener (without secrets)
+type AdminCredential struct {
+	ID      string   `json:"id"`
+	Purpose string   `json:"purpose"`
+	Roles   []string `json:"roles"`
+	Count
+}
+
+// CheckRequest asks whether a credential (by ID, or "" for a guest) may make a request
+type CheckRequest struct {
+	Credential string `json:"credential"`
+	Method     string `json:"method"`
+	Host       string `json:"host"`
+	Path       string `json:"path"`
+}
+
+// CheckResult is the proxy's decision for a CheckRequest
+type CheckResult struct {
+	ID      string   `json:"id,omitempty"`
+	Allowed bool     `json:"allowed"`
+	Status  int      `json:"status"`
+	Grant   string   `json:"grant,omitempty"`
+	Reason  string   `json:"reason,omitempty"`
+	Allow   []string `json:"allow,omitempty"`
+}
+
+// isLoopback reports whether a listen address is bound only to localhost
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newAdminHandler serves credential inspection, decision checks, and reloads.
+// Unless open (bound to localhost), callers must have the admin role.
+func (cli *MainConfig) newAdminHandler(open bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /credentials", cli.adminCredentials)
+	mux.HandleFunc("GET /counters", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, cli.counters.All())
+	})
+	mux.HandleFunc("POST /check", cli.adminCheck)
+	mux.HandleFunc("POST /reload", cli.adminReload)
+	if open {
+		return mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := cli.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", cli.ra.BasicRealm)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !slices.Contains(p.Permissions(), cli.AdminRole) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminCredentials lists the IDs, purposes, and roles of the credentials, with their counts
+func (cli *MainConfig) adminCredentials(w http.ResponseWriter, r *http.Request) {
+	a := creds.Load()
+	list := []AdminCredential{}
+	for key := range a.CredentialKeys() {
+		c, err := a.LoadCredential(key)
+		if err != nil {
+			continue
+		}
+		list = append(list, AdminCredential{
+			ID:      c.ID(),
+			Purpose: c.Purpose,
+			Roles:   c.Roles,
+			Count:   cli.counters.Get(c.ID()),
+		})
+	}
+	slices.SortFunc(list, func(a, b AdminCredential) int { return strings.Compare(a.ID, b.ID) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// adminCheck makes the same decision as the proxy would, without making the request
+func (cli *MainConfig) adminCheck(w http.ResponseWriter, r *http.Request) {
+	var req CheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Method = strings.ToUpper(req.Method); req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		http.Error(w, "Bad Request: path must start with /", http.StatusBadRequest)
+		return
+	}
+
+	var p auth.BasicPrinciple
+	if req.Credential == "" || req.Credential == "guest" {
+		p, _ = creds.Authenticate("guest", "")
+	} else {
+		c, err := creds.Load().LoadCredential(req.Credential)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Not Found: no credential %q", req.Credential), http.StatusNotFound)
+			return
+		}
+		p = &c
+	}
+
+	result := CheckResult{Status: http.StatusUnauthorized, Reason: ErrNoAuth.Error()}
+	if p != nil {
+		result.ID = p.ID()
+		d, err := authorizePrinciple(p, req.Method, req.Host, req.Path)
+		result.Grant = d.Grant
+		result.Allow = d.Allow
+		if err != nil {
+			result.Status = denyStatus(p, err)
+			result.Reason = err.Error()
+		} else {
+			result.Allowed = true
+			result.Status = http.StatusOK
+			result.Reason = ""
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// adminReload re-reads the credentials file, keeping the current credentials if it's invalid
+func (cli *MainConfig) adminReload(w http.ResponseWriter, r *http.Request) {
+	a, err := loadCredentials(cli.CredentialsPath, cli.comma, cli.aesKey)
+	if err != nil {
+		log.Printf("Failed to reload credentials (keeping the current ones): %v", err)
+		http.Error(w, "Unprocessable Entity: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	creds.Store(a)
+
+	var n int
+	for range a.CredentialKeys() {
+		n++
+	}
+	fmt.Fprintf(os.Stderr, "Reloaded %d credentials from %s\n", n, cli.CredentialsPath)
+	writeJSON(w, http.StatusOK, map[string]int{"credentials": n})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
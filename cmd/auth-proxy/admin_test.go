@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// adminDo sends a request to the admin handler, and decodes the JSON response into v
+func adminDo(t *testing.T, h http.Handler, method, path, body, token string, v any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, "http://localhost"+path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v\n%s", method, path, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestAdmin(t *testing.T) {
+	upstream := echoUpstream(t, "app")
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	proxy := cli.newAuthProxyHandler()
+	admin := cli.newAdminHandler(true)
+
+	for _, method := range []string{"GET", "GET", "POST"} {
+		r := httptest.NewRequest(method, "http://example.com/dashboard", nil)
+		r.Header.Set("Authorization", "Bearer jane-token")
+		proxy.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	t.Run("credentials", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://localhost/credentials", nil)
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, r)
+		if strings.Contains(w.Body.String(), "jane-token") {
+			t.Errorf("secrets must not be shown:\n%s", w.Body.String())
+		}
+
+		var list []AdminCredential
+		if code := adminDo(t, admin, "GET", "/credentials", "", "", &list); code != http.StatusOK {
+			t.Fatalf("%d", code)
+		}
+		i := slices.IndexFunc(list, func(c AdminCredential) bool { return c.ID == "jane~7f19xC0B" })
+		if i < 0 {
+			t.Fatalf("jane is missing from %+v", list)
+		}
+		jane := list[i]
+		if jane.Purpose != "token" || !slices.Equal(jane.Roles, []string{"GET:/"}) || jane.Allowed != 2 || jane.Denied != 1 {
+			t.Errorf("jane: %+v", jane)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		tests := []struct {
+			req    CheckRequest
+			status int
+			grant  string
+			allow  []string
+		}{
+			{CheckRequest{"jane~7f19xC0B", "get", "example.com", "/dashboard"}, http.StatusOK, "GET:/", nil},
+			{CheckRequest{"jane~7f19xC0B", "DELETE", "example.com", "/dashboard"}, http.StatusMethodNotAllowed, "", []string{"GET"}},
+			{CheckRequest{"admin~" + creds.Load().TokenID("admin-token"), "DELETE", "", "/admin/users"}, http.StatusForbidden, "!DELETE:/admin/", nil},
+			{CheckRequest{"", "GET", "", "/public/x"}, http.StatusOK, "GET:/public/", nil},
+			{CheckRequest{"guest", "GET", "", "/dashboard"}, http.StatusUnauthorized, "", nil},
+		}
+		for _, tt := range tests {
+			body, _ := json.Marshal(tt.req)
+			var got CheckResult
+			if code := adminDo(t, admin, "POST", "/check", string(body), "", &got); code != http.StatusOK {
+				t.Fatalf("%s: %d", body, code)
+			}
+			if got.Status != tt.status || got.Allowed != (tt.status == http.StatusOK) || got.Grant != tt.grant || !slices.Equal(got.Allow, tt.allow) {
+				t.Errorf("%s:\n got %+v\nwant %d %q %q", body, got, tt.status, tt.grant, tt.allow)
+			}
+			if !got.Allowed && got.Reason == "" {
+				t.Errorf("%s: missing the reason", body)
+			}
+		}
+
+		if code := adminDo(t, admin, "POST", "/check", `{"credential":"nobody","path":"/"}`, "", nil); code != http.StatusNotFound {
+			t.Errorf("unknown credential: %d", code)
+		}
+		if code := adminDo(t, admin, "POST", "/check", `{"path":"dashboard"}`, "", nil); code != http.StatusBadRequest {
+			t.Errorf("bad path: %d", code)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		dir := t.TempDir()
+		cli.CredentialsPath = filepath.Join(dir, "credentials.tsv")
+		cli.comma = '\t'
+		cli.aesKey = make([]byte, 16)
+
+		a := csvauth.New(cli.aesKey)
+		ops := a.NewCredential(csvauth.PurposeToken, "ops", "ops-token", []string{"plain"}, []string{"/", "admin"}, "")
+		f, err := os.Create(cli.CredentialsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cw := csv.NewWriter(f)
+		cw.Comma = '\t'
+		_ = cw.Write([]string{"purpose", "name", "algo", "salt", "derived", "roles", "extra"})
+		_ = cw.Write(ops.ToRecord())
+		cw.Flush()
+		_ = f.Close()
+
+		var got map[string]int
+		if code := adminDo(t, admin, "POST", "/reload", "", "", &got); code != http.StatusOK || got["credentials"] != 1 {
+			t.Fatalf("%d %v", code, got)
+		}
+
+		// jane is gone, and ops is in
+		for token, want := range map[string]int{"jane-token": http.StatusUnauthorized, "ops-token": http.StatusOK} {
+			r := httptest.NewRequest("GET", "http://example.com/dashboard", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+			if w.Code != want {
+				t.Errorf("%s: %d, want %d", token, w.Code, want)
+			}
+		}
+
+		// a broken file keeps the current credentials
+		if err := os.WriteFile(cli.CredentialsPath, []byte("purpose\tname\nnope"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if code := adminDo(t, admin, "POST", "/reload", "", "", nil); code != http.StatusUnprocessableEntity {
+			t.Errorf("broken file: %d", code)
+		}
+		if _, err := creds.Authenticate("", "ops-token"); err != nil {
+			t.Errorf("expected to keep the current credentials: %v", err)
+		}
+	})
+
+	t.Run("admin role", func(t *testing.T) {
+		cli.AdminRole = "admin"
+		protected := cli.newAdminHandler(false)
+		for token, want := range map[string]int{"": http.StatusUnauthorized, "ops-token": http.StatusOK} {
+			if code := adminDo(t, protected, "GET", "/counters", "", token, nil); code != want {
+				t.Errorf("%q: %d, want %d", token, code, want)
+			}
+		}
+
+		// without the role
+		a := creds.Load()
+		viewer := a.NewCredential(csvauth.PurposeToken, "viewer", "viewer-token", []string{"plain"}, []string{"/"}, "")
+		_ = a.CacheCredential(*viewer)
+		if code := adminDo(t, protected, "GET", "/counters", "", "viewer-token", nil); code != http.StatusForbidden {
+			t.Errorf("viewer: %d", code)
+		}
+	})
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"localhost:3001": true,
+		"127.0.0.1:3001": true,
+		"[::1]:3001":     true,
+		":3001":          false,
+		"0.0.0.0:3001":   false,
+		"10.0.0.1:3001":  false,
+		"localhost":      false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v", addr, got)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one line of the audit log, for one authorization decision
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	IP           string    `json:"ip"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	ID           string    `json:"id,omitempty"`
+	Method       string    `json:"method"`
+	Host         string    `json:"host"`
+	Path         string    `json:"path"`
+	Grant        string    `json:"grant,omitempty"`  // the grant that allowed the request
+	Denied       string    `json:"denied,omitempty"` // the reason the request was not allowed
+	Upstream     string    `json:"upstream,omitempty"`
+	Status       int       `json:"status"`
+	LatencyMS    float64   `json:"latency_ms"`
+}
+
+// AuditLog writes entries as JSON lines
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditLog returns an AuditLog that writes to w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// Log writes the entry, reporting (rather than returning) write errors
+func (a *AuditLog) Log(e *AuditEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(line); err != nil {
+		fmt.Fprintf(os.Stderr, "audit log: %v\n", err)
+	}
+}
+
+// newAuditEntry starts an entry for the request from the client ip
+func newAuditEntry(r *http.Request, ip string) *AuditEntry {
+	return &AuditEntry{
+		Time:         time.Now(),
+		IP:           ip,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		Method:       r.Method,
+		Host:         r.Host,
+		Path:         r.URL.Path,
+	}
+}
+
+// statusWriter records the response status for the audit log
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to flush, hijack, and set deadlines
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// RotatingFile is an append-only file that is renamed to path.1 (and path.1
+// to path.2, and so on, up to MaxBackups) once a write would exceed MaxSize
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64 // 0 for no rotation
+	MaxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens (or creates) path for appending
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would push the file past MaxSize
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	if rf.MaxBackups < 1 {
+		_ = os.Remove(rf.Path)
+	} else {
+		_ = os.Remove(fmt.Sprintf("%s.%d", rf.Path, rf.MaxBackups))
+		for i := rf.MaxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", rf.Path, i), fmt.Sprintf("%s.%d", rf.Path, i+1))
+		}
+		if err := os.Rename(rf.Path, rf.Path+".1"); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+// Close closes the current file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
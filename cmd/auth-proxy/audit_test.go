@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/therootcompany/golib/auth/grants"
+)
+
+func TestAuditLog(t *testing.T) {
+	upstream := echoUpstream(t, "app")
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	var buf bytes.Buffer
+	cli.audit = NewAuditLog(&buf)
+	h := cli.newAuthProxyHandler()
+
+	for _, req := range []struct {
+		method string
+		path   string
+		token  string
+	}{
+		{"GET", "/dashboard", "jane-token"},
+		{"POST", "/dashboard", "jane-token"},
+		{"GET", "/public/x", ""},
+		{"GET", "/", "wrong-token"},
+	} {
+		r := httptest.NewRequest(req.method, "http://example.com"+req.path, nil)
+		r.RemoteAddr = "192.0.2.1:54321"
+		if req.token != "" {
+			r.Header.Set("Authorization", "Bearer "+req.token)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 audit lines, got %d:\n%s", len(lines), buf.String())
+	}
+	want := []AuditEntry{
+		{ID: "jane~7f19xC0B", Method: "GET", Path: "/dashboard", Grant: "GET:/", Upstream: upstream.URL, Status: http.StatusOK},
+		{ID: "jane~7f19xC0B", Method: "POST", Path: "/dashboard", Denied: grants.ErrMethodNotAllowed.Error(), Status: http.StatusMethodNotAllowed},
+		{ID: "guest", Method: "GET", Path: "/public/x", Grant: "GET:/public/", Upstream: upstream.URL, Status: http.StatusOK},
+		{Method: "GET", Path: "/", Status: http.StatusUnauthorized},
+	}
+	for i, line := range lines {
+		var got AuditEntry
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		w := want[i]
+		if got.ID != w.ID || got.Method != w.Method || got.Path != w.Path || got.Grant != w.Grant ||
+			got.Upstream != w.Upstream || got.Status != w.Status || got.IP != "192.0.2.1" || got.Host != "example.com" {
+			t.Errorf("line %d:\n got %s\nwant %+v", i, line, w)
+		}
+		if w.Denied != "" && got.Denied != w.Denied {
+			t.Errorf("line %d: denied %q, want %q", i, got.Denied, w.Denied)
+		}
+		if w.Status >= 400 && got.Denied == "" {
+			t.Errorf("line %d: missing the reason for denial", i)
+		}
+		if got.Time.IsZero() || got.LatencyMS < 0 {
+			t.Errorf("line %d: time %v, latency %v", i, got.Time, got.LatencyMS)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rf.Close() }()
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "dddddd\n",
+		path + ".1": "cccccc\n",
+		path + ".2": "bbbbbb\n",
+	} {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 backups, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyAuthorize(t *testing.T) {
+	upstream := echoUpstream(t, "app")
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	h := cli.newAuthProxyHandler()
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		want   int
+		allow  string
+	}{
+		{"allowed", "jane-token", "GET", "/dashboard", http.StatusOK, ""},
+		{"method not allowed", "jane-token", "DELETE", "/dashboard", http.StatusMethodNotAllowed, "GET"},
+		{"no matching grant", "viewer-token", "GET", "/dashboard", http.StatusForbidden, ""},
+		{"deny grant", "admin-token", "DELETE", "/admin/users/1", http.StatusForbidden, ""},
+		{"deny grant other method", "admin-token", "GET", "/admin/users/1", http.StatusOK, ""},
+		{"deny grant other path", "admin-token", "DELETE", "/users/1", http.StatusOK, ""},
+		// guests may yet log in as someone who is allowed
+		{"guest", "", "GET", "/dashboard", http.StatusUnauthorized, ""},
+		{"guest method", "", "POST", "/public/", http.StatusUnauthorized, ""},
+		{"bad token", "wrong-token", "GET", "/public/", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("%d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow: %q, want %q", got, tt.allow)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); (got != "") != (tt.want == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// Credentials holds the current csvauth.Auth, which may be replaced (re-read) while in use
+type Credentials struct {
+	atomic.Pointer[csvauth.Auth]
+}
+
+// NewCredentials returns Credentials that start with a
+func NewCredentials(a *csvauth.Auth) *Credentials {
+	c := &Credentials{}
+	c.Store(a)
+	return c
+}
+
+// Authenticate implements auth.BasicAuthenticator with the current credentials
+func (c *Credentials) Authenticate(name, secret string) (auth.BasicPrinciple, error) {
+	return c.Load().Authenticate(name, secret)
+}
+
+// loadCredentials reads a credentials file
+func loadCredentials(path string, comma rune, aesKey []byte) (*csvauth.Auth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	a := csvauth.New(aesKey)
+	if err := a.LoadCSV(f, comma); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Counters counts the requests that each principle was allowed and denied
+type Counters struct {
+	mu     sync.Mutex
+	counts map[string]*Count
+}
+
+// Count is the number of requests allowed and denied
+type Count struct {
+	Allowed int64 `json:"allowed"`
+	Denied  int64 `json:"denied"`
+}
+
+// NewCounters returns empty Counters
+func NewCounters() *Counters {
+	return &Counters{counts: map[string]*Count{}}
+}
+
+// Add counts a request for the id
+func (c *Counters) Add(id string, allowed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.counts[id]
+	if !ok {
+		n = &Count{}
+		c.counts[id] = n
+	}
+	if allowed {
+		n.Allowed++
+	} else {
+		n.Denied++
+	}
+}
+
+// Get returns the counts for the id
+func (c *Counters) Get(id string) Count {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.counts[id]; ok {
+		return *n
+	}
+	return Count{}
+}
+
+// All returns a copy of all counts, by id
+func (c *Counters) All() map[string]Count {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	all := make(map[string]Count, len(c.counts))
+	for id, n := range c.counts {
+		all[id] = *n
+	}
+	return all
+}
@@ -1,11 +1,17 @@
 module github.com/therootcompany/golib/cmd/auth-proxy
 
-go 1.25.0
+go 1.26.1
 
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/therootcompany/golib/auth v1.1.1
 	github.com/therootcompany/golib/auth/csvauth v1.2.4
+	github.com/therootcompany/golib/auth/grants v1.0.0
+	github.com/therootcompany/golib/auth/jwt v1.0.0
+	github.com/therootcompany/golib/auth/jwtauth v1.0.0
+	github.com/therootcompany/golib/auth/oidc v1.0.0
+	github.com/therootcompany/golib/auth/session v1.0.0
+	golang.org/x/time v0.14.0
 )
 
 require golang.org/x/crypto v0.42.0 // indirect
@@ -13,4 +19,9 @@ require golang.org/x/crypto v0.42.0 // indirect
 replace (
 	github.com/therootcompany/golib/auth => ../../auth
 	github.com/therootcompany/golib/auth/csvauth => ../../auth/csvauth
+	github.com/therootcompany/golib/auth/grants => ../../auth/grants
+	github.com/therootcompany/golib/auth/jwt => ../../auth/jwt
+	github.com/therootcompany/golib/auth/jwtauth => ../../auth/jwtauth
+	github.com/therootcompany/golib/auth/oidc => ../../auth/oidc
+	github.com/therootcompany/golib/auth/session => ../../auth/session
 )
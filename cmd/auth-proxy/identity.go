@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+	"github.com/therootcompany/golib/auth/jwt"
+	"github.com/therootcompany/golib/auth/jwt/keyfile"
+	"github.com/therootcompany/golib/auth/jwtauth"
+	"github.com/therootcompany/golib/auth/session"
+)
+
+// Identity tells upstreams who the (authorized) caller is, either as plain
+// headers, or as a short-lived JWT that they can verify with the proxy's JWKs
+type Identity struct {
+	UserHeader    string // BasicPrinciple.ID()
+	RolesHeader   string // BasicPrinciple.Permissions(), space-delimited
+	PurposeHeader string // how the caller logged in (see principlePurpose)
+
+	// JWTHeader, if set, carries a signed JWT instead of the plain headers
+	JWTHeader string
+	Signer    *jwt.Signer
+	Issuer    string
+	TTL       time.Duration
+}
+
+// identityClaims are the claims of the identity JWT.
+// The audience is the Host that the request was sent to.
+type identityClaims struct {
+	jwt.TokenClaims
+	Roles   []string `json:"roles,omitempty"`
+	Purpose string   `json:"purpose,omitempty"`
+}
+
+// headerNames returns the names of all configured identity headers
+func (id *Identity) headerNames() []string {
+	var names []string
+	for _, name := range []string{id.UserHeader, id.RolesHeader, id.PurposeHeader, id.JWTHeader} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Strip removes identity headers sent by the client, so that they can't be spoofed
+func (id *Identity) Strip(h http.Header) {
+	for _, name := range id.headerNames() {
+		h.Del(name)
+	}
+}
+
+// Headers returns the identity headers for p, for a request to host
+func (id *Identity) Headers(p auth.BasicPrinciple, host string) (http.Header, error) {
+	h := http.Header{}
+	roles := p.Permissions()
+	purpose := principlePurpose(p)
+
+	if id.JWTHeader != "" {
+		now := time.Now()
+		claims := &identityClaims{Roles: roles, Purpose: purpose}
+		claims.Iss = id.Issuer
+		claims.Sub = p.ID()
+		claims.Aud = jwt.Listish{host}
+		claims.IAt = now.Unix()
+		claims.Exp = now.Add(id.TTL).Unix()
+		claims.JTI = rand.Text()
+		token, err := id.Signer.SignToString(claims)
+		if err != nil {
+			return nil, fmt.Errorf("sign identity: %w", err)
+		}
+		h.Set(id.JWTHeader, token)
+		return h, nil
+	}
+
+	if id.UserHeader != "" {
+		h.Set(id.UserHeader, headerValue(p.ID()))
+	}
+	if id.RolesHeader != "" {
+		h.Set(id.RolesHeader, headerValue(strings.Join(roles, " ")))
+	}
+	if id.PurposeHeader != "" && purpose != "" {
+		h.Set(id.PurposeHeader, purpose)
+	}
+	return h, nil
+}
+
+// principlePurpose returns the credential's Purpose for csvauth, or else the kind of login
+func principlePurpose(p auth.BasicPrinciple) string {
+	switch p := p.(type) {
+	case *csvauth.Credential:
+		return p.Purpose
+	case *CertPrinciple:
+		return "cert"
+	case *session.Session:
+		return "session"
+	case *jwtauth.Principle:
+		return "jwt"
+	default:
+		return ""
+	}
+}
+
+// headerValue replaces control characters, which are not allowed in header values
+func headerValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// loadIdentitySigner reads a private key (PEM, or JWK if it ends in .json or .jwk),
+// or generates one (in which case upstreams must re-fetch the keys on restart)
+func loadIdentitySigner(keyPath string) (*jwt.Signer, error) {
+	var pk *jwt.PrivateKey
+	var err error
+	switch {
+	case keyPath == "":
+		pk, err = jwt.NewPrivateKey()
+	case filepath.Ext(keyPath) == ".json" || filepath.Ext(keyPath) == ".jwk":
+		pk, err = keyfile.LoadPrivateJWK(keyPath)
+	default:
+		pk, err = keyfile.LoadPrivatePEM(keyPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewSigner([]*jwt.PrivateKey{pk})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth/jwt"
+)
+
+// headersUpstream responds with the identity headers it was given, as JSON
+func headersUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := map[string]string{}
+		for _, name := range []string{"X-Auth-User", "X-Auth-Roles", "X-Auth-Purpose", "X-Auth-JWT"} {
+			got[name] = r.Header.Get(name)
+		}
+		_ = json.NewEncoder(w).Encode(got)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestIdentityHeaders(t *testing.T) {
+	upstream := headersUpstream(t)
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	cli.Identity = Identity{UserHeader: "X-Auth-User", RolesHeader: "X-Auth-Roles", PurposeHeader: "X-Auth-Purpose"}
+	h := cli.newAuthProxyHandler()
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		want  map[string]string
+	}{
+		// a token's id is its name and a hash of the token (see csvauth.Credential.ID)
+		{"token", "jane-token", "/", map[string]string{"X-Auth-User": "jane~7f19xC0B", "X-Auth-Roles": "GET:/", "X-Auth-Purpose": "token"}},
+		{"guest", "", "/public/", map[string]string{"X-Auth-User": "guest", "X-Auth-Roles": "GET:/public/", "X-Auth-Purpose": "login"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			// spoofed by the client
+			r.Header.Set("X-Auth-User", "admin")
+			r.Header.Set("X-Auth-Roles", "/")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%d %s", w.Code, w.Body.String())
+			}
+
+			var got map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s: %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityJWT(t *testing.T) {
+	upstream := headersUpstream(t)
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	signer, err := loadIdentitySigner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Identity = Identity{
+		UserHeader: "X-Auth-User",
+		JWTHeader:  "X-Auth-JWT",
+		Signer:     signer,
+		Issuer:     "auth-proxy",
+		TTL:        time.Minute,
+	}
+	h := cli.newAuthProxyHandler()
+
+	// the public keys are served without auth
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", jwksPath, nil))
+	jwks, err := jwt.ParseWellKnownJWKs(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("jwks: %v\n%s", err, w.Body.String())
+	}
+	verifier, err := jwt.NewVerifier(jwks.Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer jane-token")
+	r.Header.Set("X-Auth-User", "admin")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var got map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%d %v: %s", w.Code, err, w.Body.String())
+	}
+	if got["X-Auth-User"] != "" {
+		t.Errorf("plain headers should not be sent with a JWT, got X-Auth-User %q", got["X-Auth-User"])
+	}
+
+	jws, err := verifier.VerifyJWT(got["X-Auth-JWT"])
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	var claims identityClaims
+	if err := jws.UnmarshalClaims(&claims); err != nil {
+		t.Fatal(err)
+	}
+	v := jwt.NewIDTokenValidator([]string{"auth-proxy"}, []string{"example.com"}, nil)
+	v.Checks &^= jwt.CheckAuthTime
+	if err := v.Validate(nil, &claims, time.Now()); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if claims.Sub != "jane~7f19xC0B" || claims.Purpose != "token" || !slices.Equal(claims.Roles, []string{"GET:/"}) {
+		t.Errorf("claims: %+v", claims)
+	}
+}
@@ -14,14 +14,17 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"iter"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -29,6 +32,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -37,7 +41,10 @@ import (
 	"github.com/joho/godotenv"
 
 	"github.com/therootcompany/golib/auth"
-	"github.com/therootcompany/golib/auth/csvauth"
+	"github.com/therootcompany/golib/auth/grants"
+	"github.com/therootcompany/golib/auth/jwtauth"
+	"github.com/therootcompany/golib/auth/oidc"
+	"github.com/therootcompany/golib/auth/session"
 )
 
 const (
@@ -65,13 +72,26 @@ var (
 	ErrNoAuth = errors.New("request missing the required form of authorization")
 )
 
-var creds *csvauth.Auth
+var creds *Credentials
+
+const (
+	loginPath        = "/_auth/login"
+	logoutPath       = "/_auth/logout"
+	oidcLoginPath    = "/_auth/oidc/login"
+	oidcCallbackPath = "/_auth/oidc/callback"
+	jwksPath         = "/_auth/jwks.json"
+)
+
+// identityKey is the request context key for the upstream identity headers
+type identityKey struct{}
 
 type MainConfig struct {
 	Address                    string
 	Port                       int
 	CredentialsPath            string
 	ProxyTarget                string
+	RoutesPath                 string
+	routes                     Routes
 	AES128KeyPath              string
 	ShowVersion                bool
 	BasicRealm                 string
@@ -83,6 +103,42 @@ type MainConfig struct {
 	tokenSchemeList            string
 	tokenHeaderList            string
 	tokenParamList             string
+	IssuerURL                  string
+	Audiences                  []string
+	RolesClaim                 string
+	audienceList               string
+	Sessions                   bool
+	SessionTTL                 time.Duration
+	sessions                   *session.Manager
+	OIDCIssuerURL              string
+	OIDCClientID               string
+	OIDCRedirectURL            string
+	OIDCGrantsPath             string
+	OIDCGroupsAsGrants         bool
+	OIDCGroupsClaim            string
+	oidc                       *oidc.RelyingParty
+	Identity                   Identity
+	IdentityKeyPath            string
+	TrustForwardedFor          bool
+	RateLimit                  string
+	AuthFailLimit              string
+	rateLimit                  *RateLimit
+	authFailLimit              *RateLimit
+	limiters                   *Limiters
+	TLSCertPath                string
+	TLSKeyPath                 string
+	TLSClientCAPath            string
+	TLSClientID                string
+	StreamHeartbeat            time.Duration
+	StreamIdleTimeout          time.Duration
+	AuditLogPath               string
+	AuditLogMaxMB              int
+	AuditLogBackups            int
+	audit                      *AuditLog
+	AdminAddress               string
+	AdminRole                  string
+	counters                   *Counters
+	aesKey                     []byte
 	ra                         *auth.BasicRequestAuthenticator
 }
 
@@ -94,6 +150,7 @@ func main() {
 	cli := MainConfig{
 		Address:                    "0.0.0.0",
 		Port:                       8081,
+		SessionTTL:                 12 * time.Hour,
 		CredentialsPath:            "./credentials.tsv",
 		ProxyTarget:                "http://127.0.0.1:8080",
 		AES128KeyPath:              filepath.Join("~", ".config", "csvauth", "aes-128.key"),
@@ -106,6 +163,20 @@ func main() {
 		AuthorizationHeaderSchemes: nil, // []string{"Bearer", "Token"}
 		TokenHeaderNames:           nil, // []string{"X-API-Key", "X-Auth-Token", "X-Access-Token"},
 		QueryParamNames:            nil, // []string{"access_token", "token"},
+		Identity: Identity{
+			UserHeader:    "X-Auth-User",
+			RolesHeader:   "X-Auth-Roles",
+			PurposeHeader: "X-Auth-Purpose",
+			Issuer:        name,
+			TTL:           time.Minute,
+		},
+		AuditLogMaxMB:     100,
+		AuditLogBackups:   5,
+		AuthFailLimit:     "10/m",
+		TLSClientID:       "any",
+		AdminRole:         "admin",
+		StreamHeartbeat:   30 * time.Second,
+		StreamIdleTimeout: 10 * time.Minute,
 	}
 
 	// Peek for --envfile early
@@ -128,6 +199,60 @@ func main() {
 	if v := os.Getenv("AUTHPROXY_TARGET"); v != "" {
 		cli.ProxyTarget = v
 	}
+	if v := os.Getenv("AUTHPROXY_ROUTES"); v != "" {
+		cli.RoutesPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_TLS_CERT"); v != "" {
+		cli.TLSCertPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_TLS_KEY"); v != "" {
+		cli.TLSKeyPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_TLS_CLIENT_CA"); v != "" {
+		cli.TLSClientCAPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_ADMIN_ADDRESS"); v != "" {
+		cli.AdminAddress = v
+	}
+	if v := os.Getenv("AUTHPROXY_AUDIT_LOG"); v != "" {
+		cli.AuditLogPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_RATE_LIMIT"); v != "" {
+		cli.RateLimit = v
+	}
+	if v := os.Getenv("AUTHPROXY_AUTH_FAIL_LIMIT"); v != "" {
+		cli.AuthFailLimit = v
+	}
+	if v := os.Getenv("AUTHPROXY_TRUST_FORWARDED_FOR"); v != "" {
+		cli.TrustForwardedFor, _ = strconv.ParseBool(v)
+	}
+	if v := os.Getenv("AUTHPROXY_ISSUER"); v != "" {
+		cli.IssuerURL = v
+	}
+	if v := os.Getenv("AUTHPROXY_AUDIENCE"); v != "" {
+		cli.audienceList = v
+	}
+	if v := os.Getenv("AUTHPROXY_ROLES_CLAIM"); v != "" {
+		cli.RolesClaim = v
+	}
+	if v := os.Getenv("AUTHPROXY_SESSIONS"); v != "" {
+		cli.Sessions, _ = strconv.ParseBool(v)
+	}
+	if v := os.Getenv("AUTHPROXY_OIDC_ISSUER"); v != "" {
+		cli.OIDCIssuerURL = v
+	}
+	if v := os.Getenv("AUTHPROXY_OIDC_CLIENT_ID"); v != "" {
+		cli.OIDCClientID = v
+	}
+	if v := os.Getenv("AUTHPROXY_OIDC_REDIRECT_URL"); v != "" {
+		cli.OIDCRedirectURL = v
+	}
+	if v := os.Getenv("AUTHPROXY_OIDC_GRANTS"); v != "" {
+		cli.OIDCGrantsPath = v
+	}
+	if v := os.Getenv("AUTHPROXY_OIDC_GROUPS_AS_GRANTS"); v != "" {
+		cli.OIDCGroupsAsGrants, _ = strconv.ParseBool(v)
+	}
 
 	// Flags
 	fs := flag.NewFlagSet(name, flag.ContinueOnError)
@@ -137,10 +262,43 @@ func main() {
 	fs.StringVar(&cli.AES128KeyPath, "aes-128-key", cli.AES128KeyPath, "path to credentials TSV/CSV file")
 	fs.StringVar(&cli.CredentialsPath, "credentials", cli.CredentialsPath, "path to credentials TSV/CSV file")
 	fs.StringVar(&cli.ProxyTarget, "proxy-target", cli.ProxyTarget, "upstream target to proxy requests to")
+	fs.StringVar(&cli.RoutesPath, "routes", cli.RoutesPath, "path to a file of '[host]/[path-prefix] upstream [strip] [timeout=30s]' lines (replaces --proxy-target)")
+	fs.StringVar(&cli.TLSCertPath, "tls-cert", cli.TLSCertPath, "serve HTTPS with this certificate (PEM, reloaded when it changes)")
+	fs.StringVar(&cli.TLSKeyPath, "tls-key", cli.TLSKeyPath, "private key (PEM) for --tls-cert")
+	fs.StringVar(&cli.TLSClientCAPath, "tls-client-ca", cli.TLSClientCAPath, "accept client certificates signed by these CAs (PEM) in place of tokens")
+	fs.StringVar(&cli.TLSClientID, "tls-client-id", cli.TLSClientID, "which client certificate names are credential names: 'san', 'cn', or 'any'")
 	fs.StringVar(&cli.commaString, "comma", "\\t", "single-character CSV separator for credentials file (literal characters and escapes accepted)")
 	fs.StringVar(&cli.tokenSchemeList, "token-schemes", "Bearer,Token", "checks for header 'Authorization: <Scheme> <token>'")
 	fs.StringVar(&cli.tokenHeaderList, "token-headers", "X-API-Key,X-Auth-Token,X-Access-Token", "checks for header '<API-Key-Header>: <token>'")
 	fs.StringVar(&cli.tokenParamList, "token-params", "access_token,token", "checks for query param '?<param>=<token>'")
+	fs.StringVar(&cli.IssuerURL, "issuer", cli.IssuerURL, "also accept bearer JWTs from this OIDC issuer (e.g. https://accounts.example.com)")
+	fs.StringVar(&cli.audienceList, "audience", cli.audienceList, "comma-separated audiences, one of which JWTs must have in 'aud' (default: any)")
+	fs.BoolVar(&cli.Sessions, "sessions", cli.Sessions, "serve a login form at "+loginPath+" and accept session cookies")
+	fs.DurationVar(&cli.SessionTTL, "session-ttl", cli.SessionTTL, "how long a session lasts without use (extended while in use, for up to 7 days)")
+	fs.StringVar(&cli.RolesClaim, "roles-claim", cli.RolesClaim, "JWT claim to read grants from, such as 'roles' or 'realm_access.roles' (default: scope)")
+	fs.StringVar(&cli.OIDCIssuerURL, "oidc-issuer", cli.OIDCIssuerURL, "log browsers in with this OIDC issuer at "+oidcLoginPath+" (implies --sessions)")
+	fs.StringVar(&cli.OIDCClientID, "oidc-client-id", cli.OIDCClientID, "client id registered with the OIDC issuer (the secret is read from AUTHPROXY_OIDC_CLIENT_SECRET)")
+	fs.StringVar(&cli.OIDCRedirectURL, "oidc-redirect-url", cli.OIDCRedirectURL, "redirect URL registered with the OIDC issuer (default: https://{host}"+oidcCallbackPath+")")
+	fs.StringVar(&cli.OIDCGrantsPath, "oidc-grants", cli.OIDCGrantsPath, "path to a file of 'email|@domain|group:name|* grants...' lines (required with --oidc-issuer, unless --oidc-groups-as-grants)")
+	fs.BoolVar(&cli.OIDCGroupsAsGrants, "oidc-groups-as-grants", cli.OIDCGroupsAsGrants, "also use the user's groups, as is, as grants (only if the issuer's groups are written as grants)")
+	fs.StringVar(&cli.OIDCGroupsClaim, "oidc-groups-claim", cli.OIDCGroupsClaim, "ID Token claim that lists the user's groups (default: groups)")
+	fs.StringVar(&cli.Identity.UserHeader, "user-header", cli.Identity.UserHeader, "header to send the user's id upstream in ('none' to disable)")
+	fs.StringVar(&cli.Identity.RolesHeader, "roles-header", cli.Identity.RolesHeader, "header to send the user's grants upstream in ('none' to disable)")
+	fs.StringVar(&cli.Identity.PurposeHeader, "purpose-header", cli.Identity.PurposeHeader, "header to send the credential's purpose upstream in ('none' to disable)")
+	fs.StringVar(&cli.Identity.JWTHeader, "identity-jwt-header", cli.Identity.JWTHeader, "send a signed JWT upstream in this header (e.g. X-Auth-JWT) instead of the plain headers")
+	fs.StringVar(&cli.IdentityKeyPath, "identity-jwt-key", cli.IdentityKeyPath, "private key (PEM or JWK) to sign identity JWTs (default: generated on start)")
+	fs.StringVar(&cli.Identity.Issuer, "identity-jwt-issuer", cli.Identity.Issuer, "iss of identity JWTs")
+	fs.DurationVar(&cli.Identity.TTL, "identity-jwt-ttl", cli.Identity.TTL, "lifetime of identity JWTs")
+	fs.StringVar(&cli.RateLimit, "rate-limit", cli.RateLimit, "requests per credential, such as '10/s' or '600/m' (or 'rate=' in a credential's Extra column)")
+	fs.StringVar(&cli.AuthFailLimit, "auth-fail-limit", cli.AuthFailLimit, "failed logins per client IP before responding 429 ('none' to disable)")
+	fs.BoolVar(&cli.TrustForwardedFor, "trust-forwarded-for", cli.TrustForwardedFor, "use the last X-Forwarded-For address as the client IP (only behind a TLS proxy that sets it)")
+	fs.DurationVar(&cli.StreamHeartbeat, "stream-heartbeat", cli.StreamHeartbeat, "send a heartbeat on quiet WebSocket and SSE streams this often (0 to disable)")
+	fs.DurationVar(&cli.StreamIdleTimeout, "stream-idle-timeout", cli.StreamIdleTimeout, "end WebSocket and SSE streams with no data either way for this long (0 to disable)")
+	fs.StringVar(&cli.AdminAddress, "admin-address", cli.AdminAddress, "serve the admin API (credentials, checks, reloads) here, such as 'localhost:3001'")
+	fs.StringVar(&cli.AdminRole, "admin-role", cli.AdminRole, "role a credential needs to use the admin API (unless it's bound to localhost)")
+	fs.StringVar(&cli.AuditLogPath, "audit-log", cli.AuditLogPath, "write a JSON line per request to this file ('-' for stdout)")
+	fs.IntVar(&cli.AuditLogMaxMB, "audit-log-max-mb", cli.AuditLogMaxMB, "rotate the audit log file at this size (0 to never rotate)")
+	fs.IntVar(&cli.AuditLogBackups, "audit-log-backups", cli.AuditLogBackups, "number of rotated audit log files to keep")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "USAGE\n  %s [flags]\n\n", name)
@@ -151,6 +309,26 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  AUTHPROXY_ADDRESS           bind address\n")
 		fmt.Fprintf(os.Stderr, "  AUTHPROXY_CREDENTIALS_FILE  path to tokens file\n")
 		fmt.Fprintf(os.Stderr, "  AUTHPROXY_TARGET            upstream URL\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_ROUTES            path to routes file\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_TLS_CERT          path to TLS certificate (PEM)\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_TLS_KEY           path to TLS private key (PEM)\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_TLS_CLIENT_CA     path to client certificate CAs (PEM)\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_ADMIN_ADDRESS     admin API address, such as localhost:3001\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_AUDIT_LOG         path to audit log ('-' for stdout)\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_RATE_LIMIT        requests per credential, such as 10/s\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_AUTH_FAIL_LIMIT   failed logins per client IP, such as 10/m\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_TRUST_FORWARDED_FOR  'true' to use X-Forwarded-For as the client IP\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_ISSUER            OIDC issuer URL for bearer JWTs\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_AUDIENCE          comma-separated JWT audiences\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_ROLES_CLAIM       JWT claim to read grants from\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_SESSIONS          'true' to enable login sessions\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_SESSION_KEY       AES-128 or AES-256 key (hex) for session cookies\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_ISSUER       OIDC issuer URL for browser logins\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_CLIENT_ID    OIDC client id\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_CLIENT_SECRET  OIDC client secret\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_REDIRECT_URL OIDC redirect (callback) URL\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_GRANTS       path to the OIDC grants file\n")
+		fmt.Fprintf(os.Stderr, "  AUTHPROXY_OIDC_GROUPS_AS_GRANTS  'true' to use OIDC groups as grants\n")
 	}
 
 	// Special handling for version/help
@@ -229,6 +407,51 @@ func main() {
 		}
 	}
 
+	// aud
+	cli.audienceList = strings.TrimSpace(cli.audienceList)
+	if cli.audienceList != "" && cli.audienceList != "none" {
+		cli.audienceList = strings.ReplaceAll(cli.audienceList, ",", " ")
+		cli.Audiences = strings.Fields(cli.audienceList)
+	}
+
+	// identity headers
+	for _, header := range []*string{&cli.Identity.UserHeader, &cli.Identity.RolesHeader, &cli.Identity.PurposeHeader, &cli.Identity.JWTHeader} {
+		if *header = strings.TrimSpace(*header); *header == "none" {
+			*header = ""
+		}
+	}
+
+	// rate limits
+	if cli.rateLimit, err = ParseRateLimit(cli.RateLimit); err != nil {
+		log.Fatalf("--rate-limit: %v", err)
+	}
+	if cli.authFailLimit, err = ParseRateLimit(cli.AuthFailLimit); err != nil {
+		log.Fatalf("--auth-fail-limit: %v", err)
+	}
+	cli.limiters = NewLimiters(10 * time.Minute)
+
+	// client certificates
+	if !slices.Contains([]string{"san", "cn", "any"}, cli.TLSClientID) {
+		log.Fatalf("--tls-client-id must be 'san', 'cn', or 'any', not %q", cli.TLSClientID)
+	}
+	if cli.TLSClientCAPath != "" && cli.TLSCertPath == "" {
+		log.Fatalf("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+
+	// OIDC logins are kept as sessions
+	if cli.OIDCIssuerURL != "" {
+		if cli.OIDCClientID == "" {
+			fmt.Fprintf(os.Stderr, "Error: --oidc-client-id is required with --oidc-issuer\n")
+			os.Exit(1)
+		}
+		// otherwise, no one who logs in would be allowed anything
+		if cli.OIDCGrantsPath == "" && !cli.OIDCGroupsAsGrants {
+			fmt.Fprintf(os.Stderr, "Error: --oidc-grants (or --oidc-groups-as-grants) is required with --oidc-issuer\n")
+			os.Exit(1)
+		}
+		cli.Sessions = true
+	}
+
 	run(&cli)
 }
 
@@ -271,29 +494,61 @@ func run(cli *MainConfig) {
 		return
 	}
 
-	// Load credentials from CSV/TSV file once at startup
-	f, err := os.Open(cli.CredentialsPath)
+	// Load credentials from CSV/TSV file at startup (and on POST /reload to the admin listener)
+	cli.aesKey = aesKey
+	a, err := loadCredentials(cli.CredentialsPath, cli.comma, aesKey)
 	if err != nil {
-		log.Fatalf("Failed to open credentials file %q: %v", cli.CredentialsPath, err)
+		log.Fatalf("Failed to load credentials file %q: %v", cli.CredentialsPath, err)
 	}
-	defer func() { _ = f.Close() }()
+	creds = NewCredentials(a)
+
+	var authenticator auth.BasicAuthenticator = creds
+	if cli.IssuerURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		jwtAuth, err := jwtauth.NewFromIssuer(ctx, cli.IssuerURL, cli.Audiences, nil)
+		cancel()
+		if err != nil {
+			log.Fatalf("Failed to load keys for issuer %q: %v", cli.IssuerURL, err)
+		}
+		jwtAuth.RolesClaim = cli.RolesClaim
+		authenticator = auth.BasicAuthenticators{creds, jwtAuth}
 
-	creds = csvauth.New(aesKey)
-	if err := creds.LoadCSV(f, cli.comma); err != nil {
-		log.Fatalf("Failed to load CSV auth: %v", err)
+		claim := cmp.Or(cli.RolesClaim, "scope")
+		fmt.Fprintf(os.Stderr, "Accepting JWTs from %s, with grants from the %q claim\n", cli.IssuerURL, claim)
 	}
 
 	cli.ra = &auth.BasicRequestAuthenticator{
-		Authenticator:        creds,
+		Authenticator:        authenticator,
 		AuthorizationSchemes: cli.AuthorizationHeaderSchemes,
 		TokenHeaders:         cli.TokenHeaderNames,
 		TokenQueryParams:     cli.QueryParamNames,
 		BasicRealm:           cli.BasicRealm,
 	}
 
+	if cli.Sessions {
+		sessionKey, err := getSessionKey("AUTHPROXY_SESSION_KEY")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		cli.sessions, err = session.NewEncrypted(creds, sessionKey)
+		if err != nil {
+			log.Fatalf("Failed to initialize sessions: %v", err)
+		}
+		cli.sessions.TTL = cli.SessionTTL
+		cli.ra.Authenticator = auth.BasicAuthenticators{cli.sessions, authenticator}
+		cli.ra.TokenCookies = []string{cli.sessions.CookieName}
+		fmt.Fprintf(os.Stderr, "Login form at %s, sessions last %s without use\n", loginPath, cli.SessionTTL)
+	}
+
+	if cli.OIDCIssuerURL != "" {
+		cli.oidc = newRelyingParty(cli)
+		fmt.Fprintf(os.Stderr, "Log in with %s at %s\n", cli.OIDCIssuerURL, oidcLoginPath)
+	}
+
 	var usableRoles int
-	for key := range creds.CredentialKeys() {
-		u, err := creds.LoadCredential(key)
+	for key := range creds.Load().CredentialKeys() {
+		u, err := creds.Load().LoadCredential(key)
 		if err != nil {
 			log.Fatalf("Failed to read users from CSV auth: %v", err)
 		}
@@ -308,8 +563,8 @@ func run(cli *MainConfig) {
 	}
 
 	var warnRoles bool
-	for key := range creds.CredentialKeys() {
-		u, _ := creds.LoadCredential(key)
+	for key := range creds.Load().CredentialKeys() {
+		u, _ := creds.Load().LoadCredential(key)
 		if len(u.Roles) > 0 {
 			continue
 		}
@@ -341,13 +596,45 @@ func run(cli *MainConfig) {
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "\n")
 	}
-	if usableRoles == 0 {
+	if usableRoles == 0 && cli.IssuerURL == "" && cli.OIDCIssuerURL == "" {
 		fmt.Fprintf(os.Stderr, "Error: no usable credentials found\n")
 		os.Exit(1)
 	}
 
+	if cli.Identity.JWTHeader != "" {
+		if cli.IdentityKeyPath == "" {
+			fmt.Fprintf(os.Stderr, "Warn: --identity-jwt-key is not set, so upstreams must re-fetch %s when %s restarts\n", jwksPath, name)
+		}
+		cli.Identity.Signer, err = loadIdentitySigner(cli.IdentityKeyPath)
+		if err != nil {
+			log.Fatalf("Failed to load identity JWT key: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Sending identity JWTs upstream in %s, with keys at %s\n", cli.Identity.JWTHeader, jwksPath)
+	}
+
+	switch cli.AuditLogPath {
+	case "":
+		// disabled
+	case "-":
+		cli.audit = NewAuditLog(os.Stdout)
+	default:
+		rf, err := OpenRotatingFile(cli.AuditLogPath, int64(cli.AuditLogMaxMB)*1024*1024, cli.AuditLogBackups)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer func() { _ = rf.Close() }()
+		cli.audit = NewAuditLog(rf)
+		fmt.Fprintf(os.Stderr, "Audit log at %s\n", cli.AuditLogPath)
+	}
+
+	cli.routes = loadRoutes(cli)
+	fmt.Fprintf(os.Stderr, "Routes:\n")
+	for _, rt := range cli.routes {
+		fmt.Fprintf(os.Stderr, "    %s\n", rt)
+	}
+
 	// Build proxy handler
-	handler := cli.newAuthProxyHandler(cli.ProxyTarget)
+	handler := cli.newAuthProxyHandler()
 
 	// Server setup
 	srv := &http.Server{
@@ -357,6 +644,38 @@ func run(cli *MainConfig) {
 		WriteTimeout:      30 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
+	if cli.TLSCertPath != "" || cli.TLSKeyPath != "" {
+		tlsConfig, err := cli.newTLSConfig()
+		if err != nil {
+			log.Fatalf("TLS: %v", err)
+		}
+		srv.TLSConfig = tlsConfig
+		if cli.TLSClientCAPath != "" {
+			fmt.Fprintf(os.Stderr, "Accepting client certificates from %s (by %s name)\n", cli.TLSClientCAPath, cli.TLSClientID)
+		}
+	}
+
+	// Admin listener
+	var adminSrv *http.Server
+	if cli.AdminAddress != "" {
+		open := isLoopback(cli.AdminAddress)
+		adminSrv = &http.Server{
+			Addr:              cli.AdminAddress,
+			Handler:           cli.newAdminHandler(open),
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+		}
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+		if open {
+			fmt.Fprintf(os.Stderr, "Admin listener on %s (localhost, no auth)\n", cli.AdminAddress)
+		} else {
+			fmt.Fprintf(os.Stderr, "Admin listener on %s (for credentials with the %q role)\n", cli.AdminAddress, cli.AdminRole)
+		}
+	}
 
 	// Graceful shutdown
 	done := make(chan os.Signal, 1)
@@ -367,241 +686,383 @@ func run(cli *MainConfig) {
 		log.Println("Shutting down server...")
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
+		if adminSrv != nil {
+			_ = adminSrv.Shutdown(ctx)
+		}
 		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("HTTP server shutdown error: %v", err)
 		}
 	}()
 
-	log.Printf("Starting %s v%s on %s → %s", name, version, srv.Addr, cli.ProxyTarget)
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	var serve func() error = srv.ListenAndServe
+	if srv.TLSConfig != nil {
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	log.Printf("Starting %s v%s on %s", name, version, srv.Addr)
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("HTTP server failed: %v", err)
 	}
 
 	log.Println("Server stopped")
 }
 
-func (cli *MainConfig) newAuthProxyHandler(targetURL string) http.Handler {
-	target, err := url.Parse(targetURL)
+// loadRoutes reads the routes file, or else routes everything to the proxy target
+func loadRoutes(cli *MainConfig) Routes {
+	if cli.RoutesPath == "" {
+		rt, err := NewRoute("/", cli.ProxyTarget)
+		if err != nil {
+			log.Fatalf("invalid proxy target: %v", err)
+		}
+		return Routes{rt}
+	}
+
+	f, err := os.Open(cli.RoutesPath)
+	if err != nil {
+		log.Fatalf("Failed to open routes file %q: %v", cli.RoutesPath, err)
+	}
+	defer func() { _ = f.Close() }()
+	routes, err := ParseRoutes(f)
 	if err != nil {
-		log.Fatalf("invalid proxy target %q: %v", targetURL, err)
+		log.Fatalf("Failed to load routes: %v", err)
+	}
+	if len(routes) == 0 {
+		log.Fatalf("No routes in %q", cli.RoutesPath)
 	}
+	return routes
+}
 
-	proxy := &httputil.ReverseProxy{
+func (cli *MainConfig) newReverseProxy(rt *Route) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
-			r.SetURL(target)
+			if rt.StripPrefix {
+				r.Out.URL.Path, _ = rt.cutPrefix(r.In.URL.Path)
+				r.Out.URL.RawPath, _ = rt.cutPrefix(r.In.URL.RawPath)
+				r.Out.Header.Set("X-Forwarded-Prefix", rt.Prefix)
+			}
+			r.SetURL(rt.Target)
 			r.Out.Host = r.In.Host // preserve original Host header
 			// X-Forwarded-* headers are preserved from incoming request
+			cli.Identity.Strip(r.Out.Header)
+			if identity, ok := r.In.Context().Value(identityKey{}).(http.Header); ok {
+				for name, values := range identity {
+					r.Out.Header[name] = values
+				}
+			}
+			if cli.sessions != nil {
+				removeCookies(r.Out, cli.sessions.CookieName, cli.sessions.CookieName+"_csrf", cli.sessions.CookieName+"_oidc")
+			}
 		},
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
 			log.Printf("proxy error: %v", err)
+			if errors.Is(err, context.DeadlineExceeded) {
+				http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+				return
+			}
 			http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		},
 	}
+}
+
+func (cli *MainConfig) newAuthProxyHandler() http.Handler {
+	if cli.counters == nil {
+		cli.counters = NewCounters()
+	}
+	for _, rt := range cli.routes {
+		rt.proxy = cli.newReverseProxy(rt)
+	}
+
+	var loginHandler, logoutHandler, oidcLoginHandler, oidcCallbackHandler http.Handler
+	startLoginPath := loginPath
+	if cli.sessions != nil {
+		loginHandler = cli.sessions.LoginHandler()
+		logoutHandler = cli.sessions.LogoutHandler()
+	}
+	if cli.oidc != nil {
+		oidcLoginHandler = cli.oidc.LoginHandler()
+		oidcCallbackHandler = cli.oidc.CallbackHandler()
+		startLoginPath = oidcLoginPath
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !cli.authorize(r) {
+		if cli.Identity.Signer != nil && r.URL.Path == jwksPath {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(cli.Identity.Signer.WellKnownJWKs)
+			return
+		}
+		if cli.sessions != nil {
+			switch r.URL.Path {
+			case loginPath:
+				cli.limitFailedLogins(loginHandler).ServeHTTP(w, r)
+				return
+			case logoutPath:
+				logoutHandler.ServeHTTP(w, r)
+				return
+			}
+		}
+		if cli.oidc != nil {
+			switch r.URL.Path {
+			case oidcLoginPath:
+				oidcLoginHandler.ServeHTTP(w, r)
+				return
+			case oidcCallbackPath:
+				oidcCallbackHandler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		ip := cli.clientIP(r)
+		entry := newAuditEntry(r, ip)
+		if cli.audit != nil {
+			sw := &statusWriter{ResponseWriter: w}
+			w = sw
+			defer func() {
+				entry.Status = cmp.Or(sw.status, entry.Status)
+				entry.LatencyMS = float64(time.Since(entry.Time).Microseconds()) / 1000
+				cli.audit.Log(entry)
+			}()
+		}
+
+		if cli.authFailLimit != nil {
+			if ok, wait := cli.limiters.Check("fail:"+ip, *cli.authFailLimit); !ok {
+				entry.Denied = "too many failed logins"
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+
+		principle, decision, err := cli.authorize(r)
+		if principle != nil {
+			entry.ID = principle.ID()
+		}
+		if principle != nil {
+			cli.counters.Add(principle.ID(), err == nil)
+		}
+		if err != nil {
+			entry.Denied = err.Error()
+			switch denyStatus(principle, err) {
+			case http.StatusMethodNotAllowed:
+				w.Header().Set("Allow", strings.Join(decision.Allow, ", "))
+				http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			case http.StatusForbidden:
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if cli.authFailLimit != nil && principle == nil && !errors.Is(err, ErrNoAuth) {
+				cli.limiters.Take("fail:"+ip, *cli.authFailLimit)
+			}
+			if cli.sessions != nil && wantsLoginPage(r) {
+				next := url.QueryEscape(r.URL.RequestURI())
+				http.Redirect(w, r, startLoginPath+"?next="+next, http.StatusSeeOther)
+				return
+			}
 			w.Header().Set("WWW-Authenticate", cli.ra.BasicRealm)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		proxy.ServeHTTP(w, r)
+
+		if cli.sessions != nil {
+			if s, err := cli.sessions.Load(r); err == nil {
+				_ = cli.sessions.Refresh(w, s)
+			}
+		}
+
+		entry.Grant = decision.Grant
+
+		limit, limitErr := credentialRateLimit(principle, cli.rateLimit)
+		if limitErr != nil {
+			fmt.Fprintf(os.Stderr, "Warn: credential %q: %v\n", principle.ID(), limitErr)
+			limit = cli.rateLimit
+		}
+		if limit != nil {
+			// guests share an id, so they're limited by IP instead
+			key := "id:" + principle.ID()
+			if principle.ID() == "guest" {
+				key = "guest:" + ip
+			}
+			if ok, wait := cli.limiters.Allow(key, *limit); !ok {
+				entry.Denied = "rate limited"
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+
+		rt := cli.routes.Match(r.Host, r.URL.Path)
+		if rt == nil {
+			http.NotFound(w, r)
+			return
+		}
+		entry.Upstream = rt.Target.String()
+
+		identity, err := cli.Identity.Headers(principle, r.Host)
+		if err != nil {
+			log.Printf("identity error: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+		if isStream(r) {
+			// authorized once, above, for the life of the stream
+			if cli.serveStream(w, r, rt.proxy) {
+				entry.Status = http.StatusSwitchingProtocols
+			}
+			return
+		}
+		if rt.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), rt.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+			// allow the upstream's full timeout (and the 504 after it), beyond the server's WriteTimeout
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(rt.Timeout + 5*time.Second))
+		}
+		rt.proxy.ServeHTTP(w, r)
 	})
 }
 
-func (cli *MainConfig) authorize(r *http.Request) bool {
-	cred, err := cli.authenticate(r)
+// newRelyingParty discovers the OIDC issuer, and loads the grants file (if any)
+func newRelyingParty(cli *MainConfig) *oidc.RelyingParty {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rp, err := oidc.New(ctx, oidc.Config{
+		Issuer:       cli.OIDCIssuerURL,
+		ClientID:     cli.OIDCClientID,
+		ClientSecret: os.Getenv("AUTHPROXY_OIDC_CLIENT_SECRET"),
+		RedirectURL:  cli.OIDCRedirectURL,
+		GroupsClaim:  cli.OIDCGroupsClaim,
+	}, cli.sessions)
 	if err != nil {
-		if !errors.Is(err, ErrNoAuth) {
-			return false
+		log.Fatalf("Failed to discover OIDC issuer %q: %v", cli.OIDCIssuerURL, err)
+	}
+	rp.GroupsAsPermissions = cli.OIDCGroupsAsGrants
+
+	if cli.OIDCGrantsPath != "" {
+		f, err := os.Open(cli.OIDCGrantsPath)
+		if err != nil {
+			log.Fatalf("Failed to open OIDC grants file %q: %v", cli.OIDCGrantsPath, err)
 		}
-		cred, err = creds.Authenticate("guest", "")
+		defer func() { _ = f.Close() }()
+		rp.Grants, err = oidc.ParseGrants(f)
 		if err != nil {
-			return false
+			log.Fatalf("Failed to load OIDC grants: %v", err)
 		}
 	}
+	return rp
+}
 
-	grants := cred.Permissions()
-	if len(grants) == 0 {
-		// must have at least '/'
-		fmt.Fprintf(os.Stderr, "Warn: user %q correctly authenticated, but no --roles were specified (assign * or / for full access)\n", cred.ID())
-		return false
+// clientIP returns the client's IP address, which is the last X-Forwarded-For
+// address (as added by the proxy in front) with --trust-forwarded-for
+func (cli *MainConfig) clientIP(r *http.Request) string {
+	if cli.TrustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			addrs := strings.Split(xff[len(xff)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
 	}
-
-	if grants[0] == "*" || grants[0] == "/" {
-		return true
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return ip
+}
 
-	// GET,POST example.com/path/{$}
-	for _, grant := range grants {
-		if matchPattern(grant, r.Method, r.Host, r.URL.Path) {
-			return true
-		}
+// limitFailedLogins responds 429 to IPs with too many failed logins,
+// and counts 401 responses from the login form as failures
+func (cli *MainConfig) limitFailedLogins(next http.Handler) http.Handler {
+	if cli.authFailLimit == nil {
+		return next
 	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := "fail:" + cli.clientIP(r)
+		if ok, wait := cli.limiters.Check(key, *cli.authFailLimit); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusUnauthorized {
+			cli.limiters.Take(key, *cli.authFailLimit)
+		}
+	})
+}
 
-	return false
+// tooManyRequests responds 429 with a Retry-After
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfter(wait))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 }
 
-// patternMatch returns true for a grant in the form of a ServeMux pattern matches the current request
-// (though : is used instead of space since space is already used as a separator)
-//
-// grant is in the form `[METHOD:][HOST]/[PATH]`, but METHOD may be a comma-separated list GET,POST...
-// rMthod must be ALL_CAPS
-// rHost may be HOSTNAME or HOSTNAME:PORT
-// rPath must start with /
-func matchPattern(grant, rMethod, rHost, rPath string) bool {
-	// this should have been done already, but...
-	grant = strings.TrimSpace(grant)
-
-	// must have at least /
-	// ""
-	if grant == "" {
-		fmt.Fprintf(os.Stderr, "DEBUG: missing grant\n")
+// wantsLoginPage reports whether r looks like a browser navigating to a page
+// without credentials, which should be sent to the login form rather than given a 401
+func wantsLoginPage(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
 		return false
 	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
 
-	// / => ["/"]
-	// /path => ["/path"]
-	// example.com/path => ["example.com/path"]
-	// GET:example.com/path => ["GET", "example.com/path"]
-	{
-		var methodSep = ":"
-		var methods []string
-		grantParts := strings.SplitN(grant, methodSep, 2)
-		switch len(grantParts) {
-		case 1:
-			// no method
-		case 2:
-			if len(grantParts) == 2 {
-				methods = strings.Split(strings.ToUpper(grantParts[0]), ",")
-				if !slices.Contains(methods, rMethod) {
-					// TODO maybe propagate method-not-allowed?
-					fmt.Fprintf(os.Stderr, "DEBUG: method %q != %q\n", rMethod, grantParts[0])
-					return false
-				}
-				grant = grantParts[1]
-			}
-		default:
-			fmt.Fprintf(os.Stderr, "DEBUG: extraneous spaces in grant %q\n", grant)
-			return false
+// removeCookies strips the named cookies from the request, keeping the rest
+func removeCookies(r *http.Request, names ...string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if !slices.Contains(names, c.Name) {
+			r.AddCookie(c)
 		}
 	}
+}
 
-	// / => /
-	// /path => /path
-	// example.com/path => /path
-	idx := strings.Index(grant, "/")
-	if idx < 0 {
-		// host without path is invalid
-		fmt.Fprintf(os.Stderr, "DEBUG: missing leading / from grant %q\n", grant)
-		return false
-	}
-	hostname := grant[:idx]
-	if hostname != "" {
-		// example.com:443 => example.com
-		if h, _, _ := strings.Cut(rHost, ":"); hostname != h {
-			// hostname doesn't match
-			fmt.Fprintf(os.Stderr, "DEBUG: hostname %q != %q\n", rHost, hostname)
-			return false
-		}
-	}
-	grant = grant[idx:]
-
-	// Prefix-only matching
-	//
-	// /path => /path
-	// /path/ => /path
-	// /path/{var}/bar => /path/{var}/bar
-	// /path/{var...} = /path/{var}
-	// /path/{$} => /path
-	// var exact bool
-	// grant, exact = strings.CutSuffix(grant, "/{$}")
-	// grant, _ = strings.CutSuffix(grant, "/")
-	// rPath, _ = strings.CutSuffix(rPath, "/")
-	// if len(grantPaths) > len(rPaths) {
-	// 	return false
-	// } else if len(grantPaths) < len(rPaths) {
-	// 	if exact {
-	// 		return false
-	// 	}
-	// }
-
-	// // TODO replace with pattern matching as per https://pkg.go.dev/net/http#hdr-Patterns-ServeMux
-	// // /path/{var}/bar matches /path/foo/bar and /path/foo/bar/
-	// // /path/{var...} matches /path/ and /path/foo/bar
-	// // /path/{var}/bar/{$} matches /path/foo/bar and /path/baz/bar/ but not /path/foo/
-	// for i := 1; i < len(grantPaths); i++ {
-	// 	grantPath := grantPaths[i]
-	// 	rPath := rPaths[i]
-	// 	if strings.HasPrefix(grantPath, "{") {
-	// 		continue
-	// 	}
-	// 	if rPath != grantPath {
-	// 		return false
-	// 	}
-	// }
-	// return true
-
-	// ServeMux pattern matching
-	nextGPath, gstop := iter.Pull(strings.SplitSeq(grant, "/"))
-	nextRPath, rstop := iter.Pull(strings.SplitSeq(rPath, "/"))
-	defer gstop()
-	defer rstop()
-
-	for {
-		gp, gok := nextGPath()
-		rp, rok := nextRPath()
-		// everything has matched thus far, and the pattern has ended
-		if !gok {
-			return true
-		}
-
-		// false unless the extra length of the pattern signifies the exact match, disregarding trailing /
-		if !rok {
-			// this matches trailing /, {var}, {var}/, {var...}, and {$}
-			if gp == "" || (strings.HasPrefix(gp, "{") && strings.HasSuffix(gp, "}")) {
-				gp2, more := nextGPath()
-				// this allows for one more final trailing /, but nothing else
-				if !more {
-					return true
-				}
-				if gp2 == "" {
-					// two trailing slashes are not allowed
-					_, more := nextGPath()
-					return !more
-				}
-			}
-			return false
+// authorize returns the (possibly "guest") principle and the grant that allows the request,
+// or the reason it is not allowed (along with the principle, if it authenticated)
+func (cli *MainConfig) authorize(r *http.Request) (auth.BasicPrinciple, grants.Decision, error) {
+	cred, err := cli.authenticate(r)
+	if err != nil {
+		if !errors.Is(err, ErrNoAuth) {
+			return nil, grants.Decision{}, err
 		}
-
-		// path parts are only allowed to disagree for trailing slashes and variables
-		if gp != rp {
-			// this allows for one more final trailing / on the pattern, but nothing else
-			if gp == "" {
-				_, more := nextGPath()
-				return !more
-			}
-			// this allows for a placeholder in the pattern
-			if strings.HasPrefix(gp, "{") && strings.HasSuffix(gp, "}") {
-				// normal variables pass
-				if gp != "{$}" {
-					continue
-				}
-				// trailing slash on exact match passes
-				if rp == "" {
-					_, more := nextRPath()
-					return !more
-				}
-				fmt.Fprintf(os.Stderr, "DEBUG: path past {$} %q vs %q\n", rp, gp)
-				return false
-			}
-			fmt.Fprintf(os.Stderr, "DEBUG: path part %q != %q\n", rp, gp)
-			return false
+		cred, err = creds.Authenticate("guest", "")
+		if err != nil {
+			return nil, grants.Decision{}, ErrNoAuth
 		}
 	}
+
+	d, err := authorizePrinciple(cred, r.Method, r.Host, r.URL.Path)
+	return cred, d, err
+}
+
+// authorizePrinciple checks the principle's grants, such as GET,POST:example.com/path/{$} or !DELETE:/admin/
+func authorizePrinciple(p auth.BasicPrinciple, method, host, path string) (grants.Decision, error) {
+	d, err := grants.Authorize(p.Permissions(), method, host, path)
+	if errors.Is(err, grants.ErrNoGrants) {
+		// must have at least '/'
+		fmt.Fprintf(os.Stderr, "Warn: user %q correctly authenticated, but no --roles were specified (assign * or / for full access)\n", p.ID())
+	}
+	return d, err
+}
+
+// denyStatus is the response status for an error from authorize, where p is nil if
+// the request didn't authenticate. Guests may yet log in as someone who is allowed.
+func denyStatus(p auth.BasicPrinciple, err error) int {
+	switch {
+	case p == nil || p.ID() == "guest":
+		return http.StatusUnauthorized
+	case errors.Is(err, grants.ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	default:
+		// authenticated, but not allowed, so logging in (again) won't help
+		return http.StatusForbidden
+	}
 }
 
 func (cli *MainConfig) authenticate(r *http.Request) (auth.BasicPrinciple, error) {
 	cred, err := cli.ra.Authenticate(r)
+	if errors.Is(err, auth.ErrNoCredentials) {
+		cred, err = cli.authenticateCert(r)
+	}
 	if errors.Is(err, auth.ErrNoCredentials) {
 		return nil, ErrNoAuth
 	}
@@ -622,6 +1083,24 @@ func peekOption(args []string, names []string, def string) string {
 	return def
 }
 
+// getSessionKey reads an AES-128 or AES-256 key (32 or 64 hex chars) from an environment
+// variable, or generates one (in which case sessions end when the proxy restarts)
+func getSessionKey(envname string) ([]byte, error) {
+	envKey := strings.TrimSpace(os.Getenv(envname))
+	if envKey == "" {
+		fmt.Fprintf(os.Stderr, "Warn: %s is not set, so sessions will end when %s restarts\n", envname, name)
+		key := make([]byte, 16)
+		_, _ = rand.Read(key)
+		return key, nil
+	}
+
+	key, err := hex.DecodeString(envKey)
+	if err != nil || (len(key) != 16 && len(key) != 32) {
+		return nil, fmt.Errorf("invalid %s: must be a 32- or 64-char hex string", envname)
+	}
+	return key, nil
+}
+
 // TODO expose this from csvauth
 func getAESKey(envname, filename string) ([]byte, error) {
 	envKey := os.Getenv(envname)
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// RateLimit is a token bucket's refill rate and size
+type RateLimit struct {
+	Rate  rate.Limit
+	Burst int
+}
+
+// ParseRateLimit parses "<count>/<period>", such as "10/s", "600/m", "1000/h",
+// or "5/30s", where the count is also the burst. "" and "none" mean no limit.
+func ParseRateLimit(spec string) (*RateLimit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+
+	countStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, fmt.Errorf("rate limit %q: expected <count>/<period>, such as 10/s", spec)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("rate limit %q: invalid count %q", spec, countStr)
+	}
+
+	var period time.Duration
+	switch periodStr {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("rate limit %q: invalid period %q", spec, periodStr)
+		}
+	}
+
+	return &RateLimit{
+		Rate:  rate.Limit(float64(count) / period.Seconds()),
+		Burst: count,
+	}, nil
+}
+
+// credentialRateLimit reads "rate=<count>/<period>" (or "rate=none") and "burst=<n>"
+// from a csvauth credential's Extra column, or returns def
+func credentialRateLimit(p auth.BasicPrinciple, def *RateLimit) (*RateLimit, error) {
+	var c *csvauth.Credential
+	switch p := p.(type) {
+	case *csvauth.Credential:
+		c = p
+	case *CertPrinciple:
+		c = p.Credential
+	default:
+		return def, nil
+	}
+
+	limit := def
+	var burst int
+	for field := range strings.FieldsSeq(c.Extra) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "rate":
+			var err error
+			if limit, err = ParseRateLimit(value); err != nil {
+				return nil, err
+			}
+		case "burst":
+			var err error
+			if burst, err = strconv.Atoi(value); err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid burst %q", value)
+			}
+		}
+	}
+	if limit != nil && burst > 0 {
+		limit = &RateLimit{Rate: limit.Rate, Burst: burst}
+	}
+	return limit, nil
+}
+
+// Limiters keeps a token bucket per key (such as a credential ID or an IP),
+// and forgets buckets that have been idle for IdleTTL
+type Limiters struct {
+	IdleTTL time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewLimiters returns Limiters that forget buckets after idleTTL
+// (which should be at least as long as it takes a bucket to refill)
+func NewLimiters(idleTTL time.Duration) *Limiters {
+	return &Limiters{
+		IdleTTL: idleTTL,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// get returns the key's limiter, creating it (or updating its limit) as needed
+func (l *Limiters) get(key string, limit RateLimit) (*rate.Limiter, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= l.IdleTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) >= l.IdleTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(limit.Rate, limit.Burst)}
+		l.buckets[key] = b
+	} else if b.limiter.Limit() != limit.Rate || b.limiter.Burst() != limit.Burst {
+		b.limiter.SetLimitAt(now, limit.Rate)
+		b.limiter.SetBurstAt(now, limit.Burst)
+	}
+	b.lastSeen = now
+	return b.limiter, now
+}
+
+// Allow takes a token for the key, or else returns how long until one is available
+func (l *Limiters) Allow(key string, limit RateLimit) (bool, time.Duration) {
+	lim, now := l.get(key, limit)
+	r := lim.ReserveN(now, 1)
+	if !r.OK() {
+		return false, time.Duration(math.MaxInt64)
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Check returns whether the key has a token left (without taking it), or else how
+// long until it will. Use with Take to limit only some outcomes, such as failed logins.
+func (l *Limiters) Check(key string, limit RateLimit) (bool, time.Duration) {
+	lim, now := l.get(key, limit)
+	tokens := lim.TokensAt(now)
+	if tokens >= 1 {
+		return true, 0
+	}
+	return false, time.Duration((1 - tokens) / float64(limit.Rate) * float64(time.Second))
+}
+
+// Take uses up a token for the key, if there is one
+func (l *Limiters) Take(key string, limit RateLimit) {
+	lim, now := l.get(key, limit)
+	_ = lim.AllowN(now, 1)
+}
+
+// Len returns the number of buckets currently kept
+func (l *Limiters) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// retryAfter formats a delay as whole seconds (rounded up) for the Retry-After header
+func retryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		spec  string
+		rate  rate.Limit
+		burst int
+	}{
+		{"10/s", 10, 10},
+		{"600/m", 10, 600},
+		{"3600/h", 1, 3600},
+		{"5/10s", 0.5, 5},
+	}
+	for _, tt := range tests {
+		limit, err := ParseRateLimit(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if limit.Rate != tt.rate || limit.Burst != tt.burst {
+			t.Errorf("%q: %v/s burst %d, want %v/s burst %d", tt.spec, limit.Rate, limit.Burst, tt.rate, tt.burst)
+		}
+	}
+
+	for _, spec := range []string{"", "none"} {
+		if limit, err := ParseRateLimit(spec); limit != nil || err != nil {
+			t.Errorf("%q: got %v, %v, want no limit", spec, limit, err)
+		}
+	}
+	for _, spec := range []string{"10", "0/s", "-1/s", "ten/s", "10/fortnight", "10/-1s"} {
+		if _, err := ParseRateLimit(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestLimiters(t *testing.T) {
+	l := NewLimiters(time.Minute)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	for i := range 2 {
+		if ok, _ := l.Allow("a", limit); !ok {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+	ok, wait := l.Allow("a", limit)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Errorf("over the burst: allowed %v, wait %s", ok, wait)
+	}
+	if ok, _ := l.Allow("b", limit); !ok {
+		t.Errorf("keys should have their own buckets")
+	}
+
+	// Check doesn't use up tokens, Take does
+	if ok, _ := l.Check("c", limit); !ok {
+		t.Error("Check: expected a token")
+	}
+	l.Take("c", limit)
+	l.Take("c", limit)
+	if ok, wait := l.Check("c", limit); ok || retryAfter(wait) != "1" {
+		t.Errorf("Check after Take: %v, Retry-After %s", ok, retryAfter(wait))
+	}
+
+	now = now.Add(time.Second)
+	if ok, _ := l.Allow("a", limit); !ok {
+		t.Error("expected a token to refill after 1s")
+	}
+
+	// idle buckets are forgotten
+	now = now.Add(2 * time.Minute)
+	_, _ = l.Allow("d", limit)
+	if n := l.Len(); n != 1 {
+		t.Errorf("expected idle buckets to be evicted, have %d", n)
+	}
+}
+
+func TestProxyRateLimits(t *testing.T) {
+	upstream := echoUpstream(t, "app")
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	cli.limiters = NewLimiters(time.Minute)
+	cli.authFailLimit = &RateLimit{Rate: rate.Every(time.Minute), Burst: 3}
+	h := cli.newAuthProxyHandler()
+
+	get := func(ip, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// per credential, from the Extra column (rate=2/m)
+	for i, want := range []int{200, 200, 429} {
+		if w := get("192.0.2.1", "bot-token"); w.Code != want {
+			t.Errorf("bot request %d: %d, want %d", i+1, w.Code, want)
+		} else if want == 429 && w.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After: %q, want 30", w.Header().Get("Retry-After"))
+		}
+	}
+	// jane has no limit
+	for range 5 {
+		if w := get("192.0.2.1", "jane-token"); w.Code != 200 {
+			t.Fatalf("jane: %d", w.Code)
+		}
+	}
+
+	// failed logins, per IP
+	for i := range 3 {
+		if w := get("192.0.2.2", "guess-"+string(rune('a'+i))); w.Code != http.StatusUnauthorized {
+			t.Errorf("guess %d: %d, want 401", i+1, w.Code)
+		}
+	}
+	w := get("192.0.2.2", "jane-token")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("after 3 failures: %d (Retry-After %q), want 429", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := get("192.0.2.3", "jane-token"); w.Code != 200 {
+		t.Errorf("another IP: %d, want 200", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Add("X-Forwarded-For", "10.0.0.1, 198.51.100.7")
+
+	cli := &MainConfig{}
+	if ip := cli.clientIP(r); ip != "127.0.0.1" {
+		t.Errorf("untrusted: %q", ip)
+	}
+	cli.TrustForwardedFor = true
+	if ip := cli.clientIP(r); ip != "198.51.100.7" {
+		t.Errorf("trusted: %q, want the address added by the proxy", ip)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Route forwards requests for a host and path prefix to an upstream
+type Route struct {
+	Host        string // empty for any host
+	Prefix      string // "/" for any path, otherwise without a trailing /
+	Target      *url.URL
+	StripPrefix bool
+	Timeout     time.Duration // 0 for the server's default
+	proxy       *httputil.ReverseProxy
+}
+
+// Routes are ordered from most to least specific (see ParseRoutes)
+type Routes []*Route
+
+// ParseRoutes reads one route per line, as `[host]/[path-prefix] upstream [options...]`,
+// skipping blank lines and # comments. Options are `strip` (remove the prefix before
+// forwarding) and `timeout=<duration>`.
+//
+//	git.example.com/         http://127.0.0.1:3000
+//	example.com/grafana/     http://127.0.0.1:3001  strip timeout=2m
+//	/                        http://127.0.0.1:8080
+func ParseRoutes(r io.Reader) (Routes, error) {
+	var routes Routes
+	scanner := bufio.NewScanner(r)
+	var n int
+	for scanner.Scan() {
+		n += 1
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("routes line %d: %q has no upstream", n, fields[0])
+		}
+
+		rt, err := NewRoute(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("routes line %d: %w", n, err)
+		}
+		for _, opt := range fields[2:] {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "strip":
+				rt.StripPrefix = true
+			case "timeout":
+				rt.Timeout, err = time.ParseDuration(value)
+				if err != nil || rt.Timeout < 0 {
+					return nil, fmt.Errorf("routes line %d: invalid timeout %q", n, value)
+				}
+			default:
+				return nil, fmt.Errorf("routes line %d: unknown option %q", n, opt)
+			}
+		}
+		routes = append(routes, rt)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	routes.sort()
+	return routes, nil
+}
+
+// NewRoute parses a `[host]/[path-prefix]` match and an http(s) upstream URL
+func NewRoute(match, upstream string) (*Route, error) {
+	idx := strings.Index(match, "/")
+	if idx < 0 {
+		return nil, fmt.Errorf("missing leading / from route %q", match)
+	}
+	prefix := strings.TrimRight(match[idx:], "/")
+
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an http:// or https:// URL", upstream)
+	}
+
+	return &Route{
+		Host:   strings.ToLower(match[:idx]),
+		Prefix: cmp.Or(prefix, "/"),
+		Target: target,
+	}, nil
+}
+
+// sort puts routes for a host before those for any host, and longer prefixes first
+func (routes Routes) sort() {
+	slices.SortStableFunc(routes, func(a, b *Route) int {
+		if (a.Host == "") != (b.Host == "") {
+			if a.Host == "" {
+				return 1
+			}
+			return -1
+		}
+		return len(b.Prefix) - len(a.Prefix)
+	})
+}
+
+// Match returns the first route for the host (which may include a port) and path, or nil
+func (routes Routes) Match(rHost, rPath string) *Route {
+	hostname, _, _ := strings.Cut(rHost, ":")
+	hostname = strings.ToLower(hostname)
+	for _, rt := range routes {
+		if rt.Host != "" && rt.Host != hostname {
+			continue
+		}
+		if _, ok := rt.cutPrefix(rPath); ok {
+			return rt
+		}
+	}
+	return nil
+}
+
+// cutPrefix returns the rest of the path after the route's prefix, which must
+// match whole path segments (/app matches /app and /app/x, but not /apple)
+func (rt *Route) cutPrefix(rPath string) (string, bool) {
+	if rt.Prefix == "/" {
+		return rPath, true
+	}
+	rest, ok := strings.CutPrefix(rPath, rt.Prefix)
+	if !ok {
+		return "", false
+	}
+	if rest == "" {
+		return "/", true
+	}
+	if !strings.HasPrefix(rest, "/") {
+		return "", false
+	}
+	return rest, true
+}
+
+func (rt *Route) String() string {
+	s := rt.Host + rt.Prefix + " => " + rt.Target.String()
+	if rt.StripPrefix {
+		s += " (strip)"
+	}
+	if rt.Timeout > 0 {
+		s += fmt.Sprintf(" (timeout %s)", rt.Timeout)
+	}
+	return s
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(strings.NewReader(`
+# [host]/[path-prefix]  upstream                 options
+/                       http://127.0.0.1:8080
+/grafana/               http://127.0.0.1:3001    strip timeout=2m
+Git.Example.com/        https://127.0.0.1:3000
+git.example.com/api     http://127.0.0.1:3002
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, rt := range routes {
+		got = append(got, rt.String())
+	}
+	want := []string{
+		"git.example.com/api => http://127.0.0.1:3002",
+		"git.example.com/ => https://127.0.0.1:3000",
+		"/grafana => http://127.0.0.1:3001 (strip) (timeout 2m0s)",
+		"/ => http://127.0.0.1:8080",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got routes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, bad := range []string{
+		"/only-a-path\n",
+		"example.com http://127.0.0.1:8080\n",
+		"/ 127.0.0.1:8080\n",
+		"/ http://127.0.0.1:8080 timeout=soon\n",
+		"/ http://127.0.0.1:8080 rewrite\n",
+	} {
+		if _, err := ParseRoutes(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRoutesMatch(t *testing.T) {
+	routes, err := ParseRoutes(strings.NewReader(`
+git.example.com/        http://git
+git.example.com/api/    http://git-api
+/grafana                http://grafana
+/                       http://default
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"git.example.com", "/", "http://git"},
+		{"git.example.com:443", "/repo", "http://git"},
+		{"GIT.example.com", "/api", "http://git-api"},
+		{"git.example.com", "/api/v1/repos", "http://git-api"},
+		{"git.example.com", "/apiary", "http://git"},
+		{"git.example.com", "/grafana/", "http://git"},
+		{"example.com", "/grafana", "http://grafana"},
+		{"example.com", "/grafana/d/abc", "http://grafana"},
+		{"example.com", "/grafanas", "http://default"},
+		{"", "/", "http://default"},
+	}
+	for _, tt := range tests {
+		rt := routes.Match(tt.host, tt.path)
+		if rt == nil || rt.Target.String() != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %s", tt.host, tt.path, rt, tt.want)
+		}
+	}
+
+	hostOnly := Routes{routes[0]}
+	if rt := hostOnly.Match("example.com", "/"); rt != nil {
+		t.Errorf("expected no route for another host, got %s", rt)
+	}
+}
+
+// newTestProxy returns an auth-proxy config for the given routes, where
+// "Bearer jane-token" may GET anything, "Bearer bot-token" may GET anything
+// at 2 requests per minute, and "guest" may GET /public/
+func newTestProxy(t *testing.T, routes string) *MainConfig {
+	t.Helper()
+	var key [16]byte
+	a := csvauth.New(key[:])
+	creds = NewCredentials(a)
+	for _, c := range []*csvauth.Credential{
+		a.NewCredential(csvauth.PurposeToken, "jane", "jane-token", []string{"plain"}, []string{"GET:/"}, ""),
+		a.NewCredential(csvauth.PurposeToken, "bot", "bot-token", []string{"plain"}, []string{"GET:/"}, "rate=2/m"),
+		a.NewCredential(csvauth.PurposeToken, "admin", "admin-token", []string{"plain"}, []string{"/", "!DELETE:/admin/"}, ""),
+		a.NewCredential(csvauth.PurposeToken, "viewer", "viewer-token", []string{"plain"}, []string{"GET:/reports/"}, ""),
+		a.NewCredential(csvauth.PurposeDefault, "guest", "", []string{"plain"}, []string{"GET:/public/"}, ""),
+	} {
+		if err := a.CacheCredential(*c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var err error
+	cli := &MainConfig{
+		ra: &auth.BasicRequestAuthenticator{
+			Authenticator:        creds,
+			AuthorizationSchemes: []string{"Bearer"},
+			BasicRealm:           "Basic",
+		},
+	}
+	cli.routes, err = ParseRoutes(strings.NewReader(routes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+// echoUpstream responds with its name and the path and prefix it was given
+func echoUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name+" "+r.URL.Path+" "+r.Header.Get("X-Forwarded-Prefix"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxyRoutes(t *testing.T) {
+	app := echoUpstream(t, "app")
+	grafana := echoUpstream(t, "grafana")
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	cli := newTestProxy(t, `
+tools.example.com/grafana/   `+grafana.URL+`  strip
+tools.example.com/slow       `+slow.URL+`     timeout=50ms
+app.example.com/             `+app.URL+`
+`)
+	h := cli.newAuthProxyHandler()
+
+	get := func(host, path string, login bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://"+host+path, nil)
+		if login {
+			r.Header.Set("Authorization", "Bearer jane-token")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		host   string
+		path   string
+		login  bool
+		status int
+		body   string
+	}{
+		{"app.example.com", "/dashboard", true, http.StatusOK, "app /dashboard "},
+		{"tools.example.com", "/grafana/d/abc", true, http.StatusOK, "grafana /d/abc /grafana"},
+		{"tools.example.com", "/grafana", true, http.StatusOK, "grafana / /grafana"},
+		// authorization comes before routing
+		{"tools.example.com", "/grafana/d/abc", false, http.StatusUnauthorized, ""},
+		{"nowhere.example.com", "/", false, http.StatusUnauthorized, ""},
+		{"nowhere.example.com", "/", true, http.StatusNotFound, ""},
+		{"tools.example.com", "/slow", true, http.StatusGatewayTimeout, ""},
+	}
+	for _, tt := range tests {
+		w := get(tt.host, tt.path, tt.login)
+		if w.Code != tt.status {
+			t.Errorf("GET %s%s: %d, want %d", tt.host, tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("GET %s%s: %q, want %q", tt.host, tt.path, w.Body.String(), tt.body)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sseHeartbeat is an event stream comment, which clients ignore
+var sseHeartbeat = []byte(": heartbeat\n\n")
+
+// wsPing is an empty, unmasked (server to client) WebSocket ping frame
+var wsPing = []byte{0x80 | wsOpPing, 0}
+
+const (
+	wsOpPing = 0x9
+	wsOpPong = 0xA
+)
+
+// isStream reports whether r asks for a long-lived response: a connection upgrade
+// (such as a WebSocket), or an event stream (which is what EventSource asks for)
+func isStream(r *http.Request) bool {
+	return isUpgrade(r) || isEventStream(r.Header.Get("Accept"))
+}
+
+// isUpgrade reports whether r has "Connection: Upgrade" and an Upgrade protocol
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isEventStream reports whether a media type (or Accept list) includes text/event-stream
+func isEventStream(v string) bool {
+	for mediaType := range strings.SplitSeq(v, ",") {
+		if mt, _, _ := mime.ParseMediaType(mediaType); mt == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// serveStream proxies a stream (which has already been authorized) without the
+// server's write timeout, sending a heartbeat whenever it has been quiet for the
+// heartbeat interval, and ending the stream once no data has gone either way for
+// the idle timeout. It reports whether the connection was upgraded (hijacked).
+func (cli *MainConfig) serveStream(w http.ResponseWriter, r *http.Request, proxy http.Handler) bool {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s := &streamWriter{
+		ResponseWriter: w,
+		websocket:      strings.EqualFold(r.Header.Get("Upgrade"), "websocket"),
+	}
+	s.touch()
+	stop := s.watch(cli.StreamHeartbeat, cli.StreamIdleTimeout, cancel)
+	defer stop()
+
+	proxy.ServeHTTP(s, r.WithContext(ctx))
+	return s.hijacked
+}
+
+// streamWriter tracks activity on an event stream or upgraded connection, and
+// sends heartbeats between (never within) events or frames
+type streamWriter struct {
+	http.ResponseWriter
+	websocket bool
+
+	lastActive atomic.Int64 // unix nanos of the last data, either way
+	conn       atomic.Pointer[streamConn]
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	sse       bool   // the response is an event stream
+	tail      []byte // the end of the last write, to find event boundaries
+	hijacked  bool
+}
+
+func (s *streamWriter) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *streamWriter) WriteHeader(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sse = status == http.StatusOK && isEventStream(s.Header().Get("Content-Type"))
+	s.tail = []byte("\n\n") // an event may start right away
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.ResponseWriter.Write(b)
+	if n > 0 {
+		s.touch()
+		s.lastWrite = time.Now()
+		s.tail = append(s.tail, b[:n]...)
+		s.tail = s.tail[max(0, len(s.tail)-4):]
+	}
+	return n, err
+}
+
+// FlushError is used by http.ResponseController, and keeps flushes from racing heartbeats
+func (s *streamWriter) FlushError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+// Hijack is used by httputil.ReverseProxy for upgrades
+func (s *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc := &streamConn{Conn: conn, s: s}
+	s.conn.Store(sc)
+	s.hijacked = true
+	// the response to the upgrade request is written with brw, so it must also go through conn
+	brw.Writer = bufio.NewWriter(sc)
+	return sc, brw, nil
+}
+
+// Unwrap allows http.ResponseController to set deadlines
+func (s *streamWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// watch checks for quiet and idle streams until stopped
+func (s *streamWriter) watch(heartbeat, idle time.Duration, cancel context.CancelFunc) (stop func()) {
+	interval := heartbeat
+	if interval <= 0 {
+		interval = idle
+	}
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if idle > 0 && now.Sub(time.Unix(0, s.lastActive.Load())) >= idle {
+					s.end(cancel)
+					return
+				}
+				if heartbeat > 0 {
+					s.heartbeat(now, heartbeat)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// end cancels an idle stream, including any write that is stuck on a client that has gone away
+func (s *streamWriter) end(cancel context.CancelFunc) {
+	cancel()
+	now := time.Now()
+	if conn := s.conn.Load(); conn != nil {
+		_ = conn.SetDeadline(now)
+		return
+	}
+	_ = http.NewResponseController(s.ResponseWriter).SetWriteDeadline(now)
+}
+
+// heartbeat sends an event stream comment or a WebSocket ping, if nothing has been
+// sent for the interval, and the stream is between events or frames
+func (s *streamWriter) heartbeat(now time.Time, interval time.Duration) {
+	// skip this beat if a write is in progress (or stuck)
+	if !s.mu.TryLock() {
+		return
+	}
+	defer s.mu.Unlock()
+	if now.Sub(s.lastWrite) < interval {
+		return
+	}
+
+	conn := s.conn.Load()
+	switch {
+	case conn != nil:
+		if !s.websocket || !conn.upgraded || !conn.out.atBoundary() {
+			return
+		}
+		_ = conn.SetWriteDeadline(now.Add(interval))
+		_, _ = conn.Conn.Write(wsPing)
+		_ = conn.SetWriteDeadline(time.Time{})
+	case s.sse:
+		if !eventBoundary(s.tail) {
+			return
+		}
+		if _, err := s.ResponseWriter.Write(sseHeartbeat); err != nil {
+			return
+		}
+		_ = http.NewResponseController(s.ResponseWriter).Flush()
+		s.tail = append(s.tail[:0], "\n\n"...)
+	default:
+		return
+	}
+	s.lastWrite = now
+}
+
+// eventBoundary reports whether an event stream that ends in tail is between events
+func eventBoundary(tail []byte) bool {
+	return bytes.HasSuffix(tail, []byte("\n\n")) || bytes.HasSuffix(tail, []byte("\r\r")) ||
+		bytes.HasSuffix(tail, []byte("\r\n\r\n"))
+}
+
+// streamConn is an upgraded connection to the client
+type streamConn struct {
+	net.Conn
+	s *streamWriter
+
+	in wsFrames // read by the copy to the upstream
+
+	// guarded by s.mu
+	upgraded bool   // the response to the upgrade request has been written
+	head     []byte // the end of the response so far, to find where it ends
+	out      wsFrames
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && (!c.s.websocket || c.in.scan(p[:n])) {
+		c.s.touch()
+	}
+	return n, err
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	n, err := c.Conn.Write(p)
+	written := p[:n]
+	if !c.upgraded {
+		c.head = append(c.head, written...)
+		end := bytes.Index(c.head, []byte("\r\n\r\n"))
+		if end < 0 {
+			c.head = c.head[max(0, len(c.head)-3):]
+			return n, err
+		}
+		c.upgraded = true
+		written = c.head[end+4:]
+		c.head = nil
+	}
+	if len(written) > 0 {
+		c.s.lastWrite = time.Now()
+		if !c.s.websocket || c.out.scan(written) {
+			c.s.touch()
+		}
+	}
+	return n, err
+}
+
+// wsFrames follows the frame headers in one direction of a WebSocket connection,
+// to know where frames begin and end, and which are only pongs
+type wsFrames struct {
+	header []byte // a header, so far
+	remain uint64 // payload bytes left in the current frame
+}
+
+// scan reads through p, and reports whether it started any frame other than a pong
+func (f *wsFrames) scan(p []byte) bool {
+	var data bool
+	for len(p) > 0 {
+		if f.remain > 0 {
+			n := min(uint64(len(p)), f.remain)
+			f.remain -= n
+			p = p[n:]
+			continue
+		}
+
+		f.header = append(f.header, p[0])
+		p = p[1:]
+		size, payload, ok := wsHeader(f.header)
+		if !ok || len(f.header) < size {
+			continue
+		}
+		if f.header[0]&0x0f != wsOpPong {
+			data = true
+		}
+		f.remain = payload
+		f.header = f.header[:0]
+	}
+	return data
+}
+
+// atBoundary reports whether a new frame may be written
+func (f *wsFrames) atBoundary() bool {
+	return len(f.header) == 0 && f.remain == 0
+}
+
+// wsHeader returns the size of the header, and the size of its payload,
+// once enough of the header is known
+func wsHeader(h []byte) (size int, payload uint64, ok bool) {
+	if len(h) < 2 {
+		return 0, 0, false
+	}
+	size = 2
+	payload = uint64(h[1] & 0x7f)
+	switch payload {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4 // masking key
+	}
+	if len(h) < size {
+		return size, 0, true
+	}
+	switch payload {
+	case 126:
+		payload = uint64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		payload = binary.BigEndian.Uint64(h[2:10])
+	}
+	return size, payload, true
+}
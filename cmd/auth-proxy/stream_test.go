@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStreamProxy serves the proxy with a short WriteTimeout, which streams must outlive
+func newStreamProxy(t *testing.T, upstream string) *httptest.Server {
+	t.Helper()
+	cli := newTestProxy(t, "/ "+upstream)
+	cli.StreamHeartbeat = 50 * time.Millisecond
+	cli.StreamIdleTimeout = 400 * time.Millisecond
+	srv := httptest.NewUnstartedServer(cli.newAuthProxyHandler())
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxySSE(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		rc := http.NewResponseController(w)
+		for _, event := range []string{"data: one\n\n", "data: two\n\n"} {
+			_, _ = io.WriteString(w, event)
+			_ = rc.Flush()
+			// longer than the heartbeat, and the proxy's WriteTimeout
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(300 * time.Millisecond):
+			}
+		}
+		// then nothing, until the proxy gives up on the idle stream
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	proxy := newStreamProxy(t, upstream.URL)
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("the stream should be authorized, got %d", res.StatusCode)
+	}
+	_ = res.Body.Close()
+
+	req.Header.Set("Authorization", "Bearer jane-token")
+	start := time.Now()
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%d", res.StatusCode)
+	}
+
+	var events, heartbeats int
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		switch line := scanner.Text(); {
+		case strings.HasPrefix(line, "data: "):
+			events++
+		case line == ": heartbeat":
+			heartbeats++
+		case line == "":
+		default:
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+	// the proxy aborts the idle stream (an unexpected EOF), and EventSource would reconnect
+	if events != 2 {
+		t.Errorf("expected 2 events, got %d", events)
+	}
+	if heartbeats == 0 {
+		t.Errorf("expected heartbeats while the upstream was quiet")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("the idle stream should have ended, but took %s", d)
+	}
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(wsEcho))
+	defer upstream.Close()
+	proxy := newStreamProxy(t, upstream.URL)
+
+	// unauthorized upgrades are refused
+	conn, br, res := wsDial(t, proxy.URL, "")
+	_ = conn.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("the upgrade should be authorized, got %d", res.StatusCode)
+	}
+
+	conn, br, res = wsDial(t, proxy.URL, "jane-token")
+	defer func() { _ = conn.Close() }()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("%d", res.StatusCode)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// outlive the proxy's WriteTimeout
+	time.Sleep(250 * time.Millisecond)
+	if err := wsWriteFrame(conn, 0x1, []byte("hello"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	var echoed string
+	var pings int
+	start := time.Now()
+	for {
+		op, payload, err := wsReadFrame(br)
+		if err != nil {
+			// the idle timeout ends the stream
+			break
+		}
+		switch op {
+		case wsOpPing:
+			pings++
+		case 0x1:
+			echoed = string(payload)
+		default:
+			t.Errorf("unexpected opcode %x", op)
+		}
+	}
+	if echoed != "hello" {
+		t.Errorf("echo: %q", echoed)
+	}
+	if pings == 0 {
+		t.Errorf("expected heartbeat pings while the connection was quiet")
+	}
+	if d := time.Since(start); d > 4*time.Second {
+		t.Errorf("the idle connection should have ended, but took %s", d)
+	}
+}
+
+func TestWSFrames(t *testing.T) {
+	var frames []byte
+	for _, f := range []struct {
+		op      byte
+		payload int
+		masked  bool
+	}{
+		{0x1, 5, false},
+		{0x2, 300, true},
+		{wsOpPong, 0, true},
+		{0x2, 70000, false},
+	} {
+		var b strings.Builder
+		_ = wsWriteFrame(&b, f.op, make([]byte, f.payload), f.masked)
+		frames = append(frames, b.String()...)
+	}
+
+	// split in awkward places, including within headers
+	var f wsFrames
+	var data bool
+	for chunk := range slices.Chunk(frames, 3) {
+		data = f.scan(chunk) || data
+	}
+	if !data || !f.atBoundary() {
+		t.Errorf("data %v, at boundary %v", data, f.atBoundary())
+	}
+
+	var pong strings.Builder
+	_ = wsWriteFrame(&pong, wsOpPong, []byte("x"), true)
+	if f.scan([]byte(pong.String())) {
+		t.Errorf("a pong is not data")
+	}
+	if f.scan([]byte(pong.String())[:1]); f.atBoundary() {
+		t.Errorf("a partial header is not a boundary")
+	}
+}
+
+// wsDial sends a WebSocket upgrade request, and reads the response
+func wsDial(t *testing.T, rawURL, token string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(rawURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", rawURL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString([]byte(rand.Text()[:16])))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, res
+}
+
+// wsEcho is a minimal WebSocket server that echoes text and binary frames
+func wsEcho(w http.ResponseWriter, r *http.Request) {
+	h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	_, _ = fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(h[:]))
+	_ = brw.Flush()
+	for {
+		op, payload, err := wsReadFrame(brw.Reader)
+		if err != nil {
+			return
+		}
+		if op == 0x1 || op == 0x2 {
+			if err := wsWriteFrame(conn, op, payload, false); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func wsWriteFrame(w io.Writer, op byte, payload []byte, masked bool) error {
+	header := []byte{0x80 | op, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if masked {
+		header[1] |= 0x80
+		var key [4]byte
+		_, _ = rand.Read(key[:])
+		header = append(header, key[:]...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ key[i%4]
+		}
+		payload = masked
+	}
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+func wsReadFrame(r io.Reader) (byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var key [4]byte
+	masked := h[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return h[0] & 0x0f, payload, nil
+}
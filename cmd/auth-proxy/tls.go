@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/therootcompany/golib/auth"
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+var ErrUnknownCert = errors.New("client certificate does not name a known credential")
+
+// CertReloader serves a certificate and key from files, and re-reads them
+// (at most once per CheckInterval) when either file changes
+type CertReloader struct {
+	CertPath      string
+	KeyPath       string
+	CheckInterval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the certificate and key, which must be valid to start
+func NewCertReloader(certPath, keyPath string) (*CertReloader, error) {
+	cr := &CertReloader{CertPath: certPath, KeyPath: keyPath, CheckInterval: time.Second}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *CertReloader) reload() error {
+	certInfo, err := os.Stat(cr.CertPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(cr.KeyPath)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.CertPath, cr.KeyPath)
+	if err != nil {
+		return err
+	}
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate is for tls.Config. If the files have changed but can't be
+// loaded (such as in the middle of a renewal), the current certificate is kept.
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cr.lastCheck) < cr.CheckInterval {
+		return cr.cert, nil
+	}
+	cr.lastCheck = now
+
+	certInfo, certErr := os.Stat(cr.CertPath)
+	keyInfo, keyErr := os.Stat(cr.KeyPath)
+	if certErr != nil || keyErr != nil {
+		return cr.cert, nil
+	}
+	if certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return cr.cert, nil
+	}
+	if err := cr.reload(); err != nil {
+		log.Printf("Failed to reload TLS certificate (keeping the current one): %v", err)
+		return cr.cert, nil
+	}
+	log.Printf("Reloaded TLS certificate from %s", cr.CertPath)
+	return cr.cert, nil
+}
+
+// newTLSConfig serves the --tls-cert, and asks for (but doesn't require) client
+// certificates signed by the --tls-client-ca, so that tokens and logins still work
+func (cli *MainConfig) newTLSConfig() (*tls.Config, error) {
+	cr, err := NewCertReloader(cli.TLSCertPath, cli.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load --tls-cert and --tls-key: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+
+	if cli.TLSClientCAPath != "" {
+		pem, err := os.ReadFile(cli.TLSClientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("read --tls-client-ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in --tls-client-ca %q", cli.TLSClientCAPath)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// CertPrinciple is a verified client certificate, with the grants (and Extra)
+// of the csvauth credential that it names
+type CertPrinciple struct {
+	Name       string
+	Credential *csvauth.Credential
+}
+
+func (p *CertPrinciple) ID() string {
+	return p.Name
+}
+
+func (p *CertPrinciple) Permissions() []string {
+	return p.Credential.Roles
+}
+
+// certNames returns the names of the certificate that may name a credential:
+// "san" for the URI, DNS, and email SANs, "cn" for the Subject CommonName, or "any" for both
+func certNames(cert *x509.Certificate, which string) []string {
+	var names []string
+	if which == "san" || which == "any" {
+		for _, u := range cert.URIs {
+			names = append(names, u.String())
+		}
+		names = append(names, cert.DNSNames...)
+		names = append(names, cert.EmailAddresses...)
+	}
+	if (which == "cn" || which == "any") && cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// authenticateCert returns the principle for a verified client certificate,
+// or auth.ErrNoCredentials if there isn't one
+func (cli *MainConfig) authenticateCert(r *http.Request) (auth.BasicPrinciple, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, auth.ErrNoCredentials
+	}
+
+	for _, name := range certNames(r.TLS.PeerCertificates[0], cli.TLSClientID) {
+		if name == "guest" {
+			continue
+		}
+		c, err := creds.Load().LoadCredential(name)
+		if err != nil {
+			continue
+		}
+		return &CertPrinciple{Name: name, Credential: &c}, nil
+	}
+	return nil, ErrUnknownCert
+}
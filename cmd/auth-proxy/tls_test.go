@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/auth/csvauth"
+)
+
+// testCA is a self-signed CA that issues server and client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key, for the template's names
+func (ca *testCA) issue(t *testing.T, serial int64, tmpl *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) issueServer(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	return ca.issue(t, serial, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) issueClient(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certPEM, keyPEM := ca.issue(t, 100, tmpl)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFiles(t *testing.T, files map[string][]byte) {
+	t.Helper()
+	for path, b := range files {
+		if err := os.WriteFile(path, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	certPEM, keyPEM := ca.issueServer(t, 2)
+	writeFiles(t, map[string][]byte{certPath: certPEM, keyPath: keyPEM})
+	cr, err := NewCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.CheckInterval = 0
+
+	serial := func() int64 {
+		t.Helper()
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Leaf.SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("serial %d", got)
+	}
+
+	// renewed
+	certPEM, keyPEM = ca.issueServer(t, 3)
+	writeFiles(t, map[string][]byte{certPath: certPEM, keyPath: keyPEM})
+	later := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certPath, later, later)
+	_ = os.Chtimes(keyPath, later, later)
+	if got := serial(); got != 3 {
+		t.Errorf("expected the renewed certificate, got serial %d", got)
+	}
+
+	// half-written, so keep the current one
+	writeFiles(t, map[string][]byte{certPath: certPEM[:20]})
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(certPath, later, later)
+	if got := serial(); got != 3 {
+		t.Errorf("expected to keep the current certificate, got serial %d", got)
+	}
+}
+
+func TestProxyClientCerts(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issueServer(t, 2)
+	files := map[string][]byte{
+		filepath.Join(dir, "cert.pem"): certPEM,
+		filepath.Join(dir, "key.pem"):  keyPEM,
+		filepath.Join(dir, "ca.pem"):   ca.pem,
+	}
+	writeFiles(t, files)
+
+	upstream := echoUpstream(t, "app")
+	cli := newTestProxy(t, "/ "+upstream.URL)
+	bot := creds.Load().NewCredential(csvauth.PurposeDefault, "build-bot", "unused-password", []string{"plain"}, []string{"GET:/builds/"}, "")
+	if err := creds.Load().CacheCredential(*bot); err != nil {
+		t.Fatal(err)
+	}
+	cli.TLSCertPath = filepath.Join(dir, "cert.pem")
+	cli.TLSKeyPath = filepath.Join(dir, "key.pem")
+	cli.TLSClientCAPath = filepath.Join(dir, "ca.pem")
+	cli.TLSClientID = "any"
+	tlsConfig, err := cli.newTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: cli.newAuthProxyHandler()}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	baseURL := "https://" + ln.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	other := newTestCA(t)
+	certs := map[string][]tls.Certificate{
+		"none":      nil,
+		"cn":        {ca.issueClient(t, &x509.Certificate{Subject: pkix.Name{CommonName: "build-bot"}})},
+		"san":       {ca.issueClient(t, &x509.Certificate{Subject: pkix.Name{CommonName: "whoever"}, DNSNames: []string{"build-bot"}})},
+		"unknown":   {ca.issueClient(t, &x509.Certificate{Subject: pkix.Name{CommonName: "stranger"}})},
+		"guest":     {ca.issueClient(t, &x509.Certificate{Subject: pkix.Name{CommonName: "guest"}})},
+		"untrusted": {other.issueClient(t, &x509.Certificate{Subject: pkix.Name{CommonName: "build-bot"}})},
+	}
+
+	tests := []struct {
+		name string
+		cert string
+		path string
+		want int
+	}{
+		{"no cert, as guest", "none", "/public/", http.StatusOK},
+		{"no cert", "none", "/builds/", http.StatusUnauthorized},
+		{"subject", "cn", "/builds/", http.StatusOK},
+		{"subject, grants still apply", "cn", "/public/", http.StatusForbidden},
+		{"SAN", "san", "/builds/", http.StatusOK},
+		{"no such credential", "unknown", "/builds/", http.StatusUnauthorized},
+		{"not the guest", "guest", "/public/", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: certs[tt.cert],
+			}}}
+			res, err := client.Get(baseURL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("%d, want %d: %s", res.StatusCode, tt.want, body)
+			}
+		})
+	}
+
+	t.Run("untrusted CA", func(t *testing.T) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs["untrusted"],
+		}}}
+		if res, err := client.Get(baseURL + "/builds/"); err == nil {
+			_ = res.Body.Close()
+			t.Errorf("expected the handshake to fail, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("SAN only", func(t *testing.T) {
+		cli.TLSClientID = "san"
+		defer func() { cli.TLSClientID = "any" }()
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs["cn"],
+		}}}
+		res, err := client.Get(baseURL + "/builds/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("the subject should be ignored, got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// AttemptSending is written before each send, so that a crash during one
+	// leaves the message as uncertain, rather than unsent
+	AttemptSending = "sending"
+	AttemptSent    = "sent"
+	AttemptFailed  = "failed"
+)
+
+// Attempt is one line of the campaign state file, written before and after each send
+type Attempt struct {
+	Key       string    `json:"key"`
+	Number    string    `json:"number"`
+	Name      string    `json:"name,omitempty"`
+	Status    string    `json:"status"`
+	Attempt   int       `json:"attempt"`
+	MessageID string    `json:"messageId,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// Campaign is the send log of a messages.csv, so that an interrupted run can resume
+type Campaign struct {
+	Path string
+	// Last is the most recent attempt for each key
+	Last map[string]Attempt
+	// Invalid counts lines that couldn't be parsed (such as one cut short by a crash)
+	Invalid int
+	file    *os.File
+}
+
+// MessageKey identifies a row by its number and rendered text,
+// so that editing the message (or the list) doesn't skip anyone
+func MessageKey(number, text string) string {
+	sum := sha256.Sum256([]byte(number + "\x00" + text))
+	return hex.EncodeToString(sum[:16])
+}
+
+// DefaultStatePath is ./messages.state.jsonl for ./messages.csv
+func DefaultStatePath(csvPath string) string {
+	return strings.TrimSuffix(csvPath, filepath.Ext(csvPath)) + ".state.jsonl"
+}
+
+// ReadCampaign reads the state file, if it exists, without opening it for writing
+func ReadCampaign(path string) (*Campaign, error) {
+	c := &Campaign{Path: path, Last: map[string]Attempt{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var a Attempt
+		if err := json.Unmarshal([]byte(line), &a); err != nil || a.Key == "" {
+			c.Invalid++
+			continue
+		}
+		c.Last[a.Key] = a
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// OpenCampaign reads the state file, and opens it for appending
+func OpenCampaign(path string) (*Campaign, error) {
+	c, err := ReadCampaign(path)
+	if err != nil {
+		return nil, err
+	}
+	c.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// a line cut short by a crash mustn't run into the next one
+	if c.Invalid > 0 {
+		if _, err := c.file.Write([]byte("\n")); err != nil {
+			_ = c.file.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Record appends the attempt, and syncs it to disk before the next step
+func (c *Campaign) Record(a Attempt) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := c.file.Sync(); err != nil {
+		return err
+	}
+	c.Last[a.Key] = a
+	return nil
+}
+
+// Sent reports whether the message has already been sent
+func (c *Campaign) Sent(key string) bool {
+	return c.Last[key].Status == AttemptSent
+}
+
+// Attempts is how many times the message has been tried
+func (c *Campaign) Attempts(key string) int {
+	return c.Last[key].Attempt
+}
+
+func (c *Campaign) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const campaignCSV = `Name,Phone,Message
+Jim,(800) 555-1234,"Hey {Name}, are you coming?"
+Bob,+18005554321,"Hey {Name}, are you coming?"
+Joe,1.800.555.3412,"Hey {Name}, are you coming?"
+Joe,1.800.555.3412,"Hey {Name}, are you coming?"
+Sue,800-555-9999,"Hey {Name}, are you coming?"
+`
+
+func parseCampaignCSV(t *testing.T) []SMSMessage {
+	t.Helper()
+	cfg := MainConfig{}
+	messages, _, err := cfg.LaxParseCSV(csv.NewReader(strings.NewReader(campaignCSV)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func numbers(messages []SMSMessage) string {
+	var nums []string
+	for _, message := range messages {
+		nums = append(nums, message.Number)
+	}
+	return strings.Join(nums, ",")
+}
+
+func TestCampaignResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.state.jsonl")
+	messages := parseCampaignCSV(t)
+
+	campaign, err := OpenCampaign(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := MainConfig{maxRetries: 1}
+	remaining, err := cfg.resumeCampaign(campaign, messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the duplicate row is only sent once
+	if got := numbers(remaining); got != "+18005551234,+18005554321,+18005553412,+18005559999" {
+		t.Fatalf("first run: %s", got)
+	}
+
+	// Jim was sent, Bob failed, and then the process died
+	at := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	_ = campaign.Record(Attempt{Key: messages[0].Key, Number: messages[0].Number, Status: AttemptSending, Attempt: 1, At: at})
+	_ = campaign.Record(Attempt{Key: messages[0].Key, Number: messages[0].Number, Status: AttemptSent, Attempt: 1, MessageID: "m1", At: at})
+	_ = campaign.Record(Attempt{Key: messages[1].Key, Number: messages[1].Number, Status: AttemptFailed, Attempt: 1, Error: "503", At: at})
+	_ = campaign.Close()
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString(`{"key":"` + messages[2].Key + `","status":"se`)
+	_ = f.Close()
+
+	campaign, err = OpenCampaign(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaign.Invalid != 1 {
+		t.Errorf("invalid lines: %d", campaign.Invalid)
+	}
+	if _, err := cfg.resumeCampaign(campaign, messages); err == nil {
+		t.Fatal("rerunning without --resume should fail")
+	}
+	cfg.resume = true
+	remaining, _ = cfg.resumeCampaign(campaign, messages)
+	if got := numbers(remaining); got != "+18005554321,+18005553412,+18005559999" {
+		t.Fatalf("resumed: %s", got)
+	}
+
+	// Bob fails again, which is as many retries as allowed,
+	// and the process dies while sending to Sue
+	_ = campaign.Record(Attempt{Key: messages[1].Key, Number: messages[1].Number, Status: AttemptFailed, Attempt: 2, Error: "503", At: at})
+	_ = campaign.Record(Attempt{Key: messages[2].Key, Number: messages[2].Number, Status: AttemptSent, Attempt: 1, MessageID: "m3", At: at})
+	_ = campaign.Record(Attempt{Key: messages[4].Key, Number: messages[4].Number, Status: AttemptSending, Attempt: 1, At: at})
+	_ = campaign.Close()
+
+	campaign, err = ReadCampaign(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sue may have gotten it, so she's only sent to again when asked
+	remaining, _ = cfg.resumeCampaign(campaign, messages)
+	if got := numbers(remaining); got != "" {
+		t.Fatalf("resumed again: %s", got)
+	}
+	cfg.retryUncertain = true
+	remaining, _ = cfg.resumeCampaign(campaign, messages)
+	if got := numbers(remaining); got != "+18005559999" {
+		t.Fatalf("resumed with --retry-uncertain: %s", got)
+	}
+
+	deliveries, err := ReadDeliveries(strings.NewReader(strings.Join([]string{
+		"deviceId\tevent\tid\tmessage\tmessageId\tphoneNumber",
+		"dev1\tsms:delivered\tev1\t\tm1\t+18005551234",
+		"dev1\tsms:sent\tev2\t\tm3\t+18005553412",
+		"",
+	}, "\n")), "delivered.tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := campaign.Report(messages, deliveries)
+	if report.Sent != 2 || report.Delivered != 1 || report.Failed != 1 || report.Uncertain != 1 || report.Pending != 0 || report.Unlisted != 0 {
+		t.Errorf("report: %+v", report)
+	}
+
+	// the same, as saved by smsapid
+	deliveries, err = ReadDeliveries(strings.NewReader(
+		`{"event":"sms:delivered","id":"ev1","payload":{"messageId":"m3","phoneNumber":"+18005553412"}}`+"\n",
+	), "messages.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := campaign.Report(nil, deliveries); report.Sent != 2 || report.Delivered != 1 || report.Failed != 1 || report.Uncertain != 1 || report.Pending != 0 {
+		t.Errorf("report without the csv: %+v", report)
+	}
+}
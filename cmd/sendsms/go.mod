@@ -6,3 +6,5 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/therootcompany/golib/http/androidsmsgateway v0.0.0-20260223054429-c8f26aca7c6d
 )
+
+replace github.com/therootcompany/golib/http/androidsmsgateway => ../../http/androidsmsgateway
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"flag"
 	"fmt"
@@ -21,7 +22,7 @@ import (
 
 type SMSSender interface {
 	CurlString(to, text string) string
-	Send(to, text string) error
+	Send(ctx context.Context, to, text string) (*androidsmsgateway.MessageState, error)
 }
 
 type SMSMessage struct {
@@ -30,6 +31,8 @@ type SMSMessage struct {
 	Template string
 	Vars     map[string]string
 	Text     string
+	// Key identifies the message in the campaign state file
+	Key string
 }
 
 var ErrInvalidClockFormat = fmt.Errorf("invalid clock time, ex: '06:00 PM', '6pm', or '18:00' (space and case insensitive)")
@@ -56,6 +59,11 @@ type MainConfig struct {
 	delay       time.Duration
 	verbose     bool
 	confirmed   bool
+	statePath   string
+	resume      bool
+	maxRetries  int
+	// retryUncertain resends messages that were being sent when a run stopped
+	retryUncertain bool
 }
 
 const (
@@ -80,6 +88,14 @@ func main() {
 
 	_ = godotenv.Load("./.env")
 
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := runReport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%sError%s: %v\n", textErr, textReset, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// note: we could also use twilio, or whatever
 	var sender SMSSender = androidsmsgateway.New(
 		os.Getenv("SMSGW_BASEURL"),
@@ -114,7 +130,14 @@ func main() {
 	flag.StringVar(&cfg.endClock, "end-time", "8:30pm", "don't send messages after this time (e.g. 4pm, 23:59)")
 	flag.DurationVar(&cfg.maxDuration, "max-duration", 0, "don't send messages for more than this long (e.g. 10m, 2h30m, 6h)")
 	flag.DurationVar(&cfg.minDelay, "min-delay", 0, "don't send messages closer together on average than this (e.g. 10s, 2m) (Default: 20s)")
+	flag.StringVar(&cfg.statePath, "state", "", "Path to the campaign state file, which logs each send (Default: ./messages.state.jsonl for ./messages.csv)")
+	flag.BoolVar(&cfg.resume, "resume", false, "Skip messages that the state file shows were sent, and retry those that failed")
+	flag.IntVar(&cfg.maxRetries, "max-retries", 2, "With --resume, don't retry a failed message more than this many times")
+	flag.BoolVar(&cfg.retryUncertain, "retry-uncertain", false, "With --resume, also resend messages that were being sent when the last run stopped (which may send them twice)")
 	flag.Parse()
+	if cfg.statePath == "" {
+		cfg.statePath = DefaultStatePath(cfg.csvPath)
+	}
 
 	fmt.Fprintf(os.Stderr, "Info: Time zone: %s, Offset: %.2fh\n", zoneName, float64(offset)/3600)
 	// os.Exit(1)
@@ -177,6 +200,33 @@ func main() {
 	}
 	fmt.Fprintf(os.Stderr, "Info: list of %d messages\n", len(messages))
 
+	// the dry run doesn't create (or write to) the state file
+	var campaign *Campaign
+	if cfg.dryRun {
+		campaign, err = ReadCampaign(cfg.statePath)
+	} else {
+		campaign, err = OpenCampaign(cfg.statePath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%sError%s: could not read campaign state: %v\n", textErr, textReset, err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = campaign.Close()
+	}()
+	if campaign.Invalid > 0 {
+		fmt.Fprintf(os.Stderr, "%sWarning%s: skipped %d unreadable lines in %q\n", textWarn, textReset, campaign.Invalid, cfg.statePath)
+	}
+	messages, err = cfg.resumeCampaign(campaign, messages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%sError%s: %v\n", textErr, textReset, err)
+		os.Exit(1)
+	}
+	if cfg.resume && len(messages) == 0 {
+		fmt.Fprintf(os.Stderr, "Info: nothing left to send (see '%s report')\n", os.Args[0])
+		return
+	}
+
 	if now.After(cfg.endTime) || now.Equal(cfg.endTime) {
 		fmt.Fprintf(os.Stderr, "%sWarning%s: Too late now. %sWaiting until tomorrow%s:\n", textWarn, textReset, textWarn, textReset)
 
@@ -326,9 +376,34 @@ func main() {
 			continue
 		}
 
-		if err := sender.Send(message.Number, message.Text); err != nil {
+		attempt := Attempt{
+			Key:     message.Key,
+			Number:  message.Number,
+			Name:    message.Name,
+			Status:  AttemptSending,
+			Attempt: campaign.Attempts(message.Key) + 1,
+			At:      time.Now(),
+		}
+		// if we crash mid-send, a rerun mustn't assume that this wasn't sent
+		if err := campaign.Record(attempt); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%sError%s: could not save campaign state: %v\n", textErr, textReset, err)
+			os.Exit(1)
+		}
+
+		state, err := sender.Send(context.Background(), message.Number, message.Text)
+		attempt.Status = AttemptSent
+		attempt.At = time.Now()
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%sError%s: %v\n", textErr, textReset, err)
-			continue
+			attempt.Status = AttemptFailed
+			attempt.Error = err.Error()
+		} else {
+			attempt.MessageID = state.ID
+		}
+		if err := campaign.Record(attempt); err != nil {
+			// without the log, a rerun could send this again
+			fmt.Fprintf(os.Stderr, "\n%sError%s: could not save campaign state: %v\n", textErr, textReset, err)
+			os.Exit(1)
 		}
 	}
 
@@ -506,12 +581,69 @@ func (cfg *MainConfig) LaxParseCSV(csvr *csv.Reader) (messages []SMSMessage, war
 			continue
 		}
 
+		message.Key = MessageKey(message.Number, message.Text)
 		messages = append(messages, message)
 	}
 
 	return messages, warns, nil
 }
 
+// resumeCampaign removes the messages that have already been sent (and those
+// that have failed too many times, or may have been sent), but only with --resume
+func (cfg *MainConfig) resumeCampaign(campaign *Campaign, messages []SMSMessage) ([]SMSMessage, error) {
+	var remaining []SMSMessage
+	var sent, retries, exhausted, uncertain int
+	seen := make(map[string]bool)
+	for _, message := range messages {
+		if seen[message.Key] {
+			if cfg.verbose {
+				fmt.Fprintf(os.Stderr, "   Skip: duplicate message to %s (%s)\n", message.Number, message.Name)
+			}
+			continue
+		}
+		seen[message.Key] = true
+
+		last, ok := campaign.Last[message.Key]
+		switch {
+		case !ok:
+			remaining = append(remaining, message)
+		case last.Status == AttemptSent:
+			sent += 1
+		case last.Status == AttemptSending && !cfg.retryUncertain:
+			uncertain += 1
+			if cfg.verbose {
+				fmt.Fprintf(os.Stderr, "   Skip: %s (%s) may have been sent (pass --retry-uncertain to resend)\n", message.Number, message.Name)
+			}
+		case last.Attempt > cfg.maxRetries:
+			exhausted += 1
+			if cfg.verbose {
+				fmt.Fprintf(os.Stderr, "   Skip: %s (%s) failed %d times: %s\n", message.Number, message.Name, last.Attempt, last.Error)
+			}
+		default:
+			retries += 1
+			remaining = append(remaining, message)
+		}
+	}
+
+	if !cfg.resume {
+		if sent > 0 || retries > 0 || exhausted > 0 || uncertain > 0 {
+			return nil, fmt.Errorf(
+				"%q shows that %d of these messages were already sent (and %d failed)\n"+
+					"       pass --resume to skip them, or remove the file to start over",
+				campaign.Path, sent+uncertain, retries+exhausted,
+			)
+		}
+		return remaining, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Info: resuming from %q: %d already sent, %d to retry, %d failed too many times\n", campaign.Path, sent, retries, exhausted)
+	if uncertain > 0 {
+		fmt.Fprintf(os.Stderr, "%sWarning%s: skipping %d messages that were being sent when the last run stopped, which may or may not have gone out\n"+
+			"       check the gateway, then pass --retry-uncertain to resend them\n", textWarn, textReset, uncertain)
+	}
+	return remaining, nil
+}
+
 func replaceVar(text, key, val string) string {
 	if val != "" {
 		// No special treatment:
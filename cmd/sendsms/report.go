@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Deliveries are the sms:delivered webhooks, by message ID and by phone number
+type Deliveries struct {
+	MessageIDs map[string]bool
+	Numbers    map[string]bool
+}
+
+// Delivered matches by message ID, or by number if the export has no message IDs
+func (d *Deliveries) Delivered(a Attempt) bool {
+	if d == nil {
+		return false
+	}
+	if len(d.MessageIDs) > 0 {
+		return a.MessageID != "" && d.MessageIDs[a.MessageID]
+	}
+	return d.Numbers[a.Number]
+}
+
+func (d *Deliveries) add(event, messageID, number string) {
+	if event != "" && event != "sms:delivered" {
+		return
+	}
+	if messageID != "" {
+		d.MessageIDs[messageID] = true
+	}
+	if number = cleanPhoneNumber(number); number != "" {
+		d.Numbers[number] = true
+	}
+}
+
+// ReadDeliveries reads webhooks saved by smsapid (messages.jsonl), or exported
+// as CSV or TSV (such as by smsgwhookstocsv or /api/smsgw/webhooks.csv), with
+// a messageId or phoneNumber column (and, optionally, an event column)
+func ReadDeliveries(r io.Reader, path string) (*Deliveries, error) {
+	d := &Deliveries{MessageIDs: map[string]bool{}, Numbers: map[string]bool{}}
+
+	br := bufio.NewReader(r)
+	// a short read is fine, this is just to see what kind of file it is
+	head, _ := br.Peek(4096)
+	if ext := filepath.Ext(path); ext == ".jsonl" || ext == ".json" || bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")) {
+		dec := json.NewDecoder(br)
+		for dec.More() {
+			var webhook struct {
+				Event   string `json:"event"`
+				Payload struct {
+					MessageID   string `json:"messageId"`
+					PhoneNumber string `json:"phoneNumber"`
+				} `json:"payload"`
+			}
+			if err := dec.Decode(&webhook); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if webhook.Event == "" {
+				continue
+			}
+			d.add(webhook.Event, webhook.Payload.MessageID, webhook.Payload.PhoneNumber)
+		}
+		return d, nil
+	}
+
+	csvr := csv.NewReader(br)
+	csvr.FieldsPerRecord = -1
+	csvr.LazyQuotes = true
+	if header, _, _ := bytes.Cut(head, []byte("\n")); bytes.Contains(header, []byte("\t")) {
+		csvr.Comma = '\t'
+	}
+
+	header, err := csvr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: header could not be parsed: %w", path, err)
+	}
+	FIELD_EVENT := GetFieldIndex(header, "event")
+	FIELD_MESSAGE_ID := GetFieldIndex(header, "messageId")
+	FIELD_PHONE := GetFieldIndex(header, "phoneNumber")
+	if FIELD_MESSAGE_ID == -1 && FIELD_PHONE == -1 {
+		return nil, fmt.Errorf("%s: header is missing 'messageId' and 'phoneNumber'", path)
+	}
+	field := func(rec []string, i int) string {
+		if i == -1 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+	for {
+		rec, err := csvr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		d.add(field(rec, FIELD_EVENT), field(rec, FIELD_MESSAGE_ID), field(rec, FIELD_PHONE))
+	}
+	return d, nil
+}
+
+// CampaignReport counts the messages of a campaign by their status
+type CampaignReport struct {
+	Delivered int
+	Sent      int
+	Failed    int
+	// Uncertain were being sent when a run stopped, so may or may not have gone out
+	Uncertain int
+	Pending   int
+	// Unlisted were sent (or tried) for rows that are no longer in the CSV
+	Unlisted int
+
+	FailedAttempts    []Attempt
+	UncertainAttempts []Attempt
+	PendingRows       []SMSMessage
+}
+
+// Report counts each message in the list (or, without one, in the state file)
+// by its last attempt. Delivered messages are also counted as sent.
+func (c *Campaign) Report(messages []SMSMessage, deliveries *Deliveries) CampaignReport {
+	var report CampaignReport
+	count := func(a Attempt) {
+		switch a.Status {
+		case AttemptSent:
+			report.Sent += 1
+			if deliveries.Delivered(a) {
+				report.Delivered += 1
+			}
+		case AttemptSending:
+			report.Uncertain += 1
+			report.UncertainAttempts = append(report.UncertainAttempts, a)
+		default:
+			report.Failed += 1
+			report.FailedAttempts = append(report.FailedAttempts, a)
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, message := range messages {
+		if listed[message.Key] {
+			continue
+		}
+		listed[message.Key] = true
+		a, ok := c.Last[message.Key]
+		if !ok {
+			report.Pending += 1
+			report.PendingRows = append(report.PendingRows, message)
+			continue
+		}
+		count(a)
+	}
+	for key, a := range c.Last {
+		if messages == nil {
+			count(a)
+		} else if !listed[key] {
+			report.Unlisted += 1
+		}
+	}
+	byTime := func(a, b Attempt) int {
+		return a.At.Compare(b.At)
+	}
+	slices.SortFunc(report.FailedAttempts, byTime)
+	slices.SortFunc(report.UncertainAttempts, byTime)
+	return report
+}
+
+func runReport(args []string) error {
+	var csvPath, statePath, deliveredPath string
+	var verbose bool
+
+	fs := flag.NewFlagSet("sendsms report", flag.ExitOnError)
+	fs.StringVar(&csvPath, "csv", "./messages.csv", "Path to the campaign's CSV, to count pending messages (optional)")
+	fs.StringVar(&statePath, "state", "", "Path to the campaign state file (Default: ./messages.state.jsonl for ./messages.csv)")
+	fs.StringVar(&deliveredPath, "delivered", "", "Path to sms:delivered webhooks, as saved by smsapid (JSONL) or exported by smsgwhookstocsv (TSV/CSV)")
+	fs.BoolVar(&verbose, "verbose", false, "List the failed, uncertain, and pending messages")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of sendsms report\n")
+		fmt.Fprintf(os.Stderr, "  Summarizes sent, failed, and pending messages of a campaign\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if statePath == "" {
+		statePath = DefaultStatePath(csvPath)
+	}
+
+	campaign, err := ReadCampaign(statePath)
+	if err != nil {
+		return err
+	}
+	if campaign.Invalid > 0 {
+		fmt.Fprintf(os.Stderr, "%sWarning%s: skipped %d unreadable lines in %q\n", textWarn, textReset, campaign.Invalid, statePath)
+	}
+
+	var messages []SMSMessage
+	if file, err := os.Open(csvPath); err == nil {
+		csvr := csv.NewReader(file)
+		csvr.FieldsPerRecord = -1
+		cfg := MainConfig{}
+		messages, _, err = cfg.LaxParseCSV(csvr)
+		_ = file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", csvPath, err)
+		}
+		if messages == nil {
+			messages = []SMSMessage{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	} else {
+		fmt.Fprintf(os.Stderr, "%sWarning%s: %q not found, so pending messages aren't counted\n", textWarn, textReset, csvPath)
+	}
+
+	var deliveries *Deliveries
+	if deliveredPath != "" {
+		file, err := os.Open(deliveredPath)
+		if err != nil {
+			return err
+		}
+		deliveries, err = ReadDeliveries(file, deliveredPath)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	report := campaign.Report(messages, deliveries)
+	fmt.Printf("Campaign %s\n\n", statePath)
+	if deliveries != nil {
+		fmt.Printf("  sent       %5d  (%d delivered)\n", report.Sent, report.Delivered)
+	} else {
+		fmt.Printf("  sent       %5d\n", report.Sent)
+	}
+	fmt.Printf("  failed     %5d\n", report.Failed)
+	if report.Uncertain > 0 {
+		fmt.Printf("  uncertain  %5d\n", report.Uncertain)
+	}
+	if messages != nil {
+		fmt.Printf("  pending    %5d\n", report.Pending)
+		if report.Unlisted > 0 {
+			fmt.Printf("  (and %d no longer in %s)\n", report.Unlisted, csvPath)
+		}
+	}
+
+	if verbose {
+		if len(report.FailedAttempts) > 0 {
+			fmt.Printf("\nFailed:\n")
+			for _, a := range report.FailedAttempts {
+				fmt.Printf("  %s (%s) after %d attempts: %s\n", a.Number, a.Name, a.Attempt, a.Error)
+			}
+		}
+		if len(report.UncertainAttempts) > 0 {
+			fmt.Printf("\nUncertain (being sent when a run stopped):\n")
+			for _, a := range report.UncertainAttempts {
+				fmt.Printf("  %s (%s) on attempt %d\n", a.Number, a.Name, a.Attempt)
+			}
+		}
+		if len(report.PendingRows) > 0 {
+			fmt.Printf("\nPending:\n")
+			for _, message := range report.PendingRows {
+				fmt.Printf("  %s (%s)\n", message.Number, message.Name)
+			}
+		}
+	}
+	return nil
+}
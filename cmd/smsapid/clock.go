@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidClockFormat = fmt.Errorf("invalid clock time, ex: '06:00 PM', '6pm', or '18:00' (space and case insensitive)")
+var ErrInvalidClockTime = fmt.Errorf("invalid hour or minute, for example '27:63 p' would not be valid")
+
+// ClockWindow is the time of day (in Location) that messages may be sent,
+// such as 10am to 8:30pm. When Start equals End, any time is allowed.
+// When Start is after End, the window spans midnight.
+type ClockWindow struct {
+	// Start and End are minutes since midnight
+	Start    int
+	End      int
+	Location *time.Location
+}
+
+// ParseClockWindow parses start and end times such as "10am" and "8:30pm", in local time
+func ParseClockWindow(start, end string) (ClockWindow, error) {
+	ref := time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local)
+	startTime, err := parseClock(start, ref)
+	if err != nil {
+		return ClockWindow{}, fmt.Errorf("start %q: %w", start, err)
+	}
+	endTime, err := parseClock(end, ref)
+	if err != nil {
+		return ClockWindow{}, fmt.Errorf("end %q: %w", end, err)
+	}
+	return ClockWindow{
+		Start:    startTime.Hour()*60 + startTime.Minute(),
+		End:      endTime.Hour()*60 + endTime.Minute(),
+		Location: time.Local,
+	}, nil
+}
+
+// Contains reports whether t is within the window
+func (cw ClockWindow) Contains(t time.Time) bool {
+	if cw.Start == cw.End {
+		return true
+	}
+	if cw.Location != nil {
+		t = t.In(cw.Location)
+	}
+	m := t.Hour()*60 + t.Minute()
+	if cw.Start < cw.End {
+		return m >= cw.Start && m < cw.End
+	}
+	return m >= cw.Start || m < cw.End
+}
+
+// Next returns t if it's within the window, or else the next time the window opens
+func (cw ClockWindow) Next(t time.Time) time.Time {
+	if cw.Contains(t) {
+		return t
+	}
+	if cw.Location != nil {
+		t = t.In(cw.Location)
+	}
+	open := time.Date(t.Year(), t.Month(), t.Day(), cw.Start/60, cw.Start%60, 0, 0, t.Location())
+	if !open.After(t) {
+		open = time.Date(t.Year(), t.Month(), t.Day()+1, cw.Start/60, cw.Start%60, 0, 0, t.Location())
+	}
+	return open
+}
+
+func (cw ClockWindow) String() string {
+	if cw.Start == cw.End {
+		return "any time"
+	}
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", cw.Start/60, cw.Start%60, cw.End/60, cw.End%60)
+}
+
+// parseClock parses "10am", "10:00", "22:30", etc. into today's date + that time
+func parseClock(s string, ref time.Time) (t time.Time, err error) {
+	// "10:05 AM" => "10:05am"
+	// "10 AM" => "10am"
+	// "23:05" => "23:05"
+	// "00" => "00"
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, " ", "")
+
+	var hour, min int
+	var ampm string
+
+	if strings.HasSuffix(s, "am") {
+		ampm = "am"
+		s = strings.TrimSuffix(s, "am")
+	} else if strings.HasSuffix(s, "pm") {
+		ampm = "pm"
+		s = strings.TrimSuffix(s, "pm")
+	}
+
+	parts := strings.Split(s, ":")
+	switch len(parts) {
+	case 2:
+		minStr := parts[1]
+		minStr = strings.TrimLeft(minStr, "0")
+		if len(minStr) > 0 {
+			min, err = strconv.Atoi(minStr)
+			if err != nil {
+				return t, ErrInvalidClockFormat
+			}
+		}
+		fallthrough
+	case 1:
+		hourStr := parts[0]
+		hourStr = strings.TrimLeft(hourStr, "0")
+		if len(hourStr) > 0 {
+			hour, err = strconv.Atoi(hourStr)
+			if err != nil {
+				return t, ErrInvalidClockFormat
+			}
+		}
+	default:
+		return t, ErrInvalidClockFormat
+	}
+
+	if hour < 0 || hour > 23 || min < 0 || min > 59 {
+		return t, ErrInvalidClockTime
+	}
+
+	switch ampm {
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	}
+
+	t = time.Date(ref.Year(), ref.Month(), ref.Day(), hour, min, 0, 0, ref.Location())
+	return t, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/http/sse"
+)
+
+var (
+	// replayPageSize is how many missed events are read from the store at a time
+	replayPageSize = 500
+	// eventsBroker pushes each saved webhook to GET /api/smsgw/events
+	eventsBroker = sse.NewBroker()
+	// eventsHeartbeat is how often an idle stream gets a comment, to keep proxies from closing it
+	eventsHeartbeat = sse.DefaultHeartbeat
+)
+
+// newSSEEvent encodes a webhook (or ping) as it was received
+func newSSEEvent(id, event string, webhook any) (sse.Event, error) {
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		return sse.Event{}, fmt.Errorf("failed to encode %s %s: %w", event, id, err)
+	}
+	return sse.Event{ID: id, Event: event, Data: data}, nil
+}
+
+// publishEvent sends a saved webhook (or ping) to the event stream subscribers
+func publishEvent(id, event string, webhook any) {
+	e, err := newSSEEvent(id, event, webhook)
+	if err != nil {
+		log.Printf("events: %v", err)
+		return
+	}
+	eventsBroker.Publish(e)
+}
+
+// handlerEvents streams webhooks as they're saved, as Server-Sent Events,
+// with the webhook's ID as the event ID and its event type as the event name.
+//
+// With Last-Event-ID (or ?lastEventId=), the webhooks saved since that ID are
+// sent first (or none, if it's unknown). Pings are only sent live, without an
+// ID, so that they don't change the client's Last-Event-ID.
+// ?event=sms:received,sms:delivered (or repeated) limits the event types.
+func handlerEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	for _, v := range r.URL.Query()["event"] {
+		for eventType := range strings.SplitSeq(v, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				types = append(types, eventType)
+			}
+		}
+	}
+
+	// subscribe before replaying, so that nothing is missed in between
+	live := eventsBroker.Subscribe()
+	defer eventsBroker.Unsubscribe(live)
+
+	stream, err := sse.NewStream(w)
+	if err != nil {
+		log.Printf("events: %v", err)
+		return
+	}
+
+	// replayed is what's already been sent, which may come around again on live
+	var replayed map[string]bool
+	if lastID := sse.LastEventID(r); lastID != "" {
+		replayed = map[string]bool{}
+		q := EventQuery{Events: types, AfterID: lastID, Limit: replayPageSize}
+		for {
+			page, err := store.Events(r.Context(), q)
+			if errors.Is(err, ErrEventNotFound) {
+				// not ours (or from before the store was reset), so there's no telling what was missed
+				log.Printf("events: not replaying after unknown Last-Event-ID %q", lastID)
+				break
+			}
+			if err != nil {
+				log.Printf("events: failed to replay after %q: %v", lastID, err)
+				_ = stream.Comment("failed to replay missed events")
+				return
+			}
+			for _, stored := range page {
+				f := getEventFields(stored.Event)
+				e, err := newSSEEvent(f.ID, f.Event, stored.Event)
+				if err != nil {
+					log.Printf("events: %v", err)
+					continue
+				}
+				if err := stream.Send(e); err != nil {
+					return
+				}
+				// (events without an ID, like pings, can't be told apart)
+				if f.ID != "" {
+					replayed[f.ID] = true
+				}
+			}
+			if len(page) < q.Limit {
+				break
+			}
+			// by position, which events without an ID have too
+			q.AfterID, q.AfterSeq = "", page[len(page)-1].Seq
+		}
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if err := stream.Comment("heartbeat"); err != nil {
+				return
+			}
+		case event, ok := <-live:
+			if !ok {
+				// too far behind, the client will reconnect with its Last-Event-ID
+				return
+			}
+			if replayed != nil {
+				if replayed[event.ID] {
+					continue
+				}
+				// everything after this is new
+				replayed = nil
+			}
+			if len(types) > 0 && !slices.Contains(types, event.Event) {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+// readSSE reads the next event (or comment), without the trailing blank line
+func readSSE(t *testing.T, lines *bufio.Reader) string {
+	t.Helper()
+	var event []string
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return strings.Join(event, "\n")
+		}
+		event = append(event, line)
+	}
+}
+
+// nextEvent is the next event, skipping heartbeats
+func nextEvent(t *testing.T, lines *bufio.Reader) string {
+	t.Helper()
+	for {
+		if event := readSSE(t, lines); event != ": heartbeat" {
+			return event
+		}
+	}
+}
+
+// sseHeader is the id and event lines of an event, without its data
+func sseHeader(event string) string {
+	lines := strings.Split(event, "\n")
+	return strings.Join(lines[:len(lines)-1], " ")
+}
+
+func TestHandlerEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	if _, _, err := importJSONL(t.Context(), s, strings.NewReader(messagesJSONL), strings.NewReader(pingsJSONL)); err != nil {
+		t.Fatal(err)
+	}
+	prevStore, prevHeartbeat := store, eventsHeartbeat
+	store, eventsHeartbeat = s, 50*time.Millisecond
+	t.Cleanup(func() { store, eventsHeartbeat = prevStore, prevHeartbeat })
+
+	// closed after the streams below are (cleanups run last-in, first-out)
+	srv := httptest.NewServer(http.HandlerFunc(handlerEvents))
+	t.Cleanup(srv.Close)
+
+	connect := func(query, lastEventID string) *bufio.Reader {
+		t.Helper()
+		n := eventsBroker.Len()
+		req, _ := http.NewRequestWithContext(t.Context(), "GET", srv.URL+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = res.Body.Close() })
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type: %s", ct)
+		}
+		for eventsBroker.Len() == n {
+			time.Sleep(time.Millisecond)
+		}
+		return bufio.NewReader(res.Body)
+	}
+
+	// resumes after ev3, with only the event types asked for
+	filtered := connect("?event=sms:failed,sms:received&event=system:ping", "ev3")
+	if got := sseHeader(nextEvent(t, filtered)); got != "id: ev4 event: sms:failed" {
+		t.Errorf("replayed: %s", got)
+	}
+	all := connect("", "")
+
+	received := &androidsmsgateway.WebhookReceived{ID: "ev8", Event: "sms:received", Payload: androidsmsgateway.WebhookReceivedPayload{
+		MessageID: "m8", Message: "live", PhoneNumber: "+15550100", ReceivedAt: time.Now(),
+	}}
+	sent := &androidsmsgateway.WebhookSent{ID: "ev9", Event: "sms:sent"}
+	for _, event := range []androidsmsgateway.WebhookEvent{sent, received} {
+		req := httptest.NewRequest("POST", "/", nil)
+		if err := saveWebhook(req, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ping := &androidsmsgateway.WebhookPing{ID: "p3", Event: "system:ping"}
+	if err := savePing(httptest.NewRequest("POST", "/", nil), ping); err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, filtered)
+	if got := sseHeader(event); got != "id: ev8 event: sms:received" {
+		t.Errorf("live: %s", got)
+	}
+	if !strings.Contains(event, `"message":"live"`) {
+		t.Errorf("live data: %s", event)
+	}
+	// pings aren't saved for replay, so they don't have an SSE id
+	if got := sseHeader(nextEvent(t, filtered)); got != "event: system:ping" {
+		t.Errorf("live ping: %s", got)
+	}
+	if got := readSSE(t, filtered); got != ": heartbeat" {
+		t.Errorf("heartbeat: %s", got)
+	}
+
+	for _, want := range []string{"id: ev9 event: sms:sent", "id: ev8 event: sms:received", "event: system:ping"} {
+		if got := sseHeader(nextEvent(t, all)); got != want {
+			t.Errorf("unfiltered: got %s, want %s", got, want)
+		}
+	}
+
+	// the saved events can be resumed from
+	resumed := connect("", "ev9")
+	if got := sseHeader(nextEvent(t, resumed)); got != "id: ev8 event: sms:received" {
+		t.Errorf("resumed: %s", got)
+	}
+
+	// an unknown ID replays nothing, rather than everything,
+	// and a retry of a saved event isn't published again
+	unknown := connect("", "p3")
+	late := &androidsmsgateway.WebhookSent{ID: "ev10", Event: "sms:sent"}
+	for _, event := range []androidsmsgateway.WebhookEvent{sent, late} {
+		if err := saveWebhook(httptest.NewRequest("POST", "/", nil), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sseHeader(nextEvent(t, unknown)); got != "id: ev10 event: sms:sent" {
+		t.Errorf("unknown Last-Event-ID: %s", got)
+	}
+}
+
+func TestHandlerEventsReplayWithoutID(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	// a full page that ends with an event without an ID, and then more
+	stored := []androidsmsgateway.WebhookEvent{
+		&androidsmsgateway.WebhookSent{ID: "ev1", Event: "sms:sent"},
+		&androidsmsgateway.WebhookDelivered{Event: "sms:delivered"},
+		&androidsmsgateway.WebhookSent{ID: "ev2", Event: "sms:sent"},
+	}
+	for _, event := range stored {
+		if _, err := s.AddEvent(t.Context(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prevStore, prevPageSize := store, replayPageSize
+	store, replayPageSize = s, 1
+	t.Cleanup(func() { store, replayPageSize = prevStore, prevPageSize })
+
+	srv := httptest.NewServer(http.HandlerFunc(handlerEvents))
+	t.Cleanup(srv.Close)
+
+	n := eventsBroker.Len()
+	req, _ := http.NewRequestWithContext(t.Context(), "GET", srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "ev1")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = res.Body.Close() })
+	lines := bufio.NewReader(res.Body)
+	for eventsBroker.Len() == n {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the replay pages past it, rather than stopping or starting over from ev1
+	for _, want := range []string{"event: sms:delivered", "id: ev2 event: sms:sent"} {
+		if got := sseHeader(nextEvent(t, lines)); got != want {
+			t.Errorf("replayed: got %s, want %s", got, want)
+		}
+	}
+	// and live events without an ID aren't mistaken for replayed ones
+	ping := &androidsmsgateway.WebhookPing{Event: "system:ping"}
+	if err := savePing(httptest.NewRequest("POST", "/", nil), ping); err != nil {
+		t.Fatal(err)
+	}
+	live := &androidsmsgateway.WebhookReceived{ID: "ev3", Event: "sms:received"}
+	if err := saveWebhook(httptest.NewRequest("POST", "/", nil), live); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"event: system:ping", "id: ev3 event: sms:received"} {
+		if got := sseHeader(nextEvent(t, lines)); got != want {
+			t.Errorf("live: got %s, want %s", got, want)
+		}
+	}
+}
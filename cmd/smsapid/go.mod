@@ -1,29 +1,47 @@
 module github.com/therootcompany/golib/cmd/smsapid
 
-go 1.25.0
+go 1.26.1
 
 require (
-	github.com/go-chi/chi/v5 v5.2.5
 	github.com/jszwec/csvutil v1.10.0
 	github.com/simonfrey/jsonl v0.0.0-20240904112901-935399b9a740
 	github.com/therootcompany/golib/auth v1.1.1
 	github.com/therootcompany/golib/auth/csvauth v1.2.3
+	github.com/therootcompany/golib/auth/jwtauth v1.0.0
 	github.com/therootcompany/golib/colorjson v1.0.1
+	github.com/therootcompany/golib/database/sqlmigrate v1.0.2
+	github.com/therootcompany/golib/database/sqlmigrate/litemigrate v1.0.2
 	github.com/therootcompany/golib/http/androidsmsgateway v0.0.0-20260223054429-c8f26aca7c6d
 	github.com/therootcompany/golib/http/middleware/v2 v2.0.0
+	github.com/therootcompany/golib/http/sse v0.0.0-00010101000000-000000000000
+	modernc.org/sqlite v1.48.2
 )
 
 require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/fatih/color v1.18.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.20.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/therootcompany/golib/auth/jwt v1.0.0 // indirect
 	golang.org/x/crypto v0.42.0 // indirect
-	golang.org/x/sys v0.36.0 // indirect
+	golang.org/x/sys v0.42.0 // indirect
+	modernc.org/libc v1.70.0 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
 )
 
 replace (
 	github.com/therootcompany/golib/auth => ../../auth
 	github.com/therootcompany/golib/auth/csvauth => ../../auth/csvauth
+	github.com/therootcompany/golib/auth/jwt => ../../auth/jwt
+	github.com/therootcompany/golib/auth/jwtauth => ../../auth/jwtauth
+	github.com/therootcompany/golib/database/sqlmigrate => ../../database/sqlmigrate
+	github.com/therootcompany/golib/database/sqlmigrate/litemigrate => ../../database/sqlmigrate/litemigrate
 	github.com/therootcompany/golib/http/androidsmsgateway => ../../http/androidsmsgateway
 	github.com/therootcompany/golib/http/middleware/v2 => ../../http/middleware
+	github.com/therootcompany/golib/http/sse => ../../http/sse
 )
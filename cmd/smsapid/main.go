@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"encoding/json"
@@ -14,18 +15,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/therootcompany/golib/auth"
 	"github.com/therootcompany/golib/auth/csvauth"
+	"github.com/therootcompany/golib/auth/jwtauth"
 	"github.com/therootcompany/golib/colorjson"
 	"github.com/therootcompany/golib/http/androidsmsgateway"
 	"github.com/therootcompany/golib/http/middleware/v2"
 
-	chiware "github.com/go-chi/chi/v5/middleware"
 	"github.com/jszwec/csvutil"
-	"github.com/simonfrey/jsonl"
 )
 
 const (
@@ -58,18 +57,16 @@ var (
 var (
 	jsonf = colorjson.NewFormatter()
 
-	// webhookMux protects webhookWriter, pingWriter, webhookEvents, and pingEvents.
-	webhookMux    = sync.Mutex{}
-	webhookEvents []androidsmsgateway.WebhookEvent
-	webhookWriter jsonl.Writer
-	pingEvents    []*androidsmsgateway.WebhookPing
-	pingWriter    jsonl.Writer
+	// store holds the webhook events and pings, in JSONL files or SQLite
+	store Store
 
 	smsgwSigningKey string
 	smsRequestAuth  *auth.BasicRequestAuthenticator
-	// TODO
-	// smsgwUsername   string
-	// smsgwPassword   string
+	smsgwUsername   string
+	smsgwPassword   string
+
+	// outbox sends messages queued by POST /api/smsgw/messages (nil without --sms-gateway-url)
+	outbox *Outbox
 )
 
 type MainConfig struct {
@@ -87,8 +84,19 @@ type MainConfig struct {
 	tokenSchemeList            string
 	tokenHeaderList            string
 	tokenParamList             string
-	// TODO
-	// SMSGatewayURL              string
+	IssuerURL                  string
+	Audiences                  []string
+	RolesClaim                 string
+	audienceList               string
+	MessagesPath               string
+	PingsPath                  string
+	DBPath                     string
+	ImportJSONL                bool
+	OutboundPath               string
+	SMSGatewayURL              string
+	SendStart                  string
+	SendEnd                    string
+	SendDelay                  time.Duration
 }
 
 func (c *MainConfig) Addr() string {
@@ -109,6 +117,12 @@ func main() {
 		AuthorizationHeaderSchemes: nil, // []string{"Bearer", "Token"}
 		TokenHeaderNames:           nil, // []string{"X-API-Key", "X-Auth-Token", "X-Access-Token"},
 		QueryParamNames:            nil, // []string{"access_token", "token"},
+		MessagesPath:               "./messages.jsonl",
+		PingsPath:                  "./pings.jsonl",
+		OutboundPath:               "./outbound.jsonl",
+		SendStart:                  "10am",
+		SendEnd:                    "8:30pm",
+		SendDelay:                  20 * time.Second,
 	}
 
 	// Override defaults from env
@@ -124,6 +138,21 @@ func main() {
 	if v := os.Getenv("SMSAPID_CREDENTIALS_FILE"); v != "" {
 		cli.credsPath = v
 	}
+	if v := os.Getenv("SMSAPID_ISSUER"); v != "" {
+		cli.IssuerURL = v
+	}
+	if v := os.Getenv("SMSAPID_AUDIENCE"); v != "" {
+		cli.audienceList = v
+	}
+	if v := os.Getenv("SMSAPID_ROLES_CLAIM"); v != "" {
+		cli.RolesClaim = v
+	}
+	if v := os.Getenv("SMSAPID_DB"); v != "" {
+		cli.DBPath = v
+	}
+	if v := os.Getenv("SMS_GATEWAY_URL"); v != "" {
+		cli.SMSGatewayURL = v
+	}
 
 	// Flags
 	fs := flag.NewFlagSet(name, flag.ContinueOnError)
@@ -136,8 +165,18 @@ func main() {
 	fs.StringVar(&cli.tokenSchemeList, "token-schemes", "Bearer,Token", "checks for header 'Authorization: <Scheme> <token>'")
 	fs.StringVar(&cli.tokenHeaderList, "token-headers", "X-API-Key,X-Auth-Token,X-Access-Token", "checks for header '<API-Key-Header>: <token>'")
 	fs.StringVar(&cli.tokenParamList, "token-params", "access_token,token", "checks for query param '?<param>=<token>'")
-	// TODO
-	// fs.StringVar(&cli.SMSGatewayURL, "sms-gateway-url", "", "URL of the phone running android-sms-gateway")
+	fs.StringVar(&cli.IssuerURL, "issuer", cli.IssuerURL, "also accept bearer JWTs from this OIDC issuer (e.g. https://accounts.example.com)")
+	fs.StringVar(&cli.audienceList, "audience", cli.audienceList, "comma-separated audiences, one of which JWTs must have in 'aud' (default: any)")
+	fs.StringVar(&cli.RolesClaim, "roles-claim", cli.RolesClaim, "JWT claim to read sms:* permissions from, such as 'roles' (default: scope)")
+	fs.StringVar(&cli.MessagesPath, "messages-file", cli.MessagesPath, "path to the JSONL file of webhook events (without --db, or for --import-jsonl)")
+	fs.StringVar(&cli.PingsPath, "pings-file", cli.PingsPath, "path to the JSONL file of pings (without --db, or for --import-jsonl)")
+	fs.StringVar(&cli.DBPath, "db", cli.DBPath, "path to a SQLite database to store webhooks in, instead of the JSONL files")
+	fs.BoolVar(&cli.ImportJSONL, "import-jsonl", false, "copy the JSONL files into --db before starting (already imported events are skipped)")
+	fs.StringVar(&cli.OutboundPath, "outbound-file", cli.OutboundPath, "path to the JSONL file of queued outbound messages (without --db)")
+	fs.StringVar(&cli.SMSGatewayURL, "sms-gateway-url", cli.SMSGatewayURL, "URL of the phone running android-sms-gateway (enables POST /api/smsgw/messages)")
+	fs.StringVar(&cli.SendStart, "send-start", cli.SendStart, "don't send queued messages before this time of day (local time)")
+	fs.StringVar(&cli.SendEnd, "send-end", cli.SendEnd, "don't send queued messages after this time of day (local time)")
+	fs.DurationVar(&cli.SendDelay, "send-delay", cli.SendDelay, "minimum time between sending queued messages")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "USAGE\n  %s [flags]\n\n", name)
@@ -147,6 +186,11 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  SMSAPID_PORT              port to listen on\n")
 		fmt.Fprintf(os.Stderr, "  SMSAPID_ADDRESS           bind address\n")
 		fmt.Fprintf(os.Stderr, "  SMSAPID_CREDENTIALS_FILE  path to tokens file\n")
+		fmt.Fprintf(os.Stderr, "  SMSAPID_ISSUER            OIDC issuer URL for bearer JWTs\n")
+		fmt.Fprintf(os.Stderr, "  SMSAPID_AUDIENCE          comma-separated JWT audiences\n")
+		fmt.Fprintf(os.Stderr, "  SMSAPID_ROLES_CLAIM       JWT claim to read permissions from\n")
+		fmt.Fprintf(os.Stderr, "  SMSAPID_DB                path to a SQLite database (instead of JSONL files)\n")
+		fmt.Fprintf(os.Stderr, "  SMS_GATEWAY_URL           android-sms-gateway URL (same as --sms-gateway-url)\n")
 		fmt.Fprintf(os.Stderr, "  SMS_GATEWAY_USERNAME      android-sms-gateway basic auth username\n")
 		fmt.Fprintf(os.Stderr, "  SMS_GATEWAY_PASSWORD      android-sms-gateway basic auth password\n")
 		fmt.Fprintf(os.Stderr, "  SMS_GATEWAY_SIGNING_KEY   android-sms-gateway signing key for webhooks\n")
@@ -191,6 +235,7 @@ func main() {
 	cli.AuthorizationHeaderSchemes = ArgFields(cli.tokenSchemeList, ",", []string{"none"})
 	cli.TokenHeaderNames = ArgFields(cli.tokenHeaderList, ",", []string{"none"})
 	cli.QueryParamNames = ArgFields(cli.tokenParamList, ",", []string{"none"})
+	cli.Audiences = ArgFields(cli.audienceList, ",", []string{"none"})
 
 	// Load credentials for /api/smsgw routes.
 	var smsAuth *csvauth.Auth
@@ -213,13 +258,23 @@ func main() {
 	if err := smsAuth.LoadCSV(f, cli.credsComma); err != nil {
 		log.Fatalf("failed to load credentials from %q: %v\n", credPath, err)
 	}
-	smsRequestAuth = auth.NewBasicRequestAuthenticator(smsAuth)
+	var authenticator auth.BasicAuthenticator = smsAuth
+	if cli.IssuerURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		jwtAuth, err := jwtauth.NewFromIssuer(ctx, cli.IssuerURL, cli.Audiences, nil)
+		cancel()
+		if err != nil {
+			log.Fatalf("failed to load keys for issuer %q: %v", cli.IssuerURL, err)
+		}
+		jwtAuth.RolesClaim = cli.RolesClaim
+		authenticator = auth.BasicAuthenticators{smsAuth, jwtAuth}
+	}
+	smsRequestAuth = auth.NewBasicRequestAuthenticator(authenticator)
 
 	// Load optional webhook signing key.
 	smsgwSigningKey = os.Getenv("SMS_GATEWAY_SIGNING_KEY")
-	// TODO
-	// smsgwUsername = os.Getenv("SMS_GATEWAY_USERNAME")
-	// smsgwPassword = os.Getenv("SMS_GATEWAY_PASSWORD")
+	smsgwUsername = os.Getenv("SMS_GATEWAY_USERNAME")
+	smsgwPassword = os.Getenv("SMS_GATEWAY_PASSWORD")
 
 	// credentials file delimiter
 	cli.credsComma, err = DecodeDelimiter(cli.credsCommaString)
@@ -233,96 +288,76 @@ func main() {
 func (cli *MainConfig) run() {
 	jsonf.Indent = 3
 
-	messagesPath := "./messages.jsonl"
-	{
-		file, err := os.OpenFile(messagesPath, os.O_RDONLY|os.O_CREATE, 0644)
-		if err != nil {
-			log.Fatalf("failed to open file '%s': %v", messagesPath, err)
-		}
-		defer func() { _ = file.Close() }()
-
-		webhookEvents, err = readWebhooks(file)
-		if err != nil {
-			log.Fatalf("failed to read jsonl file '%s': %v", messagesPath, err)
+	ctx := context.Background()
+	if cli.DBPath == "" {
+		if cli.ImportJSONL {
+			log.Fatalf("--import-jsonl requires --db")
 		}
-	}
-	{
-		file, err := os.OpenFile(messagesPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		jsonlStore, err := OpenJSONLStore(cli.MessagesPath, cli.PingsPath, cli.OutboundPath)
 		if err != nil {
-			panic(fmt.Errorf("failed to open file: %v", err))
+			log.Fatal(err)
 		}
-		defer func() { _ = file.Close() }()
-
-		webhookWriter = jsonl.NewWriter(file)
-	}
-
-	pingsPath := "./pings.jsonl"
-	{
-		file, err := os.OpenFile(pingsPath, os.O_RDONLY|os.O_CREATE, 0644)
+		store = jsonlStore
+	} else {
+		sqliteStore, err := OpenSQLiteStore(ctx, cli.DBPath)
 		if err != nil {
-			log.Fatalf("failed to open file '%s': %v", pingsPath, err)
+			log.Fatal(err)
 		}
-		defer func() { _ = file.Close() }()
-
-		pingEvents, err = readPings(file)
-		if err != nil {
-			log.Fatalf("failed to read jsonl file '%s': %v", pingsPath, err)
+		store = sqliteStore
+		if cli.ImportJSONL {
+			if err := cli.importJSONL(ctx); err != nil {
+				log.Fatalf("failed to import JSONL files: %v", err)
+			}
 		}
 	}
-	{
-		file, err := os.OpenFile(pingsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	defer func() { _ = store.Close() }()
+
+	if cli.SMSGatewayURL != "" {
+		window, err := ParseClockWindow(cli.SendStart, cli.SendEnd)
 		if err != nil {
-			panic(fmt.Errorf("failed to open file: %v", err))
+			log.Fatalf("invalid --send-start or --send-end: %v", err)
 		}
-		defer func() { _ = file.Close() }()
-
-		pingWriter = jsonl.NewWriter(file)
+		gateway := androidsmsgateway.New(cli.SMSGatewayURL, smsgwUsername, smsgwPassword)
+		outbox = NewOutbox(store, gateway, window, cli.SendDelay)
+		fmt.Printf("Sending queued messages via %s (%s, every %s)\n", cli.SMSGatewayURL, window, cli.SendDelay)
+		go outbox.Run(ctx)
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /api/webhooks", handlerWebhooks)
-	mux.Handle("POST /", LogRequest(http.HandlerFunc(handler)))
-
-	// Protected routes under /api/smsgw, each guarded by its specific sms:* permission.
-	smsgw := middleware.WithMux(mux, LogRequest)
-	smsgw.With(requireSMSPermission("sms:received")).HandleFunc("GET /api/smsgw/received.csv", handlerReceived)
-	smsgw.With(requireSMSPermission("sms:received")).HandleFunc("GET /api/smsgw/received.json", handlerReceived)
-	smsgw.With(requireSMSPermission("sms:sent")).HandleFunc("GET /api/smsgw/sent.csv", handlerSent)
-	smsgw.With(requireSMSPermission("sms:sent")).HandleFunc("GET /api/smsgw/sent.json", handlerSent)
-	smsgw.With(requireSMSPermission("sms:ping")).HandleFunc("GET /api/smsgw/ping.csv", handlerPing)
-	smsgw.With(requireSMSPermission("sms:ping")).HandleFunc("GET /api/smsgw/ping.json", handlerPing)
-
+	mw := middleware.WithMux(mux)
+	mw.HandleFunc("GET /api/webhooks", handlerWebhooks)
+	webhooks := androidsmsgateway.NewWebhookHandler(smsgwSigningKey)
+	webhooks.OnPing = savePing
+	webhooks.OnEvent = saveWebhook
+	mw.With(LogRequest).Handle("POST /", webhooks)
+
+	// Protected routes under /api/smsgw, each guarded by its specific sms:* permission
+	// (or the "sms:*" wildcard).
+	smsgw := mw.Group("/api/smsgw", LogRequest)
+	received := smsgw.With(middleware.RequirePermission(smsRequestAuth, "sms:received"))
+	received.HandleFunc("GET /received.csv", handlerReceived)
+	received.HandleFunc("GET /received.json", handlerReceived)
+	sent := smsgw.With(middleware.RequirePermission(smsRequestAuth, "sms:sent"))
+	sent.HandleFunc("GET /sent.csv", handlerSent)
+	sent.HandleFunc("GET /sent.json", handlerSent)
+	ping := smsgw.With(middleware.RequirePermission(smsRequestAuth, "sms:ping"))
+	ping.HandleFunc("GET /ping.csv", handlerPing)
+	ping.HandleFunc("GET /ping.json", handlerPing)
+	events := smsgw.With(middleware.RequirePermission(smsRequestAuth, "sms:events"))
+	events.HandleFunc("GET /events", handlerEvents)
+	events.HandleFunc("GET /webhooks.csv", handlerSearch)
+	events.HandleFunc("GET /webhooks.json", handlerSearch)
+	send := smsgw.With(middleware.RequirePermission(smsRequestAuth, "sms:send"))
+	send.HandleFunc("POST /messages", handlerSendMessage)
+	send.HandleFunc("GET /messages/{id}", handlerGetMessage)
+
+	for _, route := range mw.Routes() {
+		fmt.Printf("    %s\n", route)
+	}
 	addr := cli.Addr()
 	fmt.Printf("Listening on %s...\n\n", addr)
-	log.Fatal(http.ListenAndServe(addr, chiware.Logger(chiware.Compress(5)(mux))))
-}
-
-// hasSMSPermission reports whether perms includes the wildcard "sms:*" or the specific permission.
-func hasSMSPermission(perms []string, permission string) bool {
-	for _, p := range perms {
-		if p == "sms:*" || p == permission {
-			return true
-		}
-	}
-	return false
-}
-
-// requireSMSPermission returns a middleware that authenticates the request and enforces
-// that the credential holds "sms:*" or the given specific permission.
-func requireSMSPermission(permission string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cred, err := smsRequestAuth.Authenticate(r)
-			if err != nil || !hasSMSPermission(cred.Permissions(), permission) {
-				w.Header().Set("WWW-Authenticate", smsRequestAuth.BasicRealm)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-
-	}
+	root := middleware.RequestID(middleware.AccessLog(nil)(middleware.Recover(nil)(middleware.Compress(5)(mux))))
+	log.Fatal(http.ListenAndServe(addr, root))
 }
 
 // getAESKey reads an AES-128 key (32 hex chars) from an environment variable.
@@ -354,93 +389,78 @@ func getAESKey(envname, filename string) ([]byte, error) {
 	return key, nil
 }
 
-// parseSinceLimit extracts the "since" (ISO datetime) and "limit" query parameters.
-func parseSinceLimit(r *http.Request) (time.Time, int) {
-	var since time.Time
-	if s := r.URL.Query().Get("since"); s != "" {
-		for _, format := range []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02"} {
-			if t, err := time.Parse(format, s); err == nil {
-				since = t
-				break
-			}
-		}
+// queryEventsAs returns the events of type T (which should match q.Events)
+func queryEventsAs[T androidsmsgateway.WebhookEvent](ctx context.Context, q EventQuery) ([]T, error) {
+	events, err := store.Events(ctx, q)
+	if err != nil {
+		return nil, err
 	}
-
-	limit := 10_000
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if n, err := strconv.Atoi(strings.ReplaceAll(l, "_", "")); err == nil && n > 0 {
-			limit = n
+	rows := make([]T, 0, len(events))
+	for _, event := range events {
+		if row, ok := event.Event.(T); ok {
+			rows = append(rows, row)
 		}
 	}
-
-	return since, limit
+	return rows, nil
 }
 
 func handlerReceived(w http.ResponseWriter, r *http.Request) {
-	since, limit := parseSinceLimit(r)
-
-	webhookMux.Lock()
-	rows := make([]*androidsmsgateway.WebhookReceived, 0, min(len(webhookEvents), limit))
-	for _, event := range webhookEvents {
-		recv, ok := event.(*androidsmsgateway.WebhookReceived)
-		if !ok {
-			continue
-		}
-		if !since.IsZero() && !recv.Payload.ReceivedAt.After(since) {
-			continue
-		}
-		rows = append(rows, recv)
-		if len(rows) >= limit {
-			break
-		}
+	q, err := parseEventQuery(r, 10_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.Events = []string{"sms:received"}
+
+	rows, err := queryEventsAs[*androidsmsgateway.WebhookReceived](r.Context(), q)
+	if errors.Is(err, ErrEventNotFound) {
+		writeError(w, http.StatusBadRequest, ErrInvalidCursor.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to query webhooks"}`, http.StatusInternalServerError)
+		return
 	}
-	webhookMux.Unlock()
 
 	serveCSVOrJSON(w, r, rows)
 }
 
 func handlerSent(w http.ResponseWriter, r *http.Request) {
-	since, limit := parseSinceLimit(r)
-
-	webhookMux.Lock()
-	rows := make([]*androidsmsgateway.WebhookSent, 0, min(len(webhookEvents), limit))
-	for _, event := range webhookEvents {
-		sent, ok := event.(*androidsmsgateway.WebhookSent)
-		if !ok {
-			continue
-		}
-		if !since.IsZero() && !sent.Payload.SentAt.After(since) {
-			continue
-		}
-		rows = append(rows, sent)
-		if len(rows) >= limit {
-			break
-		}
+	q, err := parseEventQuery(r, 10_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.Events = []string{"sms:sent"}
+
+	rows, err := queryEventsAs[*androidsmsgateway.WebhookSent](r.Context(), q)
+	if errors.Is(err, ErrEventNotFound) {
+		writeError(w, http.StatusBadRequest, ErrInvalidCursor.Error())
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to query webhooks"}`, http.StatusInternalServerError)
+		return
 	}
-	webhookMux.Unlock()
 
 	serveCSVOrJSON(w, r, rows)
 }
 
 func handlerPing(w http.ResponseWriter, r *http.Request) {
-	since, limit := parseSinceLimit(r)
-
-	webhookMux.Lock()
-	rows := make([]*androidsmsgateway.WebhookPing, 0, min(len(pingEvents), limit))
-	for _, ping := range pingEvents {
-		pingedAt := ping.PingedAt
-		if pingedAt.IsZero() {
-			pingedAt = time.UnixMilli(ping.XTimestamp).UTC()
-		}
-		if !since.IsZero() && !pingedAt.After(since) {
-			continue
-		}
-		rows = append(rows, ping)
-		if len(rows) >= limit {
-			break
-		}
+	q, err := parseEventQuery(r, 10_000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rows, err := store.Pings(r.Context(), q.Since, q.Limit)
+	if err != nil {
+		http.Error(w, `{"error":"failed to query pings"}`, http.StatusInternalServerError)
+		return
+	}
+	if rows == nil {
+		rows = []*androidsmsgateway.WebhookPing{}
 	}
-	webhookMux.Unlock()
 
 	serveCSVOrJSON(w, r, rows)
 }
@@ -534,7 +554,9 @@ func LogBody(next http.Handler) http.Handler {
 		fmt.Printf("   %s\n", text)
 
 		ctx := context.WithValue(r.Context(), CtxKeyBody, body)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		r = r.WithContext(ctx)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
 	})
 }
 
@@ -551,69 +573,30 @@ func handlerWebhooks(w http.ResponseWriter, r *http.Request) {
 		limit = 1000
 	}
 
-	var startIdx, endIdx int
-	if next != "" {
-		for i, event := range webhookEvents {
-			switch e := event.(type) {
-			case *androidsmsgateway.WebhookSent:
-				if e.ID == next {
-					startIdx = i + 1
-					break
-				}
-			case *androidsmsgateway.WebhookDelivered:
-				if e.ID == next {
-					startIdx = i + 1
-					break
-				}
-			case *androidsmsgateway.WebhookReceived:
-				if e.ID == next {
-					startIdx = i + 1
-					break
-				}
-			}
-		}
-	} else if previous != "" {
-		for i, event := range webhookEvents {
-			switch e := event.(type) {
-			case *androidsmsgateway.WebhookSent:
-				if e.ID == previous && i >= limit {
-					startIdx = i - limit
-					break
-				}
-			case *androidsmsgateway.WebhookDelivered:
-				if e.ID == previous && i >= limit {
-					startIdx = i - limit
-					break
-				}
-			case *androidsmsgateway.WebhookReceived:
-				if e.ID == previous && i >= limit {
-					startIdx = i - limit
-					break
-				}
-			}
-		}
-	} else {
-		if len(webhookEvents) > limit {
-			startIdx = len(webhookEvents) - limit
-		} else {
-			startIdx = 0
-		}
+	// the page after "next", the page before "previous", or the latest page
+	q := EventQuery{AfterID: next, BeforeID: previous, Limit: limit, Last: next == ""}
+	events, err := store.Events(r.Context(), q)
+	if errors.Is(err, ErrEventNotFound) {
+		http.Error(w, `{"error":"unknown next or previous webhook ID"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to query webhooks"}`, http.StatusInternalServerError)
+		return
 	}
-
-	endIdx = min(startIdx+limit, len(webhookEvents))
 
 	if _, err := w.Write([]byte("[")); err != nil {
 		http.Error(w, `{"error":"failed to write response"}`, http.StatusInternalServerError)
 		return
 	}
-	for i, event := range webhookEvents[startIdx:endIdx] {
+	for i, event := range events {
 		if i > 0 {
 			if _, err := w.Write([]byte(",")); err != nil {
 				http.Error(w, `{"error":"failed to write response"}`, http.StatusInternalServerError)
 				return
 			}
 		}
-		if err := enc.Encode(event); err != nil {
+		if err := enc.Encode(event.Event); err != nil {
 			http.Error(w, `{"error":"failed to encode webhook"}`, http.StatusInternalServerError)
 			return
 		}
@@ -624,61 +607,35 @@ func handlerWebhooks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	// this will return OK unless a retry is needed (e.g. internal error)
-
-	body, ok := r.Context().Value(CtxKeyBody).([]byte)
-	if !ok {
-		return
+// savePing is the webhook callback for system:ping
+func savePing(r *http.Request, ping *androidsmsgateway.WebhookPing) error {
+	if err := store.AddPing(r.Context(), ping); err != nil {
+		return fmt.Errorf("failed to save ping: %w", err)
 	}
+	// without an ID, because pings can't be replayed after
+	publishEvent("", ping.Event, ping)
+	return nil
+}
 
-	var webhook androidsmsgateway.Webhook
-	if err := json.Unmarshal(body, &webhook); err != nil {
-		http.Error(w, `{"error":"failed to parse webhook"}`, http.StatusOK)
-		return
-	}
-	ts, _ := strconv.Atoi(r.Header.Get("X-Timestamp"))
-	webhook.XTimestamp = int64(ts)
-	webhook.XSignature = r.Header.Get("X-Signature")
-
-	if smsgwSigningKey != "" {
-		if !androidsmsgateway.VerifySignature(smsgwSigningKey, string(body), r.Header.Get("X-Timestamp"), webhook.XSignature) {
-			http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
-			return
+// saveWebhook is the webhook callback for every other event
+// (including those that this version doesn't know, as a *Webhook)
+func saveWebhook(r *http.Request, event androidsmsgateway.WebhookEvent) error {
+	// tracked first, so that if saving the event fails, the gateway's retry is tracked too
+	if outbox != nil {
+		if err := outbox.Track(r.Context(), event); err != nil {
+			return fmt.Errorf("failed to track outbound message: %w", err)
 		}
 	}
-
-	h, err := androidsmsgateway.Decode(&webhook)
+	added, err := store.AddEvent(r.Context(), event)
 	if err != nil {
-		http.Error(w, `{"error":"failed to parse webhook as a specific event"}`, http.StatusOK)
-		return
+		return fmt.Errorf("failed to save webhook: %w", err)
 	}
-
-	switch h.GetEvent() {
-	case "system:ping":
-		ping := h.(*androidsmsgateway.WebhookPing)
-		ping.PingedAt = time.UnixMilli(webhook.XTimestamp).UTC()
-		webhookMux.Lock()
-		defer webhookMux.Unlock()
-		if err := pingWriter.Write(ping); err != nil {
-			http.Error(w, `{"error":"failed to save ping"}`, http.StatusOK)
-			return
-		}
-		pingEvents = append(pingEvents, ping)
-	case "mms:received", "sms:received", "sms:data-received", "sms:sent", "sms:delivered", "sms:failed":
-		webhookMux.Lock()
-		defer webhookMux.Unlock()
-		if err := webhookWriter.Write(h); err != nil {
-			http.Error(w, `{"error":"failed to save webhook"}`, http.StatusOK)
-			return
-		}
-		webhookEvents = append(webhookEvents, h)
-	default:
-		http.Error(w, `{"error":"unknown webhook event"}`, http.StatusOK)
-		return
+	// a retry of an event that's already saved was already published
+	if added {
+		f := getEventFields(event)
+		publishEvent(f.ID, f.Event, event)
 	}
-
-	_, _ = w.Write([]byte(`{"message": "ok"}`))
+	return nil
 }
 
 func prefixLines(text, prefix string) string {
@@ -689,67 +646,26 @@ func prefixLines(text, prefix string) string {
 	return strings.Join(lines, "\n")
 }
 
-func readWebhooks(f io.Reader) ([]androidsmsgateway.WebhookEvent, error) {
-	var webhooks []androidsmsgateway.WebhookEvent
-	r := jsonl.NewReader(f)
-	err := r.ReadLines(func(line []byte) error {
-		if len(line) == 0 {
-			return nil
-		}
-
-		var webhook androidsmsgateway.Webhook
-		if err := json.Unmarshal(line, &webhook); err != nil {
-			return fmt.Errorf("could not unmarshal into Webhook: %w", err)
-		}
-
-		switch webhook.Event {
-		case "sms:sent":
-			var sent androidsmsgateway.WebhookSent
-			if err := json.Unmarshal(line, &sent); err != nil {
-				return fmt.Errorf("could not unmarshal into WebhookSent: %w", err)
-			}
-			webhooks = append(webhooks, &sent)
-		case "sms:delivered":
-			var delivered androidsmsgateway.WebhookDelivered
-			if err := json.Unmarshal(line, &delivered); err != nil {
-				return fmt.Errorf("could not unmarshal into WebhookDelivered: %w", err)
-			}
-			webhooks = append(webhooks, &delivered)
-		case "sms:received":
-			var received androidsmsgateway.WebhookReceived
-			if err := json.Unmarshal(line, &received); err != nil {
-				return fmt.Errorf("could not unmarshal into WebhookReceived: %w", err)
-			}
-			webhooks = append(webhooks, &received)
-		default:
-			return fmt.Errorf("unknown event type: %s", webhook.Event)
-		}
-		return nil
-	})
-
+// importJSONL copies the JSONL files (if they exist) into the store
+func (cli *MainConfig) importJSONL(ctx context.Context) error {
+	messages, err := os.Open(cli.MessagesPath)
 	if err != nil {
-		return webhooks, fmt.Errorf("failed to read JSONL lines: %w", err)
+		return err
 	}
-	return webhooks, nil
-}
+	defer func() { _ = messages.Close() }()
 
-func readPings(f io.Reader) ([]*androidsmsgateway.WebhookPing, error) {
-	var pings []*androidsmsgateway.WebhookPing
-	r := jsonl.NewReader(f)
-	err := r.ReadLines(func(line []byte) error {
-		if len(line) == 0 {
-			return nil
-		}
-		var ping androidsmsgateway.WebhookPing
-		if err := json.Unmarshal(line, &ping); err != nil {
-			return fmt.Errorf("could not unmarshal into WebhookPing: %w", err)
-		}
-		pings = append(pings, &ping)
-		return nil
-	})
+	var pings io.Reader = strings.NewReader("")
+	if f, err := os.Open(cli.PingsPath); err == nil {
+		defer func() { _ = f.Close() }()
+		pings = f
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
 
+	nEvents, nPings, err := importJSONL(ctx, store, messages, pings)
 	if err != nil {
-		return pings, fmt.Errorf("failed to read JSONL lines: %w", err)
+		return err
 	}
-	return pings, nil
+	fmt.Fprintf(os.Stderr, "Imported %d new events from %s and %d pings from %s\n", nEvents, cli.MessagesPath, nPings, cli.PingsPath)
+	return nil
 }
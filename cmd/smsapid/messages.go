@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/therootcompany/golib/http/middleware/v2"
+)
+
+// reMessageID is what the gateway accepts as a message ID
+var reMessageID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,36}$`)
+
+// SendMessageRequest is the body of POST /api/smsgw/messages
+type SendMessageRequest struct {
+	// ID is optional, and makes the request idempotent
+	ID           string   `json:"id,omitempty"`
+	PhoneNumber  string   `json:"phoneNumber,omitempty"`
+	PhoneNumbers []string `json:"phoneNumbers,omitempty"`
+	Text         string   `json:"text"`
+	Priority     int      `json:"priority,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// handlerSendMessage queues a message for the outbox, and responds 202 Accepted
+// (or 200 OK with the existing message, for a repeated ID)
+func handlerSendMessage(w http.ResponseWriter, r *http.Request) {
+	if outbox == nil {
+		writeError(w, http.StatusServiceUnavailable, "sending is not enabled (see --sms-gateway-url)")
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, "'text' is required")
+		return
+	}
+	numbers := req.PhoneNumbers
+	if req.PhoneNumber != "" {
+		numbers = append([]string{req.PhoneNumber}, numbers...)
+	}
+	if len(numbers) == 0 {
+		writeError(w, http.StatusBadRequest, "'phoneNumber' or 'phoneNumbers' is required")
+		return
+	}
+	for i, number := range numbers {
+		numbers[i] = cleanPhoneNumber(number)
+		if len(numbers[i]) < 3 {
+			writeError(w, http.StatusBadRequest, "invalid phone number: "+number)
+			return
+		}
+	}
+	if req.ID != "" {
+		if !reMessageID.MatchString(req.ID) {
+			writeError(w, http.StatusBadRequest, "'id' must be 1 to 36 letters, numbers, '-', or '_'")
+			return
+		}
+	}
+
+	msg := &OutboundMessage{
+		ID:           req.ID,
+		PhoneNumbers: numbers,
+		Text:         req.Text,
+		Priority:     req.Priority,
+	}
+	if p, ok := middleware.GetPrinciple(r.Context()); ok {
+		msg.RequestedBy = p.ID()
+	}
+	msg, queued, err := outbox.Enqueue(r.Context(), msg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to queue message")
+		return
+	}
+	if !queued {
+		// already queued (or sent) with this ID
+		writeJSON(w, http.StatusOK, msg)
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path+"/"+msg.ID)
+	writeJSON(w, http.StatusAccepted, msg)
+}
+
+// handlerGetMessage responds with a queued message, and its delivery status
+func handlerGetMessage(w http.ResponseWriter, r *http.Request) {
+	msg, err := store.GetOutbound(r.Context(), r.PathValue("id"))
+	if errors.Is(err, ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load message")
+		return
+	}
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// we're not just skipping symbols,
+// we're also eliminating non-printing characters copied from HTML and such
+func cleanPhoneNumber(raw string) string {
+	var cleaned []rune
+	for i, char := range raw {
+		if (i == 0 && char == '+') || (char >= '0' && char <= '9') {
+			cleaned = append(cleaned, char)
+		}
+	}
+	return string(cleaned)
+}
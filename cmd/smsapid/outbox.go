@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// OutboundStatus is the state of a queued message
+type OutboundStatus string
+
+const (
+	// OutboundQueued is waiting for the worker (or for a retry)
+	OutboundQueued OutboundStatus = "queued"
+	// OutboundSending has been claimed by the worker, which is sending it.
+	// One left sending by a crash isn't retried, since it may have gone out,
+	// but its webhooks (if any) still move it on.
+	OutboundSending OutboundStatus = "sending"
+	// OutboundPending has been accepted by the gateway
+	OutboundPending   OutboundStatus = "pending"
+	OutboundSent      OutboundStatus = "sent"
+	OutboundDelivered OutboundStatus = "delivered"
+	OutboundFailed    OutboundStatus = "failed"
+)
+
+// OutboundMessage is a message queued through POST /api/smsgw/messages.
+// Its ID is also the gateway's message ID, which the sms:sent, sms:delivered,
+// and sms:failed webhooks refer to.
+type OutboundMessage struct {
+	ID            string                             `json:"id"`
+	PhoneNumbers  []string                           `json:"phoneNumbers"`
+	Text          string                             `json:"text"`
+	Priority      int                                `json:"priority,omitempty"`
+	Status        OutboundStatus                     `json:"status"`
+	Recipients    []androidsmsgateway.RecipientState `json:"recipients,omitempty"`
+	Error         string                             `json:"error,omitempty"`
+	Attempts      int                                `json:"attempts"`
+	RequestedBy   string                             `json:"requestedBy,omitempty"`
+	CreatedAt     time.Time                          `json:"createdAt"`
+	UpdatedAt     time.Time                          `json:"updatedAt"`
+	NextAttemptAt time.Time                          `json:"nextAttemptAt,omitzero"`
+	SentAt        time.Time                          `json:"sentAt,omitzero"`
+	DeliveredAt   time.Time                          `json:"deliveredAt,omitzero"`
+}
+
+// clone copies the message, so that a store's copy isn't shared
+func (msg *OutboundMessage) clone() *OutboundMessage {
+	c := *msg
+	c.PhoneNumbers = slices.Clone(msg.PhoneNumbers)
+	c.Recipients = slices.Clone(msg.Recipients)
+	return &c
+}
+
+// newMessageID returns a random ID (short enough for the gateway)
+func newMessageID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// MessageSender is implemented by *androidsmsgateway.AndroidSMSGateway
+type MessageSender interface {
+	SendMessage(ctx context.Context, msg androidsmsgateway.Message) (*androidsmsgateway.MessageState, error)
+}
+
+// Outbox sends queued messages one at a time, no more often than Delay,
+// and only within the Window
+type Outbox struct {
+	Store       Store
+	Sender      MessageSender
+	Window      ClockWindow
+	Delay       time.Duration
+	MaxAttempts int
+	// mu serializes updates by the worker and by webhooks
+	mu   sync.Mutex
+	wake chan struct{}
+	now  func() time.Time
+}
+
+func NewOutbox(store Store, sender MessageSender, window ClockWindow, delay time.Duration) *Outbox {
+	return &Outbox{
+		Store:       store,
+		Sender:      sender,
+		Window:      window,
+		Delay:       delay,
+		MaxAttempts: 5,
+		wake:        make(chan struct{}, 1),
+		now:         time.Now,
+	}
+}
+
+// Enqueue saves the message as queued, and wakes the worker, and reports
+// whether it did. A message with the same ID is left as it is, and returned
+// instead, so that a client can safely retry with its own ID.
+func (o *Outbox) Enqueue(ctx context.Context, msg *OutboundMessage) (*OutboundMessage, bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if msg.ID == "" {
+		msg.ID = newMessageID()
+	} else if existing, err := o.Store.GetOutbound(ctx, msg.ID); err == nil {
+		return existing, false, nil
+	} else if !errors.Is(err, ErrMessageNotFound) {
+		return nil, false, err
+	}
+
+	now := o.now()
+	msg.Status = OutboundQueued
+	msg.CreatedAt = now
+	msg.UpdatedAt = now
+	if err := o.Store.SaveOutbound(ctx, msg); err != nil {
+		return nil, false, err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return msg, true, nil
+}
+
+// Run sends queued messages until the context is canceled
+func (o *Outbox) Run(ctx context.Context) {
+	for {
+		wait := o.Delay
+		sent, err := o.sendNext(ctx)
+		if err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		if !sent {
+			wait = time.Minute
+			if next := o.Window.Next(o.now()); next.After(o.now()) {
+				wait = min(wait, next.Sub(o.now()))
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.wake:
+			// a new message doesn't skip the delay after a send
+			if sent {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// sendNext sends the oldest message that's due, if it's within the window,
+// and reports whether it tried
+func (o *Outbox) sendNext(ctx context.Context) (bool, error) {
+	msg, err := o.claimNext(ctx)
+	if msg == nil || err != nil {
+		return false, err
+	}
+
+	gwMsg := androidsmsgateway.NewTextMessage(msg.Text, msg.PhoneNumbers...)
+	gwMsg.ID = msg.ID
+	gwMsg.Priority = msg.Priority
+	withReport := true
+	gwMsg.WithDeliveryReport = &withReport
+
+	// without the lock, so that a slow gateway doesn't hold up Enqueue or Track
+	state, sendErr := o.Sender.SendMessage(ctx, gwMsg)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// webhooks for it may have come in while it was sending
+	msg, err = o.Store.GetOutbound(ctx, msg.ID)
+	if err != nil {
+		return true, err
+	}
+	msg.UpdatedAt = o.now()
+	switch {
+	case sendErr == nil:
+		if msg.Status == OutboundSending {
+			msg.Status = OutboundPending
+		}
+		msg.Error = ""
+		msg.NextAttemptAt = time.Time{}
+		for _, r := range state.Recipients {
+			msg.trackRecipient(r.PhoneNumber, r.State, r.Error)
+		}
+		// the gateway may already have sent it
+		msg.advance(state.State, msg.UpdatedAt)
+	case isRetryable(sendErr) && msg.Attempts < o.MaxAttempts:
+		if msg.Status == OutboundSending {
+			msg.Status = OutboundQueued
+		}
+		msg.Error = sendErr.Error()
+		msg.NextAttemptAt = msg.UpdatedAt.Add(time.Duration(msg.Attempts) * max(o.Delay, time.Minute))
+	default:
+		if msg.Status == OutboundSending {
+			msg.Status = OutboundFailed
+		}
+		msg.Error = sendErr.Error()
+		msg.NextAttemptAt = time.Time{}
+	}
+	if err := o.Store.SaveOutbound(ctx, msg); err != nil {
+		return true, err
+	}
+	if sendErr != nil {
+		return true, fmt.Errorf("send %s (attempt %d): %w", msg.ID, msg.Attempts, sendErr)
+	}
+	return true, nil
+}
+
+// claimNext marks the oldest message that's due as sending, and returns it,
+// or nil if there's none (or it's outside of the window)
+func (o *Outbox) claimNext(ctx context.Context) (*OutboundMessage, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	if !o.Window.Contains(now) {
+		return nil, nil
+	}
+	queued, err := o.Store.QueuedOutbound(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var msg *OutboundMessage
+	for _, m := range queued {
+		if !m.NextAttemptAt.After(now) {
+			msg = m
+			break
+		}
+	}
+	if msg == nil {
+		return nil, nil
+	}
+
+	msg.Status = OutboundSending
+	msg.Attempts += 1
+	msg.UpdatedAt = now
+	if err := o.Store.SaveOutbound(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// isRetryable is true for a 429 or 5xx from the gateway, or for a network error
+func isRetryable(err error) bool {
+	var apiErr *androidsmsgateway.APIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(err, androidsmsgateway.ErrRetryable)
+	}
+	return !errors.Is(err, androidsmsgateway.ErrInvalidMessage) &&
+		!errors.Is(err, androidsmsgateway.ErrInvalidNumber) &&
+		!errors.Is(err, androidsmsgateway.ErrNoRecipients)
+}
+
+// Track updates the queued message that a sms:sent, sms:delivered, or sms:failed
+// webhook refers to (other events, and messages not sent by the outbox, are ignored)
+func (o *Outbox) Track(ctx context.Context, event androidsmsgateway.WebhookEvent) error {
+	var messageID, phoneNumber, reason string
+	var state androidsmsgateway.ProcessState
+	var at time.Time
+	switch e := event.(type) {
+	case *androidsmsgateway.WebhookSent:
+		messageID, phoneNumber, state, at = e.Payload.MessageID, e.Payload.PhoneNumber, androidsmsgateway.StateSent, e.Payload.SentAt
+	case *androidsmsgateway.WebhookDelivered:
+		messageID, phoneNumber, state, at = e.Payload.MessageID, e.Payload.PhoneNumber, androidsmsgateway.StateDelivered, e.Payload.DeliveredAt
+	case *androidsmsgateway.WebhookFailed:
+		messageID, phoneNumber, state, at = e.Payload.MessageID, e.Payload.PhoneNumber, androidsmsgateway.StateFailed, e.Payload.FailedAt
+		reason = e.Payload.Reason
+	default:
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	msg, err := o.Store.GetOutbound(ctx, messageID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	msg.trackRecipient(phoneNumber, state, reason)
+	msg.advance(msg.recipientsState(), at)
+	if reason != "" {
+		msg.Error = reason
+	}
+	msg.UpdatedAt = o.now()
+	return o.Store.SaveOutbound(ctx, msg)
+}
+
+// stateRank orders the gateway's states, so that a late webhook doesn't undo a later one
+var stateRank = map[androidsmsgateway.ProcessState]int{
+	androidsmsgateway.StatePending:   1,
+	androidsmsgateway.StateProcessed: 2,
+	androidsmsgateway.StateSent:      3,
+	androidsmsgateway.StateDelivered: 4,
+	androidsmsgateway.StateFailed:    5,
+}
+
+func (msg *OutboundMessage) trackRecipient(phoneNumber string, state androidsmsgateway.ProcessState, reason string) {
+	i := slices.IndexFunc(msg.Recipients, func(r androidsmsgateway.RecipientState) bool {
+		return r.PhoneNumber == phoneNumber
+	})
+	if i < 0 {
+		msg.Recipients = append(msg.Recipients, androidsmsgateway.RecipientState{PhoneNumber: phoneNumber})
+		i = len(msg.Recipients) - 1
+	}
+	r := &msg.Recipients[i]
+	if stateRank[state] > stateRank[r.State] {
+		r.State = state
+		r.Error = reason
+	}
+}
+
+// recipientsState is Failed if every recipient failed, Delivered once every
+// recipient is delivered (or failed), Sent once any has been sent, or else Pending
+func (msg *OutboundMessage) recipientsState() androidsmsgateway.ProcessState {
+	var sent, done, failed int
+	for _, r := range msg.Recipients {
+		switch r.State {
+		case androidsmsgateway.StateSent:
+			sent++
+		case androidsmsgateway.StateDelivered:
+			sent++
+			done++
+		case androidsmsgateway.StateFailed:
+			done++
+			failed++
+		}
+	}
+	total := max(len(msg.Recipients), len(msg.PhoneNumbers))
+	switch {
+	case failed == total:
+		return androidsmsgateway.StateFailed
+	case done == total:
+		return androidsmsgateway.StateDelivered
+	case sent > 0:
+		return androidsmsgateway.StateSent
+	}
+	return androidsmsgateway.StatePending
+}
+
+// advance moves the status forward (never back) to match the gateway's state
+func (msg *OutboundMessage) advance(state androidsmsgateway.ProcessState, at time.Time) {
+	var status OutboundStatus
+	switch state {
+	case androidsmsgateway.StateSent:
+		status = OutboundSent
+		if msg.SentAt.IsZero() {
+			msg.SentAt = at
+		}
+	case androidsmsgateway.StateDelivered:
+		status = OutboundDelivered
+		if msg.SentAt.IsZero() {
+			msg.SentAt = at
+		}
+		msg.DeliveredAt = at
+	case androidsmsgateway.StateFailed:
+		status = OutboundFailed
+	default:
+		return
+	}
+	if outboundRank[status] > outboundRank[msg.Status] {
+		msg.Status = status
+	}
+}
+
+var outboundRank = map[OutboundStatus]int{
+	OutboundQueued:    1,
+	OutboundSending:   2,
+	OutboundPending:   3,
+	OutboundSent:      4,
+	OutboundDelivered: 5,
+	OutboundFailed:    5,
+}
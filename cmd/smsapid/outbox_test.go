@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+// fakeSender records messages, and fails with its errs (in order) before succeeding
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []androidsmsgateway.Message
+	errs []error
+}
+
+func (f *fakeSender) SendMessage(ctx context.Context, msg androidsmsgateway.Message) (*androidsmsgateway.MessageState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return &androidsmsgateway.MessageState{ID: msg.ID, State: androidsmsgateway.StatePending}, nil
+}
+
+func newTestOutbox(t *testing.T, sender MessageSender, window ClockWindow) (*Outbox, *time.Time) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	o := NewOutbox(store, sender, window, 20*time.Second)
+	o.now = func() time.Time { return now }
+	return o, &now
+}
+
+func TestClockWindow(t *testing.T) {
+	window, err := ParseClockWindow("10am", "8:30pm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window.Location = time.UTC
+	if window.String() != "10:00-20:30" {
+		t.Errorf("String: %s", window)
+	}
+
+	at := func(h, m int) time.Time { return time.Date(2026, 1, 1, h, m, 0, 0, time.UTC) }
+	tests := []struct {
+		t        time.Time
+		contains bool
+		next     time.Time
+	}{
+		{at(9, 59), false, at(10, 0)},
+		{at(10, 0), true, at(10, 0)},
+		{at(20, 29), true, at(20, 29)},
+		{at(20, 30), false, at(34, 0)},
+		{at(23, 0), false, at(34, 0)},
+	}
+	for _, tt := range tests {
+		if got := window.Contains(tt.t); got != tt.contains {
+			t.Errorf("Contains(%s) = %v", tt.t, got)
+		}
+		if got := window.Next(tt.t); !got.Equal(tt.next) {
+			t.Errorf("Next(%s) = %s, want %s", tt.t, got, tt.next)
+		}
+	}
+
+	overnight := ClockWindow{Start: 22 * 60, End: 6 * 60, Location: time.UTC}
+	if !overnight.Contains(at(23, 0)) || !overnight.Contains(at(5, 0)) || overnight.Contains(at(12, 0)) {
+		t.Error("overnight window")
+	}
+	if _, err := ParseClockWindow("27:63pm", "8pm"); !errors.Is(err, ErrInvalidClockTime) {
+		t.Errorf("invalid start: %v", err)
+	}
+}
+
+func TestOutboxSendAndTrack(t *testing.T) {
+	ctx := t.Context()
+	sender := &fakeSender{}
+	o, now := newTestOutbox(t, sender, ClockWindow{Start: 10 * 60, End: 20 * 60, Location: time.UTC})
+
+	msg := &OutboundMessage{PhoneNumbers: []string{"+15550100", "+15550101"}, Text: "hello"}
+	if _, _, err := o.Enqueue(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID == "" || msg.Status != OutboundQueued {
+		t.Fatalf("enqueued: %+v", msg)
+	}
+
+	// outside of the window, nothing is sent
+	*now = time.Date(2026, 1, 1, 21, 0, 0, 0, time.UTC)
+	if sent, err := o.sendNext(ctx); sent || err != nil {
+		t.Fatalf("sent outside of window: %v %v", sent, err)
+	}
+
+	*now = time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	if sent, err := o.sendNext(ctx); !sent || err != nil {
+		t.Fatalf("not sent: %v %v", sent, err)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].ID != msg.ID || sender.sent[0].TextMessage.Text != "hello" {
+		t.Fatalf("sender got: %+v", sender.sent)
+	}
+	if sent, _ := o.sendNext(ctx); sent {
+		t.Fatal("sent twice")
+	}
+
+	sentAt := time.Date(2026, 1, 2, 10, 0, 5, 0, time.UTC)
+	deliveredAt := sentAt.Add(time.Second)
+	events := []androidsmsgateway.WebhookEvent{
+		&androidsmsgateway.WebhookSent{Payload: androidsmsgateway.WebhookSentPayload{MessageID: msg.ID, PhoneNumber: "+15550100", SentAt: sentAt}},
+		&androidsmsgateway.WebhookSent{Payload: androidsmsgateway.WebhookSentPayload{MessageID: msg.ID, PhoneNumber: "+15550101", SentAt: sentAt}},
+		&androidsmsgateway.WebhookDelivered{Payload: androidsmsgateway.WebhookDeliveredPayload{MessageID: msg.ID, PhoneNumber: "+15550100", DeliveredAt: deliveredAt}},
+		// not ours
+		&androidsmsgateway.WebhookSent{Payload: androidsmsgateway.WebhookSentPayload{MessageID: "someone-else", PhoneNumber: "+15550100", SentAt: sentAt}},
+	}
+	for _, event := range events {
+		if err := o.Track(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, _ := o.Store.GetOutbound(ctx, msg.ID)
+	if got.Status != OutboundSent || !got.SentAt.Equal(sentAt) || !got.DeliveredAt.IsZero() {
+		t.Errorf("one delivered: %+v", got)
+	}
+
+	failed := &androidsmsgateway.WebhookFailed{Payload: androidsmsgateway.WebhookFailedPayload{MessageID: msg.ID, PhoneNumber: "+15550101", Reason: "no signal", FailedAt: deliveredAt}}
+	if err := o.Track(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = o.Store.GetOutbound(ctx, msg.ID)
+	if got.Status != OutboundDelivered || got.Error != "no signal" || len(got.Recipients) != 2 {
+		t.Errorf("delivered to one, failed for the other: %+v", got)
+	}
+	if got.Recipients[1].State != androidsmsgateway.StateFailed || got.Recipients[1].Error != "no signal" {
+		t.Errorf("failed recipient: %+v", got.Recipients[1])
+	}
+
+	// a late sms:sent doesn't undo the delivery
+	if err := o.Track(ctx, events[0]); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = o.Store.GetOutbound(ctx, msg.ID)
+	if got.Status != OutboundDelivered || got.Recipients[0].State != androidsmsgateway.StateDelivered {
+		t.Errorf("after a late sms:sent: %+v", got)
+	}
+
+	// a repeated ID isn't queued (or sent) again
+	again := &OutboundMessage{ID: msg.ID, PhoneNumbers: []string{"+15550100"}, Text: "hello again"}
+	existing, queued, err := o.Enqueue(ctx, again)
+	if err != nil || queued {
+		t.Fatalf("enqueued a repeated ID: %v %v", queued, err)
+	}
+	if existing.Status != OutboundDelivered || existing.Text != "hello" || !existing.CreatedAt.Equal(got.CreatedAt) {
+		t.Errorf("existing: %+v", existing)
+	}
+	if sent, _ := o.sendNext(ctx); sent {
+		t.Error("sent a delivered message again")
+	}
+}
+
+func TestSendMessageConcurrentID(t *testing.T) {
+	o, _ := newTestOutbox(t, &fakeSender{}, ClockWindow{})
+	prevOutbox, prevStore := outbox, store
+	outbox, store = o, o.Store
+	t.Cleanup(func() { outbox, store = prevOutbox, prevStore })
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for range cap(codes) {
+		wg.Go(func() {
+			body := strings.NewReader(`{"id":"same-id","phoneNumber":"+1 555 0100","text":"hello"}`)
+			w := httptest.NewRecorder()
+			handlerSendMessage(w, httptest.NewRequest("POST", "/api/smsgw/messages", body))
+			codes <- w.Code
+		})
+	}
+	wg.Wait()
+	close(codes)
+
+	var accepted, ok int
+	for code := range codes {
+		switch code {
+		case http.StatusAccepted:
+			accepted += 1
+		case http.StatusOK:
+			ok += 1
+		default:
+			t.Errorf("status %d", code)
+		}
+	}
+	if accepted != 1 || ok != cap(codes)-1 {
+		t.Errorf("%d accepted and %d existing, want 1 and %d", accepted, ok, cap(codes)-1)
+	}
+	queued, _ := o.Store.QueuedOutbound(t.Context())
+	if len(queued) != 1 {
+		t.Errorf("%d queued", len(queued))
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	ctx := t.Context()
+	unavailable := &androidsmsgateway.APIError{StatusCode: http.StatusServiceUnavailable}
+	sender := &fakeSender{errs: []error{unavailable, unavailable}}
+	o, now := newTestOutbox(t, sender, ClockWindow{})
+	o.MaxAttempts = 2
+
+	retried := &OutboundMessage{ID: "retried", PhoneNumbers: []string{"+15550100"}, Text: "hello"}
+	if _, _, err := o.Enqueue(ctx, retried); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.sendNext(ctx); !errors.Is(err, androidsmsgateway.ErrRetryable) {
+		t.Fatalf("first attempt: %v", err)
+	}
+	got, _ := o.Store.GetOutbound(ctx, "retried")
+	if got.Status != OutboundQueued || got.Attempts != 1 || !got.NextAttemptAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("after first attempt: %+v", got)
+	}
+
+	// not due yet
+	if sent, _ := o.sendNext(ctx); sent {
+		t.Fatal("retried too soon")
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := o.sendNext(ctx); err == nil {
+		t.Fatal("second attempt should fail")
+	}
+	got, _ = o.Store.GetOutbound(ctx, "retried")
+	if got.Status != OutboundFailed || got.Attempts != 2 {
+		t.Fatalf("after max attempts: %+v", got)
+	}
+
+	// a bad request isn't retried
+	sender.errs = []error{&androidsmsgateway.APIError{StatusCode: http.StatusBadRequest}}
+	rejected := &OutboundMessage{ID: "rejected", PhoneNumbers: []string{"+15550100"}, Text: "hello"}
+	if _, _, err := o.Enqueue(ctx, rejected); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = o.sendNext(ctx)
+	got, _ = o.Store.GetOutbound(ctx, "rejected")
+	if got.Status != OutboundFailed || got.Attempts != 1 {
+		t.Fatalf("rejected: %+v", got)
+	}
+}
+
+// blockingSender doesn't answer until release is closed
+type blockingSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSender) SendMessage(ctx context.Context, msg androidsmsgateway.Message) (*androidsmsgateway.MessageState, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return &androidsmsgateway.MessageState{ID: msg.ID, State: androidsmsgateway.StatePending, Recipients: []androidsmsgateway.RecipientState{
+		{PhoneNumber: "+15550100", State: androidsmsgateway.StatePending},
+	}}, nil
+}
+
+func TestOutboxSlowSend(t *testing.T) {
+	ctx := t.Context()
+	sender := &blockingSender{started: make(chan struct{}), release: make(chan struct{})}
+	o, now := newTestOutbox(t, sender, ClockWindow{})
+
+	if _, _, err := o.Enqueue(ctx, &OutboundMessage{ID: "slow", PhoneNumbers: []string{"+15550100"}, Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := o.sendNext(ctx)
+		done <- err
+	}()
+	<-sender.started
+
+	// while the gateway is slow, messages are queued and webhooks tracked
+	got, _ := o.Store.GetOutbound(ctx, "slow")
+	if got.Status != OutboundSending || got.Attempts != 1 {
+		t.Errorf("while sending: %+v", got)
+	}
+	if _, _, err := o.Enqueue(ctx, &OutboundMessage{PhoneNumbers: []string{"+15550101"}, Text: "next"}); err != nil {
+		t.Fatal(err)
+	}
+	sentAt := now.Add(time.Second)
+	sent := &androidsmsgateway.WebhookSent{Payload: androidsmsgateway.WebhookSentPayload{MessageID: "slow", PhoneNumber: "+15550100", SentAt: sentAt}}
+	if err := o.Track(ctx, sent); err != nil {
+		t.Fatal(err)
+	}
+	close(sender.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// and the gateway's late answer doesn't undo the webhook
+	got, _ = o.Store.GetOutbound(ctx, "slow")
+	if got.Status != OutboundSent || got.Recipients[0].State != androidsmsgateway.StateSent || !got.SentAt.Equal(sentAt) {
+		t.Errorf("after sending: %+v", got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// timeFormats are accepted for since and until
+var timeFormats = []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02"}
+
+// eventCursor is the position of a page of events (by StoredEvent.Seq, since
+// not every event has an ID), which clients see only as an opaque string
+type eventCursor struct {
+	After  int64 `json:"a,omitempty"`
+	Before int64 `json:"b,omitempty"`
+}
+
+func (c eventCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseCursor(s string) (eventCursor, error) {
+	var c eventCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	if c.After < 0 || c.Before < 0 || (c.After == 0) == (c.Before == 0) {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// parseEventQuery reads the filters that all of the event APIs accept:
+//
+//	?event=sms:received,sms:sent (or repeated)
+//	&phoneNumber=+15550100&deviceId=xxxx&q=hello
+//	&since=2026-01-01&until=2026-02-01T00:00:00Z
+//	&limit=100&cursor=xxxx
+func parseEventQuery(r *http.Request, defaultLimit int) (EventQuery, error) {
+	query := r.URL.Query()
+	q := EventQuery{
+		PhoneNumber: query.Get("phoneNumber"),
+		DeviceID:    query.Get("deviceId"),
+		Text:        query.Get("q"),
+		Limit:       defaultLimit,
+	}
+	for _, v := range query["event"] {
+		for eventType := range strings.SplitSeq(v, ",") {
+			if eventType = strings.TrimSpace(eventType); eventType != "" {
+				q.Events = append(q.Events, eventType)
+			}
+		}
+	}
+	if q.PhoneNumber != "" && phoneDigits(q.PhoneNumber) == "" {
+		return q, fmt.Errorf("invalid phoneNumber %q", q.PhoneNumber)
+	}
+
+	var err error
+	if q.Since, err = parseTime(query.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseTime(query.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(strings.ReplaceAll(l, "_", ""))
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit %q", l)
+		}
+		q.Limit = n
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		c, err := parseCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.AfterSeq = c.After
+		q.BeforeSeq = c.Before
+		// the page just before the cursor
+		q.Last = c.Before != 0
+	}
+	return q, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, format := range timeFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date (2006-01-02) or time (2006-01-02T15:04:05Z)", s)
+}
+
+// EventRow is a search result, with the fields that every event has
+// (and, in JSON, the whole webhook)
+type EventRow struct {
+	ID          string                         `json:"id"          csv:"id"`
+	Event       string                         `json:"event"       csv:"event"`
+	DeviceID    string                         `json:"deviceId"    csv:"deviceId"`
+	MessageID   string                         `json:"messageId"   csv:"messageId"`
+	PhoneNumber string                         `json:"phoneNumber" csv:"phoneNumber"`
+	At          time.Time                      `json:"at"          csv:"at"`
+	Text        string                         `json:"text"        csv:"text"`
+	Webhook     androidsmsgateway.WebhookEvent `json:"webhook"     csv:"-"`
+}
+
+// handlerSearch responds with a page of the events that match the query.
+// The next and previous pages (if any) are in the Link header, such as
+//
+//	Link: </api/smsgw/webhooks.json?q=hi&cursor=xxxx>; rel="next"
+func handlerSearch(w http.ResponseWriter, r *http.Request) {
+	q, err := parseEventQuery(r, 1000)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// one more than the page, to know whether there's another
+	limit := q.Limit
+	q.Limit = limit + 1
+	events, err := store.Events(r.Context(), q)
+	if errors.Is(err, ErrEventNotFound) {
+		writeError(w, http.StatusBadRequest, ErrInvalidCursor.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to query webhooks")
+		return
+	}
+	more := len(events) > limit
+	if more {
+		if q.Last {
+			events = events[1:]
+		} else {
+			events = events[:limit]
+		}
+	}
+
+	rows := make([]EventRow, 0, len(events))
+	for _, stored := range events {
+		event := stored.Event
+		f := getEventFields(event)
+		rows = append(rows, EventRow{
+			ID:          f.ID,
+			Event:       f.Event,
+			DeviceID:    f.DeviceID,
+			MessageID:   f.MessageID,
+			PhoneNumber: f.PhoneNumber,
+			At:          f.At,
+			Text:        eventText(event),
+			Webhook:     event,
+		})
+	}
+
+	// there's another page if this one was trimmed, or back across the cursor
+	var links []string
+	if len(rows) > 0 {
+		if (more && !q.Last) || q.BeforeSeq != 0 {
+			links = append(links, pageLink(r, eventCursor{After: events[len(events)-1].Seq}, "next"))
+		}
+		if (more && q.Last) || q.AfterSeq != 0 {
+			links = append(links, pageLink(r, eventCursor{Before: events[0].Seq}, "prev"))
+		}
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	serveCSVOrJSON(w, r, rows)
+}
+
+// pageLink is the same request, at another cursor
+func pageLink(r *http.Request, c eventCursor, rel string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", c.String())
+	u.RawQuery = query.Encode()
+	return fmt.Sprintf("<%s>; rel=%q", u.RequestURI(), rel)
+}
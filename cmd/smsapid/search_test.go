@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+var reLink = regexp.MustCompile(`<([^>]+)>; rel="(\w+)"`)
+
+type searchPage struct {
+	status int
+	ids    string
+	links  map[string]string
+	body   string
+}
+
+func search(t *testing.T, url string) searchPage {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handlerSearch(w, httptest.NewRequest("GET", url, nil))
+
+	page := searchPage{status: w.Code, links: map[string]string{}, body: w.Body.String()}
+	for _, m := range reLink.FindAllStringSubmatch(w.Header().Get("Link"), -1) {
+		page.links[m[2]] = m[1]
+	}
+	if w.Code != http.StatusOK || !strings.Contains(url, ".json") {
+		return page
+	}
+	var rows []struct {
+		ID      string          `json:"id"`
+		Webhook json.RawMessage `json:"webhook"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+	var ids []string
+	for _, row := range rows {
+		if !strings.Contains(string(row.Webhook), `"id":"`+row.ID+`"`) {
+			t.Errorf("%s: %s is missing its webhook: %s", url, row.ID, row.Webhook)
+		}
+		ids = append(ids, row.ID)
+	}
+	page.ids = strings.Join(ids, ",")
+	return page
+}
+
+// rels lists which of the next and prev links the page has
+func (page searchPage) rels() string {
+	var rels []string
+	for _, rel := range []string{"next", "prev"} {
+		if page.links[rel] != "" {
+			rels = append(rels, rel)
+		}
+	}
+	return strings.Join(rels, ",")
+}
+
+func testSearch(t *testing.T, s Store) {
+	if _, _, err := importJSONL(t.Context(), s, strings.NewReader(messagesJSONL), strings.NewReader(pingsJSONL)); err != nil {
+		t.Fatal(err)
+	}
+	prevStore := store
+	store = s
+	defer func() { store = prevStore }()
+
+	// forward to the end, and back to the start
+	steps := []struct {
+		rel   string
+		ids   string
+		links string
+	}{
+		{"", "ev1,ev2,ev3", "next"},
+		{"next", "ev4,ev5,ev6", "next,prev"},
+		{"next", "ev7", "prev"},
+		{"prev", "ev4,ev5,ev6", "next,prev"},
+		{"prev", "ev1,ev2,ev3", "next"},
+	}
+	url := "/api/smsgw/webhooks.json?limit=3"
+	var page searchPage
+	for i, step := range steps {
+		if step.rel != "" {
+			url = page.links[step.rel]
+		}
+		page = search(t, url)
+		if page.ids != step.ids || page.rels() != step.links {
+			t.Errorf("step %d: got %s (%s), want %s (%s)", i, page.ids, page.rels(), step.ids, step.links)
+		}
+		if !strings.Contains(url, "limit=3") {
+			t.Errorf("step %d: the link lost the query: %s", i, url)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		url   string
+		ids   string
+		links string
+	}{
+		{"exactly one page", "?limit=7", "ev1,ev2,ev3,ev4,ev5,ev6,ev7", ""},
+		{"no matches", "?q=nothing+like+it", "", ""},
+		{"filters", "?event=sms:received,sms:failed&phoneNumber=%2B1+555+0100&limit=1", "ev1", "next"},
+		{"filters after a cursor", "?event=sms:received,sms:failed&phoneNumber=15550100&limit=1&cursor=" + eventCursor{After: 1}.String(), "ev4", "prev"},
+		{"date range", "?since=2026-01-01T00:00:01Z&until=2026-01-01T00:00:04Z", "ev2,ev3", ""},
+		{"device", "?deviceId=dev1&limit=6", "ev1,ev2,ev3,ev4,ev5,ev6", "next"},
+	}
+	for _, tt := range tests {
+		page := search(t, "/api/smsgw/webhooks.json"+tt.url)
+		if page.status != http.StatusOK || page.ids != tt.ids || page.rels() != tt.links {
+			t.Errorf("%s: got %d %s (%s), want %s (%s)", tt.name, page.status, page.ids, page.rels(), tt.ids, tt.links)
+		}
+	}
+
+	// malformed, and stale (or made up) cursors are rejected, rather than starting over
+	oldCursor := base64.RawURLEncoding.EncodeToString([]byte(`{"a":"ev1"}`))
+	for _, bad := range []string{
+		"?cursor=nope", "?cursor=" + eventCursor{}.String(), "?cursor=" + eventCursor{After: -1}.String(),
+		"?cursor=" + eventCursor{After: 404}.String(), "?cursor=" + eventCursor{Before: 404}.String(), "?cursor=" + oldCursor,
+		"?limit=0", "?since=yesterday", "?phoneNumber=abc",
+	} {
+		if page := search(t, "/api/smsgw/webhooks.json"+bad); page.status != http.StatusBadRequest {
+			t.Errorf("%s: got %d", bad, page.status)
+		}
+	}
+
+	page = search(t, "/api/smsgw/webhooks.csv?event=sms:received")
+	if !strings.HasPrefix(page.body, "id,event,deviceId,messageId,phoneNumber,at,text\nev1,sms:received,dev1,m1,+15550100,") {
+		t.Errorf("csv: %s", page.body)
+	}
+
+	// events without an ID can be paged across
+	for range 2 {
+		if _, err := s.AddEvent(t.Context(), &androidsmsgateway.WebhookDelivered{Event: "sms:delivered"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page = search(t, "/api/smsgw/webhooks.json?event=sms:delivered&limit=1")
+	if page.ids != "ev3" || page.rels() != "next" {
+		t.Fatalf("first delivered: %s (%s)", page.ids, page.rels())
+	}
+	page = search(t, page.links["next"])
+	if page.status != http.StatusOK || page.ids != "" || page.rels() != "next,prev" {
+		t.Fatalf("delivered without an ID: %d %q (%s)", page.status, page.ids, page.rels())
+	}
+	page = search(t, page.links["next"])
+	if page.status != http.StatusOK || page.ids != "" || page.rels() != "prev" {
+		t.Errorf("the last delivered: %d %q (%s)", page.status, page.ids, page.rels())
+	}
+	page = search(t, page.links["prev"])
+	if page.status != http.StatusOK || page.ids != "" || page.rels() != "next,prev" {
+		t.Errorf("back: %d %q (%s)", page.status, page.ids, page.rels())
+	}
+}
+
+func TestSearchJSONL(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	testSearch(t, s)
+}
+
+func TestSearchSQLite(t *testing.T) {
+	s, err := OpenSQLiteStore(t.Context(), filepath.Join(t.TempDir(), "smsapid.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = s.Close() }()
+	testSearch(t, s)
+}
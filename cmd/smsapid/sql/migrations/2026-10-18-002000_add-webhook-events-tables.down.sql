@@ -0,0 +1,11 @@
+-- add-webhook-events-tables (down)
+DROP INDEX IF EXISTS webhook_pings_pinged_at_idx;
+DROP TABLE IF EXISTS webhook_pings;
+DROP INDEX IF EXISTS webhook_events_message_id_idx;
+DROP INDEX IF EXISTS webhook_events_phone_number_idx;
+DROP INDEX IF EXISTS webhook_events_event_idx;
+DROP INDEX IF EXISTS webhook_events_occurred_at_idx;
+DROP TABLE IF EXISTS webhook_events;
+
+-- leave this as the last line
+DELETE FROM _migrations WHERE id = 'b6d77e41';
@@ -0,0 +1,34 @@
+-- add-webhook-events-tables (up)
+-- 'data' is the event as JSON, the same as a line of messages.jsonl (or pings.jsonl),
+-- and the other columns are copied out of it for queries
+-- (times are unix milliseconds, so that they sort and compare as numbers)
+CREATE TABLE webhook_events (
+   seq INTEGER PRIMARY KEY AUTOINCREMENT,
+   id VARCHAR(64) NULL UNIQUE,
+   event VARCHAR(40) NOT NULL,
+   device_id VARCHAR(64) NOT NULL DEFAULT '',
+   message_id VARCHAR(64) NOT NULL DEFAULT '',
+   phone_number VARCHAR(32) NOT NULL DEFAULT '',
+   occurred_at INTEGER NOT NULL,
+   data TEXT NOT NULL,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_events_occurred_at_idx ON webhook_events (occurred_at);
+CREATE INDEX webhook_events_event_idx ON webhook_events (event, occurred_at);
+CREATE INDEX webhook_events_phone_number_idx ON webhook_events (phone_number, occurred_at);
+CREATE INDEX webhook_events_message_id_idx ON webhook_events (message_id);
+
+CREATE TABLE webhook_pings (
+   seq INTEGER PRIMARY KEY AUTOINCREMENT,
+   id VARCHAR(64) NULL UNIQUE,
+   device_id VARCHAR(64) NOT NULL DEFAULT '',
+   pinged_at INTEGER NOT NULL,
+   data TEXT NOT NULL,
+   created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_pings_pinged_at_idx ON webhook_pings (pinged_at);
+
+-- leave this as the last line
+INSERT INTO _migrations (name, id) VALUES ('2026-10-18-002000_add-webhook-events-tables', 'b6d77e41');
@@ -0,0 +1,6 @@
+-- add-outbound-messages-table (down)
+DROP INDEX IF EXISTS outbound_messages_status_idx;
+DROP TABLE IF EXISTS outbound_messages;
+
+-- leave this as the last line
+DELETE FROM _migrations WHERE id = 'b8fb9647';
@@ -0,0 +1,16 @@
+-- add-outbound-messages-table (up)
+-- 'data' is the message as JSON, the same as a line of outbound.jsonl,
+-- and the other columns are copied out of it for queries
+-- (times are unix milliseconds, so that they sort and compare as numbers)
+CREATE TABLE outbound_messages (
+   id VARCHAR(64) PRIMARY KEY,
+   status VARCHAR(20) NOT NULL,
+   created_at INTEGER NOT NULL,
+   updated_at INTEGER NOT NULL,
+   data TEXT NOT NULL
+);
+
+CREATE INDEX outbound_messages_status_idx ON outbound_messages (status, created_at);
+
+-- leave this as the last line
+INSERT INTO _migrations (name, id) VALUES ('2026-10-18-003000_add-outbound-messages-table', 'b8fb9647');
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+
+	"github.com/simonfrey/jsonl"
+)
+
+// ErrEventNotFound is returned for an AfterID, BeforeID, AfterSeq, or BeforeSeq
+// that isn't saved
+var ErrEventNotFound = errors.New("event not found")
+
+// Store persists the gateway's webhook events and pings, and the outbox
+type Store interface {
+	// AddEvent saves the event, and reports false (without saving it)
+	// for an event with an ID that's already saved
+	AddEvent(ctx context.Context, event androidsmsgateway.WebhookEvent) (bool, error)
+	AddPing(ctx context.Context, ping *androidsmsgateway.WebhookPing) error
+	// Events returns the events that match the query, oldest first,
+	// or ErrEventNotFound for an unknown event to page from
+	Events(ctx context.Context, q EventQuery) ([]StoredEvent, error)
+	// Pings returns the pings after since (if not zero), oldest first
+	Pings(ctx context.Context, since time.Time, limit int) ([]*androidsmsgateway.WebhookPing, error)
+	// SaveOutbound adds or replaces a queued message (by ID)
+	SaveOutbound(ctx context.Context, msg *OutboundMessage) error
+	// GetOutbound returns a copy of the queued message, or ErrMessageNotFound
+	GetOutbound(ctx context.Context, id string) (*OutboundMessage, error)
+	// QueuedOutbound returns copies of the messages with the queued status, oldest first
+	QueuedOutbound(ctx context.Context) ([]*OutboundMessage, error)
+	Close() error
+}
+
+// EventQuery selects webhook events. Zero values match everything.
+type EventQuery struct {
+	// Events are event types, such as "sms:received"
+	Events []string
+	// PhoneNumber matches by digits, so "+1 (555) 010-0100" matches "+15550100100"
+	PhoneNumber string
+	DeviceID    string
+	// Since excludes events at or before the time
+	Since time.Time
+	// Until excludes events at or after the time
+	Until time.Time
+	// Text matches messages (or MMS subjects) that contain it, ignoring case
+	Text string
+	// AfterID returns the events after the one with this ID
+	AfterID string
+	// BeforeID returns the events before the one with this ID
+	BeforeID string
+	// AfterSeq and BeforeSeq are the same, by StoredEvent.Seq, for events without an ID
+	AfterSeq  int64
+	BeforeSeq int64
+	Limit     int
+	// Last returns the last Limit matches, rather than the first
+	Last bool
+}
+
+// StoredEvent is a saved event, and its position in the store
+type StoredEvent struct {
+	// Seq increases with each event saved, starting at 1
+	Seq   int64
+	Event androidsmsgateway.WebhookEvent
+}
+
+// eventFields are the indexed fields of an event
+type eventFields struct {
+	ID          string
+	Event       string
+	DeviceID    string
+	MessageID   string
+	PhoneNumber string
+	At          time.Time
+}
+
+func getEventFields(event androidsmsgateway.WebhookEvent) eventFields {
+	switch e := event.(type) {
+	case *androidsmsgateway.WebhookReceived:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.ReceivedAt}
+	case *androidsmsgateway.WebhookDataReceived:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.ReceivedAt}
+	case *androidsmsgateway.WebhookMMSReceived:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.ReceivedAt}
+	case *androidsmsgateway.WebhookSent:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.SentAt}
+	case *androidsmsgateway.WebhookDelivered:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.DeliveredAt}
+	case *androidsmsgateway.WebhookFailed:
+		return eventFields{e.ID, e.Event, e.DeviceID, e.Payload.MessageID, e.Payload.PhoneNumber, e.Payload.FailedAt}
+	case *androidsmsgateway.WebhookPing:
+		return eventFields{e.ID, e.Event, e.DeviceID, "", "", pingedAt(e)}
+	case *androidsmsgateway.Webhook:
+		return eventFields{e.ID, e.Event, e.DeviceID, "", "", androidsmsgateway.TimestampTime(e.XTimestamp)}
+	}
+	return eventFields{Event: event.GetEvent()}
+}
+
+// eventText is the message (or MMS subject) that a text search matches
+func eventText(event androidsmsgateway.WebhookEvent) string {
+	switch e := event.(type) {
+	case *androidsmsgateway.WebhookReceived:
+		return e.Payload.Message
+	case *androidsmsgateway.WebhookMMSReceived:
+		return e.Payload.Subject
+	}
+	return ""
+}
+
+// phoneDigits is how phone numbers are compared, regardless of formatting
+// (a '+' is often lost as a space in an unescaped query string)
+func phoneDigits(number string) string {
+	return strings.TrimPrefix(cleanPhoneNumber(number), "+")
+}
+
+// pingedAt is when the ping was received, for pings saved before PingedAt was set
+func pingedAt(ping *androidsmsgateway.WebhookPing) time.Time {
+	if ping.PingedAt.IsZero() && ping.XTimestamp != 0 {
+		return androidsmsgateway.TimestampTime(ping.XTimestamp)
+	}
+	return ping.PingedAt
+}
+
+// decodeEvent parses a saved event (or a raw webhook) as its specific type,
+// or as a *Webhook for an event type that this version doesn't know
+func decodeEvent(data []byte) (androidsmsgateway.WebhookEvent, error) {
+	var webhook androidsmsgateway.Webhook
+	if err := json.Unmarshal(data, &webhook); err != nil {
+		return nil, fmt.Errorf("could not unmarshal into Webhook: %w", err)
+	}
+	event, err := androidsmsgateway.Decode(&webhook)
+	if err != nil {
+		return &webhook, nil
+	}
+	return event, nil
+}
+
+func readWebhooks(f io.Reader) ([]androidsmsgateway.WebhookEvent, error) {
+	var webhooks []androidsmsgateway.WebhookEvent
+	r := jsonl.NewReader(f)
+	err := r.ReadLines(func(line []byte) error {
+		if len(line) == 0 {
+			return nil
+		}
+		event, err := decodeEvent(line)
+		if err != nil {
+			return err
+		}
+		webhooks = append(webhooks, event)
+		return nil
+	})
+
+	if err != nil {
+		return webhooks, fmt.Errorf("failed to read JSONL lines: %w", err)
+	}
+	return webhooks, nil
+}
+
+func readPings(f io.Reader) ([]*androidsmsgateway.WebhookPing, error) {
+	var pings []*androidsmsgateway.WebhookPing
+	r := jsonl.NewReader(f)
+	err := r.ReadLines(func(line []byte) error {
+		if len(line) == 0 {
+			return nil
+		}
+		var ping androidsmsgateway.WebhookPing
+		if err := json.Unmarshal(line, &ping); err != nil {
+			return fmt.Errorf("could not unmarshal into WebhookPing: %w", err)
+		}
+		pings = append(pings, &ping)
+		return nil
+	})
+
+	if err != nil {
+		return pings, fmt.Errorf("failed to read JSONL lines: %w", err)
+	}
+	return pings, nil
+}
+
+// importJSONL copies the events and pings from JSONL files into the store,
+// and returns how many events were new, and how many pings were read
+func importJSONL(ctx context.Context, store Store, messages, pings io.Reader) (int, int, error) {
+	events, err := readWebhooks(messages)
+	if err != nil {
+		return 0, 0, err
+	}
+	var nEvents int
+	for _, event := range events {
+		added, err := store.AddEvent(ctx, event)
+		if err != nil {
+			return nEvents, 0, err
+		}
+		if added {
+			nEvents++
+		}
+	}
+
+	pingEvents, err := readPings(pings)
+	if err != nil {
+		return nEvents, 0, err
+	}
+	for _, ping := range pingEvents {
+		if err := store.AddPing(ctx, ping); err != nil {
+			return nEvents, 0, err
+		}
+	}
+	return nEvents, len(pingEvents), nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+
+	"github.com/simonfrey/jsonl"
+)
+
+// JSONLStore appends to messages.jsonl, pings.jsonl, and outbound.jsonl,
+// and keeps everything in memory for queries. Each change to a queued
+// message appends the whole message, and the last line for an ID wins.
+type JSONLStore struct {
+	mu             sync.Mutex
+	events         []androidsmsgateway.WebhookEvent
+	seqByID        map[string]int64
+	pings          []*androidsmsgateway.WebhookPing
+	outbound       []*OutboundMessage
+	outboundByID   map[string]int
+	eventsFile     *os.File
+	pingsFile      *os.File
+	outboundFile   *os.File
+	webhookWriter  jsonl.Writer
+	pingWriter     jsonl.Writer
+	outboundWriter jsonl.Writer
+}
+
+// OpenJSONLStore reads (or creates) the JSONL files, and opens them for appending
+func OpenJSONLStore(messagesPath, pingsPath, outboundPath string) (*JSONLStore, error) {
+	s := &JSONLStore{seqByID: map[string]int64{}, outboundByID: map[string]int{}}
+
+	data, err := os.OpenFile(messagesPath, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s': %w", messagesPath, err)
+	}
+	events, err := readWebhooks(data)
+	_ = data.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jsonl file '%s': %w", messagesPath, err)
+	}
+	// duplicates saved before IDs were checked are skipped, as SQLite would
+	for _, event := range events {
+		s.appendEvent(event)
+	}
+
+	data, err = os.OpenFile(pingsPath, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s': %w", pingsPath, err)
+	}
+	s.pings, err = readPings(data)
+	_ = data.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jsonl file '%s': %w", pingsPath, err)
+	}
+
+	data, err = os.OpenFile(outboundPath, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s': %w", outboundPath, err)
+	}
+	err = jsonl.NewReader(data).ReadLines(func(line []byte) error {
+		if len(line) == 0 {
+			return nil
+		}
+		var msg OutboundMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return fmt.Errorf("could not unmarshal into OutboundMessage: %w", err)
+		}
+		s.putOutbound(&msg)
+		return nil
+	})
+	_ = data.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jsonl file '%s': %w", outboundPath, err)
+	}
+
+	s.eventsFile, err = os.OpenFile(messagesPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file '%s': %w", messagesPath, err)
+	}
+	s.pingsFile, err = os.OpenFile(pingsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		_ = s.eventsFile.Close()
+		return nil, fmt.Errorf("failed to open file '%s': %w", pingsPath, err)
+	}
+	s.outboundFile, err = os.OpenFile(outboundPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		_ = s.eventsFile.Close()
+		_ = s.pingsFile.Close()
+		return nil, fmt.Errorf("failed to open file '%s': %w", outboundPath, err)
+	}
+	s.webhookWriter = jsonl.NewWriter(s.eventsFile)
+	s.pingWriter = jsonl.NewWriter(s.pingsFile)
+	s.outboundWriter = jsonl.NewWriter(s.outboundFile)
+	return s, nil
+}
+
+// AddEvent saves the event, unless an event with the same ID is already saved
+func (s *JSONLStore) AddEvent(ctx context.Context, event androidsmsgateway.WebhookEvent) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id := getEventFields(event).ID; id != "" && s.seqByID[id] != 0 {
+		return false, nil
+	}
+	if err := s.webhookWriter.Write(event); err != nil {
+		return false, err
+	}
+	s.appendEvent(event)
+	return true, nil
+}
+
+// appendEvent keeps the event in memory (unless its ID is already kept),
+// with its index + 1 as its Seq
+func (s *JSONLStore) appendEvent(event androidsmsgateway.WebhookEvent) {
+	id := getEventFields(event).ID
+	if id != "" {
+		if s.seqByID[id] != 0 {
+			return
+		}
+		s.seqByID[id] = int64(len(s.events)) + 1
+	}
+	s.events = append(s.events, event)
+}
+
+func (s *JSONLStore) AddPing(ctx context.Context, ping *androidsmsgateway.WebhookPing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.pingWriter.Write(ping); err != nil {
+		return err
+	}
+	s.pings = append(s.pings, ping)
+	return nil
+}
+
+func (s *JSONLStore) Events(ctx context.Context, q EventQuery) ([]StoredEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the events strictly between after and before
+	after, before := int64(0), int64(len(s.events))+1
+	if q.AfterID != "" {
+		seq, ok := s.seqByID[q.AfterID]
+		if !ok {
+			return nil, ErrEventNotFound
+		}
+		after = seq
+	}
+	if q.AfterSeq != 0 {
+		if !s.hasSeq(q.AfterSeq) {
+			return nil, ErrEventNotFound
+		}
+		after = max(after, q.AfterSeq)
+	}
+	if q.BeforeID != "" {
+		seq, ok := s.seqByID[q.BeforeID]
+		if !ok {
+			return nil, ErrEventNotFound
+		}
+		before = seq
+	}
+	if q.BeforeSeq != 0 {
+		if !s.hasSeq(q.BeforeSeq) {
+			return nil, ErrEventNotFound
+		}
+		before = min(before, q.BeforeSeq)
+	}
+
+	phone := phoneDigits(q.PhoneNumber)
+	text := strings.ToLower(q.Text)
+	var matches []StoredEvent
+	for seq := after + 1; seq < before; seq++ {
+		event := s.events[seq-1]
+		f := getEventFields(event)
+		if len(q.Events) > 0 && !slices.Contains(q.Events, f.Event) {
+			continue
+		}
+		if q.PhoneNumber != "" && phoneDigits(f.PhoneNumber) != phone {
+			continue
+		}
+		if q.DeviceID != "" && f.DeviceID != q.DeviceID {
+			continue
+		}
+		if !q.Since.IsZero() && !f.At.After(q.Since) {
+			continue
+		}
+		if !q.Until.IsZero() && !f.At.Before(q.Until) {
+			continue
+		}
+		if text != "" && !strings.Contains(strings.ToLower(eventText(event)), text) {
+			continue
+		}
+		matches = append(matches, StoredEvent{Seq: seq, Event: event})
+	}
+
+	if q.Limit > 0 && len(matches) > q.Limit {
+		if q.Last {
+			matches = matches[len(matches)-q.Limit:]
+		} else {
+			matches = matches[:q.Limit]
+		}
+	}
+	return matches, nil
+}
+
+// hasSeq reports whether seq is the position of a saved event
+func (s *JSONLStore) hasSeq(seq int64) bool {
+	return seq >= 1 && seq <= int64(len(s.events))
+}
+
+func (s *JSONLStore) Pings(ctx context.Context, since time.Time, limit int) ([]*androidsmsgateway.WebhookPing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rows []*androidsmsgateway.WebhookPing
+	for _, ping := range s.pings {
+		if !since.IsZero() && !pingedAt(ping).After(since) {
+			continue
+		}
+		rows = append(rows, ping)
+		if limit > 0 && len(rows) >= limit {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// putOutbound replaces the message with the same ID, or appends it
+func (s *JSONLStore) putOutbound(msg *OutboundMessage) {
+	if i, ok := s.outboundByID[msg.ID]; ok {
+		s.outbound[i] = msg
+		return
+	}
+	s.outboundByID[msg.ID] = len(s.outbound)
+	s.outbound = append(s.outbound, msg)
+}
+
+func (s *JSONLStore) SaveOutbound(ctx context.Context, msg *OutboundMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.outboundWriter.Write(msg); err != nil {
+		return err
+	}
+	s.putOutbound(msg.clone())
+	return nil
+}
+
+func (s *JSONLStore) GetOutbound(ctx context.Context, id string) (*OutboundMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.outboundByID[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return s.outbound[i].clone(), nil
+}
+
+func (s *JSONLStore) QueuedOutbound(ctx context.Context) ([]*OutboundMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var queued []*OutboundMessage
+	for _, msg := range s.outbound {
+		if msg.Status == OutboundQueued {
+			queued = append(queued, msg.clone())
+		}
+	}
+	return queued, nil
+}
+
+func (s *JSONLStore) Close() error {
+	return errors.Join(s.eventsFile.Close(), s.pingsFile.Close(), s.outboundFile.Close())
+}
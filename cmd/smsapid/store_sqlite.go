@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/therootcompany/golib/database/sqlmigrate"
+	"github.com/therootcompany/golib/database/sqlmigrate/litemigrate"
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+
+	_ "modernc.org/sqlite"
+)
+
+//go:embed sql/migrations/*.sql
+var migrations embed.FS
+
+const migrationsDir = "sql/migrations"
+
+// SQLiteStore keeps events and pings in SQLite, indexed by time,
+// event type, phone number, and ID
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+// OpenSQLiteStore opens (or creates) the database, and applies any pending migrations
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to migrate '%s': %w", path, err)
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	scripts, err := sqlmigrate.Collect(migrations, migrationsDir)
+	if err != nil {
+		return err
+	}
+	_, err = sqlmigrate.Latest(ctx, litemigrate.New(conn), scripts)
+	return err
+}
+
+// nullString stores "" as NULL, so that many rows may lack a (unique) ID
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// AddEvent saves the event, unless an event with the same ID is already saved
+func (s *SQLiteStore) AddEvent(ctx context.Context, event androidsmsgateway.WebhookEvent) (bool, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	f := getEventFields(event)
+	result, err := s.DB.ExecContext(ctx, `INSERT INTO webhook_events
+   (id, event, device_id, message_id, phone_number, occurred_at, data)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO NOTHING`,
+		nullString(f.ID), f.Event, f.DeviceID, f.MessageID, phoneDigits(f.PhoneNumber), f.At.UnixMilli(), string(data),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// AddPing saves the ping, ignoring a ping with an ID that's already saved
+func (s *SQLiteStore) AddPing(ctx context.Context, ping *androidsmsgateway.WebhookPing) error {
+	data, err := json.Marshal(ping)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `INSERT INTO webhook_pings (id, device_id, pinged_at, data)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO NOTHING`,
+		nullString(ping.ID), ping.DeviceID, pingedAt(ping).UnixMilli(), string(data),
+	)
+	return err
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *SQLiteStore) Events(ctx context.Context, q EventQuery) ([]StoredEvent, error) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(q.Events) > 0 {
+		var params []string
+		for _, event := range q.Events {
+			params = append(params, arg(event))
+		}
+		where = append(where, "event IN ("+strings.Join(params, ", ")+")")
+	}
+	if q.PhoneNumber != "" {
+		where = append(where, "phone_number = "+arg(phoneDigits(q.PhoneNumber)))
+	}
+	if q.DeviceID != "" {
+		where = append(where, "device_id = "+arg(q.DeviceID))
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "occurred_at > "+arg(q.Since.UnixMilli()))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "occurred_at < "+arg(q.Until.UnixMilli()))
+	}
+	if q.Text != "" {
+		// the same fields as eventText (LIKE ignores case, for ASCII)
+		pattern := "%" + likeEscaper.Replace(q.Text) + "%"
+		where = append(where, "event IN ('sms:received', 'mms:received')"+
+			" AND COALESCE(json_extract(data, '$.payload.message'), json_extract(data, '$.payload.subject'), '')"+
+			" LIKE "+arg(pattern)+` ESCAPE '\'`)
+	}
+	if q.AfterID != "" {
+		seq, err := s.eventSeq(ctx, "id", q.AfterID)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "seq > "+arg(seq))
+	}
+	if q.AfterSeq != 0 {
+		if _, err := s.eventSeq(ctx, "seq", q.AfterSeq); err != nil {
+			return nil, err
+		}
+		where = append(where, "seq > "+arg(q.AfterSeq))
+	}
+	if q.BeforeID != "" {
+		seq, err := s.eventSeq(ctx, "id", q.BeforeID)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "seq < "+arg(seq))
+	}
+	if q.BeforeSeq != 0 {
+		if _, err := s.eventSeq(ctx, "seq", q.BeforeSeq); err != nil {
+			return nil, err
+		}
+		where = append(where, "seq < "+arg(q.BeforeSeq))
+	}
+	order := "ASC"
+	if q.Last {
+		// the last Limit, reversed below
+		order = "DESC"
+	}
+
+	query := "SELECT seq, data FROM webhook_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY seq " + order
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var events []StoredEvent
+	for rows.Next() {
+		var seq int64
+		var data []byte
+		if err := rows.Scan(&seq, &data); err != nil {
+			return nil, err
+		}
+		event, err := decodeEvent(data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, StoredEvent{Seq: seq, Event: event})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if order == "DESC" {
+		slices.Reverse(events)
+	}
+	return events, nil
+}
+
+// eventSeq returns the seq of the event where column (id or seq) is value,
+// or ErrEventNotFound
+func (s *SQLiteStore) eventSeq(ctx context.Context, column string, value any) (int64, error) {
+	var seq int64
+	err := s.DB.QueryRowContext(ctx, "SELECT seq FROM webhook_events WHERE "+column+" = $1", value).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrEventNotFound
+	}
+	return seq, err
+}
+
+func (s *SQLiteStore) Pings(ctx context.Context, since time.Time, limit int) ([]*androidsmsgateway.WebhookPing, error) {
+	query := "SELECT data FROM webhook_pings"
+	var args []any
+	if !since.IsZero() {
+		args = append(args, since.UnixMilli())
+		query += " WHERE pinged_at > $1"
+	}
+	query += " ORDER BY seq"
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var pings []*androidsmsgateway.WebhookPing
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var ping androidsmsgateway.WebhookPing
+		if err := json.Unmarshal(data, &ping); err != nil {
+			return nil, err
+		}
+		pings = append(pings, &ping)
+	}
+	return pings, rows.Err()
+}
+
+func (s *SQLiteStore) SaveOutbound(ctx context.Context, msg *OutboundMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `INSERT INTO outbound_messages (id, status, created_at, updated_at, data)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE SET
+   status = excluded.status,
+   updated_at = excluded.updated_at,
+   data = excluded.data`,
+		msg.ID, string(msg.Status), msg.CreatedAt.UnixMilli(), msg.UpdatedAt.UnixMilli(), string(data),
+	)
+	return err
+}
+
+func (s *SQLiteStore) GetOutbound(ctx context.Context, id string) (*OutboundMessage, error) {
+	var data []byte
+	err := s.DB.QueryRowContext(ctx, "SELECT data FROM outbound_messages WHERE id = $1", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var msg OutboundMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *SQLiteStore) QueuedOutbound(ctx context.Context) ([]*OutboundMessage, error) {
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT data FROM outbound_messages WHERE status = $1 ORDER BY created_at, id",
+		string(OutboundQueued),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var queued []*OutboundMessage
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var msg OutboundMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		queued = append(queued, &msg)
+	}
+	return queued, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.DB.Close()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+// messagesJSONL has every kind of event, including one this version doesn't know
+var messagesJSONL = strings.Join([]string{
+	`{"deviceId":"dev1","event":"sms:received","id":"ev1","payload":{"messageId":"m1","message":"hi","phoneNumber":"+15550100","simNumber":1,"receivedAt":"2026-01-01T00:00:01Z"},"webhookId":"wh1"}`,
+	`{"deviceId":"dev1","event":"sms:sent","id":"ev2","payload":{"messageId":"m2","partsCount":1,"phoneNumber":"+15550101","simNumber":1,"sentAt":"2026-01-01T00:00:02Z"},"webhookId":"wh2"}`,
+	`{"deviceId":"dev1","event":"sms:delivered","id":"ev3","payload":{"messageId":"m2","phoneNumber":"+15550101","simNumber":1,"deliveredAt":"2026-01-01T00:00:03Z"},"webhookId":"wh3"}`,
+	`{"deviceId":"dev1","event":"sms:failed","id":"ev4","payload":{"messageId":"m3","reason":"no signal","phoneNumber":"+15550100","simNumber":1,"failedAt":"2026-01-01T00:00:04Z"},"webhookId":"wh4"}`,
+	`{"deviceId":"dev1","event":"mms:received","id":"ev5","payload":{"messageId":"m4","phoneNumber":"+15550100","receivedAt":"2026-01-01T00:00:05Z"},"webhookId":"wh5"}`,
+	`{"deviceId":"dev1","event":"sms:data-received","id":"ev6","payload":{"messageId":"m5","data":"AAE=","phoneNumber":"+15550102","receivedAt":"2026-01-01T00:00:06Z"},"webhookId":"wh6"}`,
+	`{"deviceId":"dev1","event":"sms:future","id":"ev7","payload":{},"webhookId":"wh7","x-timestamp":1767225607}`,
+	``,
+}, "\n")
+
+var pingsJSONL = `{"deviceId":"dev1","event":"system:ping","id":"p1","payload":{"health":{"status":"pass"}},"pingedAt":"2026-01-01T00:00:00Z"}
+{"deviceId":"dev1","event":"system:ping","id":"p2","payload":{"health":{"status":"warn"}},"x-timestamp":1767225660}
+`
+
+func eventIDs(events []StoredEvent) string {
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, getEventFields(event.Event).ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := t.Context()
+
+	nEvents, nPings, err := importJSONL(ctx, store, strings.NewReader(messagesJSONL), strings.NewReader(pingsJSONL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nEvents != 7 || nPings != 2 {
+		t.Fatalf("imported %d events and %d pings", nEvents, nPings)
+	}
+
+	since := time.Date(2026, 1, 1, 0, 0, 2, 0, time.UTC)
+	tests := []struct {
+		name string
+		q    EventQuery
+		want string
+	}{
+		{"all", EventQuery{}, "ev1,ev2,ev3,ev4,ev5,ev6,ev7"},
+		{"event type", EventQuery{Events: []string{"sms:received", "mms:received"}}, "ev1,ev5"},
+		{"unknown type", EventQuery{Events: []string{"sms:future"}}, "ev7"},
+		{"phone number", EventQuery{PhoneNumber: "+15550101"}, "ev2,ev3"},
+		{"since", EventQuery{Since: since, Limit: 3}, "ev3,ev4,ev5"},
+		{"first", EventQuery{Limit: 2}, "ev1,ev2"},
+		{"last", EventQuery{Limit: 2, Last: true}, "ev6,ev7"},
+		{"after", EventQuery{AfterID: "ev2", Limit: 2}, "ev3,ev4"},
+		{"before", EventQuery{BeforeID: "ev5", Limit: 2, Last: true}, "ev3,ev4"},
+		{"after and phone", EventQuery{AfterID: "ev1", PhoneNumber: "+15550100"}, "ev4,ev5"},
+		{"after seq", EventQuery{AfterSeq: 5}, "ev6,ev7"},
+		{"between seqs", EventQuery{AfterSeq: 1, BeforeSeq: 4}, "ev2,ev3"},
+		{"phone formatting", EventQuery{PhoneNumber: "1 (555) 010-1"}, "ev2,ev3"},
+		{"device", EventQuery{DeviceID: "dev1", Limit: 1}, "ev1"},
+		{"other device", EventQuery{DeviceID: "dev2"}, ""},
+		{"until", EventQuery{Until: time.Date(2026, 1, 1, 0, 0, 3, 0, time.UTC)}, "ev1,ev2"},
+		{"text", EventQuery{Text: "HI"}, "ev1"},
+		{"text wildcard", EventQuery{Text: "%"}, ""},
+	}
+	for _, tt := range tests {
+		events, err := store.Events(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := eventIDs(events); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	for _, q := range []EventQuery{{AfterID: "p1"}, {BeforeID: "ev404"}, {AfterSeq: 8}, {BeforeSeq: -1}} {
+		if _, err := store.Events(ctx, q); !errors.Is(err, ErrEventNotFound) {
+			t.Errorf("unknown ID %+v: %v", q, err)
+		}
+	}
+
+	events, _ := store.Events(ctx, EventQuery{Events: []string{"sms:failed"}})
+	if failed, ok := events[0].Event.(*androidsmsgateway.WebhookFailed); !ok || failed.Payload.Reason != "no signal" {
+		t.Errorf("failed event: %#v", events[0].Event)
+	}
+
+	pings, err := store.Pings(ctx, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pings) != 2 || pings[1].Payload.Health.Status != "warn" {
+		t.Fatalf("pings: %+v", pings)
+	}
+	pings, _ = store.Pings(ctx, time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC), 10)
+	if len(pings) != 1 || pings[0].ID != "p2" {
+		t.Errorf("pings since (by x-timestamp): %+v", pings)
+	}
+
+	// a duplicate ID (such as a gateway retry after a restart) is ignored,
+	// but events without an ID are all kept
+	retried := &androidsmsgateway.WebhookReceived{ID: "ev1", Event: "sms:received", Payload: androidsmsgateway.WebhookReceivedPayload{Message: "retried"}}
+	if added, err := store.AddEvent(ctx, retried); err != nil || added {
+		t.Fatalf("duplicate added: %v, %v", added, err)
+	}
+	events, _ = store.Events(ctx, EventQuery{})
+	if got := eventIDs(events); got != "ev1,ev2,ev3,ev4,ev5,ev6,ev7" {
+		t.Errorf("after a duplicate: %s", got)
+	}
+	if events, _ := store.Events(ctx, EventQuery{Text: "retried"}); len(events) != 0 {
+		t.Errorf("the duplicate replaced the original: %s", eventIDs(events))
+	}
+	for range 2 {
+		if added, err := store.AddEvent(ctx, &androidsmsgateway.WebhookDelivered{Event: "sms:delivered"}); err != nil || !added {
+			t.Fatalf("without an ID added: %v, %v", added, err)
+		}
+	}
+	events, _ = store.Events(ctx, EventQuery{})
+	if got := eventIDs(events); got != "ev1,ev2,ev3,ev4,ev5,ev6,ev7,," {
+		t.Errorf("events without an ID: %s", got)
+	}
+	// which can be paged after by position
+	if after, _ := store.Events(ctx, EventQuery{AfterSeq: events[7].Seq}); len(after) != 1 || after[0].Seq != events[8].Seq {
+		t.Errorf("after an event without an ID: %+v", after)
+	}
+
+	testOutboundStore(t, store)
+}
+
+func testOutboundStore(t *testing.T, store Store) {
+	ctx := t.Context()
+
+	if _, err := store.GetOutbound(ctx, "nope"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("missing message: %v", err)
+	}
+	created := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"out2", "out1", "out3"} {
+		msg := &OutboundMessage{
+			ID:           id,
+			PhoneNumbers: []string{"+15550100"},
+			Text:         "hello " + id,
+			Status:       OutboundQueued,
+			CreatedAt:    created.Add(time.Duration(i) * time.Second),
+		}
+		if err := store.SaveOutbound(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := store.GetOutbound(ctx, "out1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Status = OutboundSent
+	msg.Attempts = 1
+	if err := store.SaveOutbound(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _ = store.GetOutbound(ctx, "out1")
+	if msg.Status != OutboundSent || msg.Attempts != 1 || msg.Text != "hello out1" {
+		t.Errorf("updated message: %+v", msg)
+	}
+	queued, err := store.QueuedOutbound(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, msg := range queued {
+		ids = append(ids, msg.ID)
+	}
+	if got := strings.Join(ids, ","); got != "out2,out3" {
+		t.Errorf("queued: %s", got)
+	}
+}
+
+func TestJSONLStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	_ = store.Close()
+
+	// a duplicate written before IDs were checked
+	f, err := os.OpenFile(filepath.Join(dir, "messages.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := strings.Cut(messagesJSONL, "\n")
+	_, _ = f.WriteString(first + "\n")
+	_ = f.Close()
+
+	// everything written is read back, including the unknown event, but not the duplicate
+	store, err = OpenJSONLStore(filepath.Join(dir, "messages.jsonl"), filepath.Join(dir, "pings.jsonl"), filepath.Join(dir, "outbound.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	events, _ := store.Events(t.Context(), EventQuery{})
+	if got := eventIDs(events); got != "ev1,ev2,ev3,ev4,ev5,ev6,ev7,," {
+		t.Errorf("reopened: %s", got)
+	}
+	// and importing again skips what's already there
+	if nEvents, _, err := importJSONL(t.Context(), store, strings.NewReader(messagesJSONL), strings.NewReader("")); err != nil || nEvents != 0 {
+		t.Fatalf("re-imported %d events: %v", nEvents, err)
+	}
+	if events, _ := store.Events(t.Context(), EventQuery{}); len(events) != 9 {
+		t.Errorf("re-import: %d events", len(events))
+	}
+	// the last line for each outbound message wins
+	queued, _ := store.QueuedOutbound(t.Context())
+	if msg, _ := store.GetOutbound(t.Context(), "out1"); len(queued) != 2 || msg == nil || msg.Status != OutboundSent {
+		t.Errorf("reopened outbound: %d queued, out1 %+v", len(queued), msg)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smsapid.sqlite3")
+	store, err := OpenSQLiteStore(t.Context(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+	_ = store.Close()
+
+	// reopening doesn't re-run migrations, and importing again skips what's already there
+	store, err = OpenSQLiteStore(t.Context(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = store.Close() }()
+	if nEvents, _, err := importJSONL(t.Context(), store, strings.NewReader(messagesJSONL), strings.NewReader(pingsJSONL)); err != nil || nEvents != 0 {
+		t.Fatalf("re-imported %d events: %v", nEvents, err)
+	}
+	events, _ := store.Events(t.Context(), EventQuery{})
+	pings, _ := store.Pings(t.Context(), time.Time{}, 0)
+	if len(events) != 9 || len(pings) != 2 {
+		t.Errorf("re-import: %d events and %d pings", len(events), len(pings))
+	}
+}
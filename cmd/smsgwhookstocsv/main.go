@@ -43,6 +43,7 @@ type MainConfig struct {
 	out   string
 	comma string
 	in    string
+	event string
 }
 
 func main() {
@@ -52,6 +53,7 @@ func main() {
 
 	fs.StringVar(&cfg.out, "o", "-", "output file ('-' = stdout)")
 	fs.StringVar(&cfg.comma, "comma", "\t", "field separator (default: tab)")
+	fs.StringVar(&cfg.event, "event", "sms:received", "event type to convert, such as sms:delivered (or '*' for all)")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: jsonl-to-tsv [flags] [input.jsonl]\n")
@@ -67,6 +69,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  jsonl-to-tsv -o ./out.tsv messages.jsonl\n")
 		fmt.Fprintf(os.Stderr, "  jsonl-to-tsv --comma , -o ./out.csv messages.jsonl\n")
 		fmt.Fprintf(os.Stderr, "  jsonl-to-tsv -o - messages.jsonl           # explicit stdout\n")
+		fmt.Fprintf(os.Stderr, "  jsonl-to-tsv --event sms:delivered -o ./delivered.tsv messages.jsonl\n")
 	}
 
 	_ = fs.Parse(os.Args[1:])
@@ -117,7 +120,7 @@ func main() {
 			log.Printf("decode error, skipping: %v", err)
 			continue
 		}
-		if evt.Event != "sms:received" {
+		if cfg.event != "*" && evt.Event != cfg.event {
 			continue
 		}
 
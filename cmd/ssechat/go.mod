@@ -1,3 +1,7 @@
 module github.com/therootcompany/golib/cmd/ssechat
 
 go 1.25.4
+
+require github.com/therootcompany/golib/http/sse v0.0.0-00010101000000-000000000000
+
+replace github.com/therootcompany/golib/http/sse => ../../http/sse
@@ -7,6 +7,8 @@ import (
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/therootcompany/golib/http/sse"
 )
 
 //go:embed index.html
@@ -18,7 +20,7 @@ type Message struct {
 	Text string `json:"text"`
 }
 
-var sse = NewSSEChannel()
+var broker = sse.NewBroker()
 
 func main() {
 	mux := http.NewServeMux()
@@ -45,45 +47,40 @@ func main() {
 }
 
 func handleSSE(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // helps nginx / some proxies
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+	events := broker.Subscribe()
+	defer broker.Unsubscribe(events)
+
+	stream, err := sse.NewStream(w)
+	if err != nil {
+		log.Printf("sse: %v", err)
 		return
 	}
 
-	sse.Subscribe(AddrStr(r.RemoteAddr))
-	defer sse.Unsubscribe(AddrStr(r.RemoteAddr))
+	joined := fmt.Sprintf(`{"time":"%s","text":"You joined the room"}`, time.Now().Format("15:04"))
+	if err := stream.Send(sse.Event{Event: "system", Data: []byte(joined)}); err != nil {
+		return
+	}
 
-	fmt.Fprintf(w, "event: system\ndata: {\"time\":\"%s\",\"text\":\"You joined the room\"}\n\n", time.Now().Format("15:04"))
-	flusher.Flush()
+	heartbeat := time.NewTicker(sse.DefaultHeartbeat)
+	defer heartbeat.Stop()
 
 	// Forward messages to this client
 	for {
 		select {
 		case <-r.Context().Done():
 			return
-		case msg, ok := <-sse.Member(AddrStr(r.RemoteAddr)).C:
+		case <-heartbeat.C:
+			if err := stream.Comment("heartbeat"); err != nil {
+				return
+			}
+		case event, ok := <-events:
 			if !ok {
+				// too far behind
 				return
 			}
-
-			// SSE format:
-			// : comment
-			// event: optional_name
-			// data: data
-			// data: more data
-			//
-			// : extra newline to end data
-			if msg.Event != "" {
-				fmt.Fprintf(w, "event: %s\n", msg.Event)
+			if err := stream.Send(event); err != nil {
+				return
 			}
-			fmt.Fprintf(w, "%s: %s\n\n", msg.Type, msg.Data)
-			flusher.Flush()
 		}
 	}
 }
@@ -113,7 +110,11 @@ func handleSend(w http.ResponseWriter, r *http.Request) {
 	}
 	payload, _ := json.Marshal(msg)
 
-	sse.Broadcast(payload)
+	broker.Publish(sse.Event{
+		Event: "message",
+		ID:    fmt.Sprintf("%d", time.Now().UnixMilli()),
+		Data:  payload,
+	})
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -2,46 +2,170 @@ package androidsmsgateway
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidNumber  = errors.New("invalid phone number")
+	ErrNoRecipients   = errors.New("no phone numbers")
+	ErrInvalidMessage = errors.New("message must have either text or data")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrNotFound       = errors.New("not found")
+	// ErrRetryable matches an *APIError for 429 Too Many Requests or a 5xx
+	ErrRetryable = errors.New("retryable")
+)
+
+// APIError is a non-2xx response from the gateway.
+// Use errors.Is with ErrUnauthorized, ErrNotFound, or ErrRetryable.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Body
+	}
+	return fmt.Sprintf("android sms gateway: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), msg)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRetryable:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	return false
+}
+
+// ProcessState is the state of a message, or of one of its recipients
+type ProcessState string
+
+const (
+	StatePending   ProcessState = "Pending"
+	StateProcessed ProcessState = "Processed"
+	StateSent      ProcessState = "Sent"
+	StateDelivered ProcessState = "Delivered"
+	StateFailed    ProcessState = "Failed"
+)
+
+// Webhook events, for RegisterWebhook
+const (
+	EventSMSReceived     = "sms:received"
+	EventSMSDataReceived = "sms:data-received"
+	EventSMSSent         = "sms:sent"
+	EventSMSDelivered    = "sms:delivered"
+	EventSMSFailed       = "sms:failed"
+	EventMMSReceived     = "mms:received"
+	EventSystemPing      = "system:ping"
 )
 
 type AndroidSMSGateway struct {
 	baseURL  string
 	username string
 	password string
+	// HTTPClient makes the requests (with a 30s timeout by default)
+	HTTPClient *http.Client
+	// Priority is used for Send (>= 100 skips the device's limits and delays)
+	Priority int
 }
 
 func New(baseURL, username, password string) *AndroidSMSGateway {
 	return &AndroidSMSGateway{
-		baseURL:  baseURL,
-		username: username,
-		password: password,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		username:   username,
+		password:   password,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		Priority:   65,
 	}
 }
 
-type MessagePayload struct {
-	TextMessage struct {
-		Text string `json:"text"`
-	} `json:"textMessage"`
-	PhoneNumbers []string `json:"phoneNumbers"`
-	Priority     int      `json:"priority,omitempty"`
+// Message is a text or data SMS to one or more numbers
+type Message struct {
+	ID                 string       `json:"id,omitempty"`
+	TextMessage        *TextMessage `json:"textMessage,omitempty"`
+	DataMessage        *DataMessage `json:"dataMessage,omitempty"`
+	PhoneNumbers       []string     `json:"phoneNumbers"`
+	SimNumber          int          `json:"simNumber,omitempty"`
+	WithDeliveryReport *bool        `json:"withDeliveryReport,omitempty"`
+	Priority           int          `json:"priority,omitempty"`
+	// TTL is in seconds (don't set both TTL and ValidUntil)
+	TTL        int        `json:"ttl,omitempty"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+type TextMessage struct {
+	Text string `json:"text"`
+}
+
+// DataMessage is a binary SMS, sent to an application port
+type DataMessage struct {
+	Data []byte `json:"data"` // base64 in JSON
+	Port int    `json:"port"`
+}
+
+// NewTextMessage returns a text Message to the numbers
+func NewTextMessage(text string, numbers ...string) Message {
+	return Message{TextMessage: &TextMessage{Text: text}, PhoneNumbers: numbers}
+}
+
+// NewDataMessage returns a data Message to the numbers, for the port
+func NewDataMessage(data []byte, port int, numbers ...string) Message {
+	return Message{DataMessage: &DataMessage{Data: data, Port: port}, PhoneNumbers: numbers}
+}
+
+// MessageState is the state of a sent message, overall and by recipient
+type MessageState struct {
+	ID          string                     `json:"id"`
+	DeviceID    string                     `json:"deviceId"`
+	State       ProcessState               `json:"state"`
+	IsHashed    bool                       `json:"isHashed"`
+	IsEncrypted bool                       `json:"isEncrypted"`
+	Recipients  []RecipientState           `json:"recipients"`
+	States      map[ProcessState]time.Time `json:"states,omitempty"`
+}
+
+type RecipientState struct {
+	PhoneNumber string       `json:"phoneNumber"`
+	State       ProcessState `json:"state"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// WebhookRegistration is a URL that the gateway posts an event to
+type WebhookRegistration struct {
+	ID       string `json:"id,omitempty"`
+	URL      string `json:"url"`
+	Event    string `json:"event"`
+	DeviceID string `json:"deviceId,omitempty"`
+}
+
+// Device is a phone registered with the (cloud) gateway
+type Device struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	LastSeen  time.Time  `json:"lastSeen"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 func (s *AndroidSMSGateway) CurlString(number, message string) string {
 	url := s.baseURL + "/messages"
-	payload := MessagePayload{
-		TextMessage: struct {
-			Text string `json:"text"`
-		}{
-			Text: message,
-		},
-		PhoneNumbers: []string{number},
-		Priority:     65,
-	}
+	payload := NewTextMessage(message, number)
+	payload.Priority = s.Priority
 	body := bytes.NewBuffer(nil)
 	encoder := json.NewEncoder(body)
 	encoder.SetEscapeHTML(false)
@@ -54,48 +178,142 @@ func (s *AndroidSMSGateway) CurlString(number, message string) string {
 		"   --data-binary '" + escapedBody + "'"
 }
 
-func (s *AndroidSMSGateway) Send(number, message string) error {
-	number = cleanPhoneNumber(number)
-	if len(number) == 0 {
-		panic(fmt.Errorf("non-sanitized number '%s'", number))
+// Send sends a text message to one number, and returns its (usually Pending) state
+func (s *AndroidSMSGateway) Send(ctx context.Context, number, message string) (*MessageState, error) {
+	msg := NewTextMessage(message, number)
+	msg.Priority = s.Priority
+	return s.SendMessage(ctx, msg)
+}
+
+// SendMessage sends a text or data message to its numbers (which are cleaned of
+// symbols and formatting), and returns its (usually Pending) state
+func (s *AndroidSMSGateway) SendMessage(ctx context.Context, msg Message) (*MessageState, error) {
+	if (msg.TextMessage == nil) == (msg.DataMessage == nil) {
+		return nil, ErrInvalidMessage
+	}
+	if len(msg.PhoneNumbers) == 0 {
+		return nil, ErrNoRecipients
+	}
+	numbers := make([]string, len(msg.PhoneNumbers))
+	for i, raw := range msg.PhoneNumbers {
+		numbers[i] = cleanPhoneNumber(raw)
+		if len(numbers[i]) == 0 || numbers[i] == "+" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidNumber, raw)
+		}
+	}
+	msg.PhoneNumbers = numbers
+
+	var state MessageState
+	if err := s.do(ctx, "POST", "/messages", msg, &state); err != nil {
+		return nil, fmt.Errorf("send message to %s: %w", strings.Join(numbers, ", "), err)
+	}
+	return &state, nil
+}
+
+// GetMessage returns the current state of a sent message, by ID
+func (s *AndroidSMSGateway) GetMessage(ctx context.Context, id string) (*MessageState, error) {
+	var state MessageState
+	if err := s.do(ctx, "GET", "/messages/"+url.PathEscape(id), nil, &state); err != nil {
+		return nil, err
 	}
+	return &state, nil
+}
 
-	url := s.baseURL + "/messages"
-	payload := MessagePayload{
-		TextMessage: struct {
-			Text string `json:"text"`
-		}{
-			Text: message,
-		},
-		PhoneNumbers: []string{number},
-		Priority:     65,
+// Webhooks lists the registered webhooks
+func (s *AndroidSMSGateway) Webhooks(ctx context.Context) ([]WebhookRegistration, error) {
+	var webhooks []WebhookRegistration
+	if err := s.do(ctx, "GET", "/webhooks", nil, &webhooks); err != nil {
+		return nil, err
 	}
+	return webhooks, nil
+}
 
-	body := bytes.NewBuffer(nil)
-	encoder := json.NewEncoder(body)
-	encoder.SetEscapeHTML(false)
-	_ = encoder.Encode(payload)
+// RegisterWebhook registers (or, with the ID of an existing one, replaces) a webhook
+func (s *AndroidSMSGateway) RegisterWebhook(ctx context.Context, webhook WebhookRegistration) (*WebhookRegistration, error) {
+	var registered WebhookRegistration
+	if err := s.do(ctx, "POST", "/webhooks", webhook, &registered); err != nil {
+		return nil, err
+	}
+	return &registered, nil
+}
+
+// DeleteWebhook removes a webhook, by ID
+func (s *AndroidSMSGateway) DeleteWebhook(ctx context.Context, id string) error {
+	return s.do(ctx, "DELETE", "/webhooks/"+url.PathEscape(id), nil, nil)
+}
 
-	req, _ := http.NewRequest("POST", url, body)
+// Health returns the device's health checks (battery, connection, failed messages)
+func (s *AndroidSMSGateway) Health(ctx context.Context) (*DeviceHealth, error) {
+	var health DeviceHealth
+	if err := s.do(ctx, "GET", "/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Devices lists the devices registered with the (cloud) gateway
+func (s *AndroidSMSGateway) Devices(ctx context.Context) ([]Device, error) {
+	var devices []Device
+	if err := s.do(ctx, "GET", "/devices", nil, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// do sends in (if any) as JSON and decodes the response into out (if any),
+// or returns an *APIError for a non-2xx response
+func (s *AndroidSMSGateway) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		buf := bytes.NewBuffer(nil)
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(in); err != nil {
+			return err
+		}
+		body = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
 	req.SetBasicAuth(s.username, s.password)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-	client := &http.Client{}
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("request to send message to '%s' failed: %v", number, err)
+		return fmt.Errorf("%s %s: %w", method, path, err)
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed sending message to '%s': %d %s",
-			number, resp.StatusCode, string(body),
-		)
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &msg) == nil {
+			apiErr.Message = msg.Message
+		}
+		return apiErr
 	}
 
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decode response: %w", method, path, err)
+	}
 	return nil
 }
 
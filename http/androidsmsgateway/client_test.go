@@ -0,0 +1,300 @@
+package androidsmsgateway_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+// fakeGateway is a minimal in-memory android-sms-gateway
+type fakeGateway struct {
+	mu       sync.Mutex
+	messages map[string]androidsmsgateway.Message
+	webhooks map[string]androidsmsgateway.WebhookRegistration
+	nextID   int
+}
+
+func newFakeGateway(t *testing.T) (*androidsmsgateway.AndroidSMSGateway, *fakeGateway, *httptest.Server) {
+	t.Helper()
+	fake := &fakeGateway{
+		messages: map[string]androidsmsgateway.Message{},
+		webhooks: map[string]androidsmsgateway.WebhookRegistration{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /messages", fake.sendMessage)
+	mux.HandleFunc("GET /messages/{id}", fake.getMessage)
+	mux.HandleFunc("GET /webhooks", fake.listWebhooks)
+	mux.HandleFunc("POST /webhooks", fake.registerWebhook)
+	mux.HandleFunc("DELETE /webhooks/{id}", fake.deleteWebhook)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, map[string]any{"status": "pass", "version": "1.2.3", "checks": map[string]any{}})
+	})
+	mux.HandleFunc("GET /devices", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, []map[string]any{{"id": "dev1", "name": "Pixel", "lastSeen": "2026-01-02T03:04:05Z"}})
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "sms" || pass != "secret" {
+			writeJSON(w, 401, map[string]string{"message": "invalid credentials"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := androidsmsgateway.New(srv.URL+"/", "sms", "secret")
+	client.HTTPClient = srv.Client()
+	return client, fake, srv
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeGateway) sendMessage(w http.ResponseWriter, r *http.Request) {
+	var msg androidsmsgateway.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeJSON(w, 400, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	f.nextID++
+	id := msg.ID
+	if id == "" {
+		id = "msg" + strconv.Itoa(f.nextID)
+	}
+	f.messages[id] = msg
+	f.mu.Unlock()
+	writeJSON(w, 202, f.state(id, msg, androidsmsgateway.StatePending))
+}
+
+func (f *fakeGateway) getMessage(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	msg, ok := f.messages[r.PathValue("id")]
+	f.mu.Unlock()
+	if !ok {
+		writeJSON(w, 404, map[string]string{"message": "message not found"})
+		return
+	}
+	writeJSON(w, 200, f.state(r.PathValue("id"), msg, androidsmsgateway.StateDelivered))
+}
+
+func (f *fakeGateway) state(id string, msg androidsmsgateway.Message, state androidsmsgateway.ProcessState) androidsmsgateway.MessageState {
+	ms := androidsmsgateway.MessageState{ID: id, DeviceID: "dev1", State: state}
+	for _, number := range msg.PhoneNumbers {
+		ms.Recipients = append(ms.Recipients, androidsmsgateway.RecipientState{PhoneNumber: number, State: state})
+	}
+	return ms
+}
+
+func (f *fakeGateway) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	webhooks := []androidsmsgateway.WebhookRegistration{}
+	for _, wh := range f.webhooks {
+		webhooks = append(webhooks, wh)
+	}
+	slices.SortFunc(webhooks, func(a, b androidsmsgateway.WebhookRegistration) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	writeJSON(w, 200, webhooks)
+}
+
+func (f *fakeGateway) registerWebhook(w http.ResponseWriter, r *http.Request) {
+	var wh androidsmsgateway.WebhookRegistration
+	if err := json.NewDecoder(r.Body).Decode(&wh); err != nil || wh.URL == "" || wh.Event == "" {
+		writeJSON(w, 400, map[string]string{"message": "url and event are required"})
+		return
+	}
+	f.mu.Lock()
+	if wh.ID == "" {
+		f.nextID++
+		wh.ID = "wh" + strconv.Itoa(f.nextID)
+	}
+	f.webhooks[wh.ID] = wh
+	f.mu.Unlock()
+	writeJSON(w, 201, wh)
+}
+
+func (f *fakeGateway) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	delete(f.webhooks, r.PathValue("id"))
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestSend(t *testing.T) {
+	client, fake, _ := newFakeGateway(t)
+	ctx := context.Background()
+
+	state, err := client.Send(ctx, "+1 (555) 123-4567", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID == "" || state.State != androidsmsgateway.StatePending {
+		t.Fatalf("state: %+v", state)
+	}
+	sent := fake.messages[state.ID]
+	if !slices.Equal(sent.PhoneNumbers, []string{"+15551234567"}) || sent.TextMessage.Text != "hello" || sent.Priority != 65 {
+		t.Errorf("sent: %+v", sent)
+	}
+
+	state, err = client.GetMessage(ctx, state.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.State != androidsmsgateway.StateDelivered || state.Recipients[0].PhoneNumber != "+15551234567" {
+		t.Errorf("GetMessage: %+v", state)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	client, fake, _ := newFakeGateway(t)
+	ctx := context.Background()
+
+	msg := androidsmsgateway.NewTextMessage("hi all", "555-0100", "555-0101")
+	msg.ID = "campaign-1"
+	state, err := client.SendMessage(ctx, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ID != "campaign-1" || len(state.Recipients) != 2 {
+		t.Errorf("multi-recipient: %+v", state)
+	}
+
+	data := []byte{0x00, 0xff, 0x10}
+	state, err = client.SendMessage(ctx, androidsmsgateway.NewDataMessage(data, 53739, "5550100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := fake.messages[state.ID]
+	if sent.TextMessage != nil || sent.DataMessage == nil || sent.DataMessage.Port != 53739 || string(sent.DataMessage.Data) != string(data) {
+		t.Errorf("data message: %+v", sent)
+	}
+}
+
+func TestSendMessageInvalid(t *testing.T) {
+	client, _, _ := newFakeGateway(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		msg  androidsmsgateway.Message
+		want error
+	}{
+		{"no recipients", androidsmsgateway.NewTextMessage("hi"), androidsmsgateway.ErrNoRecipients},
+		{"empty number", androidsmsgateway.NewTextMessage("hi", "(call me)"), androidsmsgateway.ErrInvalidNumber},
+		{"no body", androidsmsgateway.Message{PhoneNumbers: []string{"5550100"}}, androidsmsgateway.ErrInvalidMessage},
+		{"text and data", androidsmsgateway.Message{
+			PhoneNumbers: []string{"5550100"},
+			TextMessage:  &androidsmsgateway.TextMessage{Text: "hi"},
+			DataMessage:  &androidsmsgateway.DataMessage{Data: []byte("hi")},
+		}, androidsmsgateway.ErrInvalidMessage},
+	}
+	for _, tt := range tests {
+		if _, err := client.SendMessage(ctx, tt.msg); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestWebhookRegistration(t *testing.T) {
+	client, _, _ := newFakeGateway(t)
+	ctx := context.Background()
+
+	for _, event := range []string{androidsmsgateway.EventSMSReceived, androidsmsgateway.EventSMSDelivered} {
+		wh, err := client.RegisterWebhook(ctx, androidsmsgateway.WebhookRegistration{
+			URL:   "https://example.com/hooks",
+			Event: event,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wh.ID == "" || wh.Event != event {
+			t.Errorf("registered: %+v", wh)
+		}
+	}
+
+	webhooks, err := client.Webhooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 2 {
+		t.Fatalf("webhooks: %+v", webhooks)
+	}
+
+	if err := client.DeleteWebhook(ctx, webhooks[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	webhooks, _ = client.Webhooks(ctx)
+	if len(webhooks) != 1 || webhooks[0].Event != androidsmsgateway.EventSMSDelivered {
+		t.Errorf("after delete: %+v", webhooks)
+	}
+
+	_, err = client.RegisterWebhook(ctx, androidsmsgateway.WebhookRegistration{})
+	var apiErr *androidsmsgateway.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || apiErr.Message != "url and event are required" {
+		t.Errorf("bad registration: %v", err)
+	}
+}
+
+func TestHealthAndDevices(t *testing.T) {
+	client, _, _ := newFakeGateway(t)
+	ctx := context.Background()
+
+	health, err := client.Health(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "pass" || health.Version != "1.2.3" {
+		t.Errorf("health: %+v", health)
+	}
+
+	devices, err := client.Devices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].ID != "dev1" || devices[0].LastSeen.Year() != 2026 {
+		t.Errorf("devices: %+v", devices)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	client, _, srv := newFakeGateway(t)
+	ctx := context.Background()
+
+	_, err := client.GetMessage(ctx, "nope")
+	if !errors.Is(err, androidsmsgateway.ErrNotFound) || errors.Is(err, androidsmsgateway.ErrRetryable) {
+		t.Errorf("missing message: %v", err)
+	}
+
+	wrong := androidsmsgateway.New(srv.URL, "sms", "wrong")
+	wrong.HTTPClient = srv.Client()
+	if _, err := wrong.Health(ctx); !errors.Is(err, androidsmsgateway.ErrUnauthorized) {
+		t.Errorf("wrong password: %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.Send(canceled, "5550100", "hi"); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: %v", err)
+	}
+
+	for status, retryable := range map[int]bool{429: true, 500: true, 503: true, 400: false, 404: false} {
+		err := error(&androidsmsgateway.APIError{StatusCode: status})
+		if errors.Is(err, androidsmsgateway.ErrRetryable) != retryable {
+			t.Errorf("%d: retryable should be %v", status, retryable)
+		}
+	}
+}
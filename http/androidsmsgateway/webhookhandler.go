@@ -0,0 +1,252 @@
+package androidsmsgateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	// DefaultTolerance is how far X-Timestamp may be from now
+	DefaultTolerance = 5 * time.Minute
+	// DefaultMaxBodySize is the largest webhook body that will be read
+	DefaultMaxBodySize = 1 << 20
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredTimestamp = errors.New("webhook timestamp outside of tolerance")
+	// ErrNoRetry can be returned (or wrapped) by a callback to acknowledge a
+	// webhook that failed in a way that a retry won't fix
+	ErrNoRetry = errors.New("do not retry")
+)
+
+// IDCache remembers the IDs of webhooks that have been handled, so that
+// the gateway's retries (and replays) are only handled once
+type IDCache interface {
+	// Seen reports whether the ID was already added, or else adds it (until expires)
+	Seen(id string, expires time.Time) bool
+	// Forget removes the ID, so that a retry will be handled
+	Forget(id string)
+}
+
+// MemoryIDCache is an in-memory IDCache
+type MemoryIDCache struct {
+	mu  sync.Mutex
+	ids map[string]time.Time
+}
+
+func NewMemoryIDCache() *MemoryIDCache {
+	return &MemoryIDCache{ids: map[string]time.Time{}}
+}
+
+func (c *MemoryIDCache) Seen(id string, expires time.Time) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, exp := range c.ids {
+		if now.After(exp) {
+			delete(c.ids, k)
+		}
+	}
+	if _, ok := c.ids[id]; ok {
+		return true
+	}
+	c.ids[id] = expires
+	return false
+}
+
+func (c *MemoryIDCache) Forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ids, id)
+}
+
+// WebhookHandler verifies, dedupes, decodes, and dispatches the gateway's
+// webhooks to the callback for each event. Without a callback for the event,
+// OnEvent is called, or else the event is ignored.
+//
+// The gateway retries a webhook until it gets a 2xx, so the handler responds:
+//   - 200 when handled, ignored, a duplicate, unparseable, or ErrNoRetry
+//   - 401 for a missing or invalid signature, or a timestamp outside of Tolerance
+//   - 500 when a callback returns any other error (and the ID is forgotten, for the retry)
+type WebhookHandler struct {
+	// SigningKey is the gateway's signing key. If empty, signatures aren't checked.
+	SigningKey string
+	// Tolerance is how far X-Timestamp may be from now (0 for any)
+	Tolerance time.Duration
+	// IDs dedupes by webhook ID (nil to handle every delivery)
+	IDs         IDCache
+	MaxBodySize int64
+	Logger      *slog.Logger
+
+	OnReceived     func(*http.Request, *WebhookReceived) error
+	OnDataReceived func(*http.Request, *WebhookDataReceived) error
+	OnMMSReceived  func(*http.Request, *WebhookMMSReceived) error
+	OnSent         func(*http.Request, *WebhookSent) error
+	OnDelivered    func(*http.Request, *WebhookDelivered) error
+	OnFailed       func(*http.Request, *WebhookFailed) error
+	OnPing         func(*http.Request, *WebhookPing) error
+	// OnEvent is called for each event that has no callback of its own,
+	// and with a *Webhook for an event that Decode doesn't know
+	OnEvent func(*http.Request, WebhookEvent) error
+}
+
+// NewWebhookHandler verifies signatures with the signing key (if any),
+// with the DefaultTolerance, and dedupes in memory
+func NewWebhookHandler(signingKey string) *WebhookHandler {
+	return &WebhookHandler{
+		SigningKey:  signingKey,
+		Tolerance:   DefaultTolerance,
+		IDs:         NewMemoryIDCache(),
+		MaxBodySize: DefaultMaxBodySize,
+	}
+}
+
+// GetEvent lets an undecoded *Webhook be passed to OnEvent
+func (w *Webhook) GetEvent() string {
+	return w.Event
+}
+
+// Verify checks the signature and timestamp of a webhook's body
+func (h *WebhookHandler) Verify(body []byte, signature, timestamp string) error {
+	if h.SigningKey == "" {
+		return nil
+	}
+	if signature == "" || !VerifySignature(h.SigningKey, string(body), timestamp, signature) {
+		return ErrInvalidSignature
+	}
+	if h.Tolerance > 0 {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		if d := time.Since(TimestampTime(ts)); d > h.Tolerance || d < -h.Tolerance {
+			return ErrExpiredTimestamp
+		}
+	}
+	return nil
+}
+
+// TimestampTime converts an X-Timestamp to a time.
+// The gateway sends seconds, but milliseconds are accepted too.
+func TimestampTime(ts int64) time.Time {
+	if ts > 1e12 {
+		return time.UnixMilli(ts).UTC()
+	}
+	return time.Unix(ts, 0).UTC()
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := h.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeWebhookError(w, http.StatusOK, "failed to read webhook")
+		return
+	}
+
+	signature := r.Header.Get(SignatureHeader)
+	timestamp := r.Header.Get(TimestampHeader)
+	if err := h.Verify(body, signature, timestamp); err != nil {
+		logger.Warn("rejected webhook", "error", err, "remote", r.RemoteAddr)
+		writeWebhookError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var webhook Webhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		writeWebhookError(w, http.StatusOK, "failed to parse webhook")
+		return
+	}
+	webhook.XSignature = signature
+	webhook.XTimestamp, _ = strconv.ParseInt(timestamp, 10, 64)
+
+	if h.IDs != nil && webhook.ID != "" {
+		if h.IDs.Seen(webhook.ID, time.Now().Add(max(h.Tolerance, DefaultTolerance)*2)) {
+			_, _ = w.Write([]byte(`{"message": "duplicate"}`))
+			return
+		}
+	}
+
+	var event WebhookEvent = &webhook
+	if decoded, err := Decode(&webhook); err == nil {
+		event = decoded
+	}
+	if err := h.dispatch(r, event); err != nil {
+		if errors.Is(err, ErrNoRetry) {
+			logger.Warn("webhook failed", "event", webhook.Event, "id", webhook.ID, "error", err)
+			writeWebhookError(w, http.StatusOK, err.Error())
+			return
+		}
+		logger.Error("webhook failed, will retry", "event", webhook.Event, "id", webhook.ID, "error", err)
+		if h.IDs != nil && webhook.ID != "" {
+			h.IDs.Forget(webhook.ID)
+		}
+		writeWebhookError(w, http.StatusInternalServerError, "failed to handle webhook")
+		return
+	}
+
+	_, _ = w.Write([]byte(`{"message": "ok"}`))
+}
+
+func (h *WebhookHandler) dispatch(r *http.Request, event WebhookEvent) error {
+	switch e := event.(type) {
+	case *WebhookReceived:
+		if h.OnReceived != nil {
+			return h.OnReceived(r, e)
+		}
+	case *WebhookDataReceived:
+		if h.OnDataReceived != nil {
+			return h.OnDataReceived(r, e)
+		}
+	case *WebhookMMSReceived:
+		if h.OnMMSReceived != nil {
+			return h.OnMMSReceived(r, e)
+		}
+	case *WebhookSent:
+		if h.OnSent != nil {
+			return h.OnSent(r, e)
+		}
+	case *WebhookDelivered:
+		if h.OnDelivered != nil {
+			return h.OnDelivered(r, e)
+		}
+	case *WebhookFailed:
+		if h.OnFailed != nil {
+			return h.OnFailed(r, e)
+		}
+	case *WebhookPing:
+		if e.PingedAt.IsZero() && e.XTimestamp != 0 {
+			e.PingedAt = TimestampTime(e.XTimestamp)
+		}
+		if h.OnPing != nil {
+			return h.OnPing(r, e)
+		}
+	}
+	if h.OnEvent != nil {
+		return h.OnEvent(r, event)
+	}
+	return nil
+}
+
+func writeWebhookError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.Marshal(map[string]string{"error": msg})
+	_, _ = w.Write(b)
+}
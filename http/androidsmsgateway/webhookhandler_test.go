@@ -0,0 +1,120 @@
+package androidsmsgateway_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/therootcompany/golib/http/androidsmsgateway"
+)
+
+const testSigningKey = "test-signing-key"
+
+func webhookRequest(body string, ts int64, key string) *http.Request {
+	r := httptest.NewRequest("POST", "/webhooks", strings.NewReader(body))
+	timestamp := strconv.FormatInt(ts, 10)
+	r.Header.Set(androidsmsgateway.TimestampHeader, timestamp)
+	if key != "" {
+		r.Header.Set(androidsmsgateway.SignatureHeader, androidsmsgateway.Sign(key, body, timestamp))
+	}
+	return r
+}
+
+func receivedBody(id string) string {
+	return fmt.Sprintf(`{"deviceId":"dev1","event":"sms:received","id":%q,"webhookId":"wh1",`+
+		`"payload":{"messageId":"m1","message":"hi","phoneNumber":"+15550100","simNumber":1,"receivedAt":"2026-01-02T03:04:05Z"}}`, id)
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var received []*androidsmsgateway.WebhookReceived
+	var pings []*androidsmsgateway.WebhookPing
+	var others []string
+	h := androidsmsgateway.NewWebhookHandler(testSigningKey)
+	h.OnReceived = func(r *http.Request, ev *androidsmsgateway.WebhookReceived) error {
+		received = append(received, ev)
+		return nil
+	}
+	h.OnPing = func(r *http.Request, ev *androidsmsgateway.WebhookPing) error {
+		pings = append(pings, ev)
+		return nil
+	}
+	h.OnEvent = func(r *http.Request, ev androidsmsgateway.WebhookEvent) error {
+		others = append(others, fmt.Sprintf("%T %s", ev, ev.GetEvent()))
+		return nil
+	}
+
+	now := time.Now().Unix()
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", webhookRequest(receivedBody("ev1"), now, testSigningKey), 200},
+		{"duplicate", webhookRequest(receivedBody("ev1"), now, testSigningKey), 200},
+		{"wrong key", webhookRequest(receivedBody("ev2"), now, "wrong"), 401},
+		{"unsigned", webhookRequest(receivedBody("ev2"), now, ""), 401},
+		{"expired", webhookRequest(receivedBody("ev2"), now-3600, testSigningKey), 401},
+		{"milliseconds", webhookRequest(receivedBody("ev2"), time.Now().UnixMilli(), testSigningKey), 200},
+		{"malformed", webhookRequest(`{"event":`, now, testSigningKey), 200},
+		{"ping", webhookRequest(`{"event":"system:ping","id":"ev3","payload":{"health":{"status":"pass"}}}`, now, testSigningKey), 200},
+		{"sent", webhookRequest(`{"event":"sms:sent","id":"ev4","payload":{"messageId":"m2"}}`, now, testSigningKey), 200},
+		{"unknown", webhookRequest(`{"event":"sms:future","id":"ev5","payload":{}}`, now, testSigningKey), 200},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tt.req)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+	}
+
+	if len(received) != 2 || received[0].Payload.Message != "hi" || received[0].XSignature == "" {
+		t.Errorf("received: %+v", received)
+	}
+	if len(pings) != 1 || pings[0].PingedAt.Unix() != now || pings[0].Payload.Health.Status != "pass" {
+		t.Errorf("pings: %+v", pings)
+	}
+	want := []string{"*androidsmsgateway.WebhookSent sms:sent", "*androidsmsgateway.Webhook sms:future"}
+	if fmt.Sprint(others) != fmt.Sprint(want) {
+		t.Errorf("others = %q, want %q", others, want)
+	}
+}
+
+func TestWebhookHandlerRetry(t *testing.T) {
+	var calls int
+	h := androidsmsgateway.NewWebhookHandler("")
+	h.OnReceived = func(r *http.Request, ev *androidsmsgateway.WebhookReceived) error {
+		calls++
+		switch ev.ID {
+		case "temporary":
+			if calls == 1 {
+				return errors.New("database is locked")
+			}
+		case "permanent":
+			return fmt.Errorf("bad number: %w", androidsmsgateway.ErrNoRetry)
+		}
+		return nil
+	}
+
+	for i, want := range []int{500, 200, 200} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, webhookRequest(receivedBody("temporary"), 0, ""))
+		if w.Code != want {
+			t.Errorf("attempt %d: status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("the retry should be handled, and then deduped: %d calls", calls)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, webhookRequest(receivedBody("permanent"), 0, ""))
+	if w.Code != 200 {
+		t.Errorf("ErrNoRetry: status = %d, want 200", w.Code)
+	}
+}
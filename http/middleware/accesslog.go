@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// statusWriter remembers the status and counts the bytes written
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	hijacked bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 && status >= 200 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, for streaming responses
+func (sw *statusWriter) Flush() {
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker, for WebSockets
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(sw.ResponseWriter).Hijack()
+	if err == nil {
+		sw.hijacked = true
+		if sw.status == 0 {
+			sw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, brw, err
+}
+
+// Unwrap is for http.ResponseController
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// AccessLog logs each request to the logger (or slog.Default) when it completes,
+// with its status, bytes written, and duration, and its request ID if RequestID
+// came first. Server errors are logged as errors.
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Int("status", status),
+					slog.Int64("bytes", sw.bytes),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("remote", r.RemoteAddr),
+				}
+				if id := GetRequestID(r.Context()); id != "" {
+					attrs = append(attrs, slog.String("request_id", id))
+				}
+				level := slog.LevelInfo
+				if status >= 500 {
+					level = slog.LevelError
+				}
+				orDefault(logger).LogAttrs(r.Context(), level, "request", attrs...)
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+func orDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
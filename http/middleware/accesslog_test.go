@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int
+		level   string
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("hello")) }, 200, 5, "INFO"},
+		{"no body", func(w http.ResponseWriter, r *http.Request) {}, 200, 0, "INFO"},
+		{"not found", http.NotFound, 404, 19, "INFO"},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.WriteHeader(http.StatusOK) // superfluous
+		}, 502, 0, "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			h := RequestID(AccessLog(logger)(tt.handler))
+			r := httptest.NewRequest("GET", "/things?secret=1", nil)
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			var entry struct {
+				Level     string `json:"level"`
+				Msg       string `json:"msg"`
+				Method    string `json:"method"`
+				Path      string `json:"path"`
+				Status    int    `json:"status"`
+				Bytes     int    `json:"bytes"`
+				Duration  int64  `json:"duration"`
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("%v: %s", err, buf.String())
+			}
+			if entry.Level != tt.level || entry.Msg != "request" || entry.Method != "GET" || entry.Path != "/things" {
+				t.Errorf("entry: %+v", entry)
+			}
+			if entry.Status != tt.status || entry.Bytes != tt.bytes {
+				t.Errorf("status %d, bytes %d; want %d, %d", entry.Status, entry.Bytes, tt.status, tt.bytes)
+			}
+			if entry.RequestID == "" {
+				t.Error("expected the request ID")
+			}
+		})
+	}
+}
+
+func TestStatusWriterFlush(t *testing.T) {
+	h := AccessLog(slog.New(slog.DiscardHandler))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected an http.Flusher")
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed {
+		t.Error("expected the flush to reach the recorder")
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+type principleKeyType struct{}
+
+var principleKey principleKeyType
+
+// WithPrinciple returns a copy of ctx that holds the principle (see GetPrinciple)
+func WithPrinciple(ctx context.Context, p auth.BasicPrinciple) context.Context {
+	return context.WithValue(ctx, principleKey, p)
+}
+
+// GetPrinciple returns the principle stored by Authenticate or RequirePermission
+func GetPrinciple(ctx context.Context) (auth.BasicPrinciple, bool) {
+	p, ok := ctx.Value(principleKey).(auth.BasicPrinciple)
+	return p, ok && p != nil
+}
+
+// GetPrincipleAs returns the stored principle as its concrete type, such as *csvauth.Credential
+func GetPrincipleAs[T auth.BasicPrinciple](ctx context.Context) (T, bool) {
+	p, _ := GetPrinciple(ctx)
+	t, ok := p.(T)
+	return t, ok
+}
+
+// Authenticate returns middleware that authenticates each request with ra and
+// stores the principle in the request context, or responds 401 Unauthorized
+// with ra.BasicRealm as the WWW-Authenticate challenge.
+func Authenticate(ra *auth.BasicRequestAuthenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := ra.Authenticate(r)
+			if err != nil {
+				unauthorized(w, ra)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrinciple(r.Context(), p)))
+		})
+	}
+}
+
+// RequirePermission returns middleware that requires the principle to have
+// any one of the permissions (see HasPermission), or responds 403 Forbidden.
+// If Authenticate hasn't already stored a principle, the request is
+// authenticated with ra (or responds 401 Unauthorized).
+func RequirePermission(ra *auth.BasicRequestAuthenticator, permissions ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := GetPrinciple(r.Context())
+			if !ok {
+				var err error
+				p, err = ra.Authenticate(r)
+				if err != nil {
+					unauthorized(w, ra)
+					return
+				}
+				r = r.WithContext(WithPrinciple(r.Context(), p))
+			}
+
+			for _, permission := range permissions {
+				if HasPermission(p.Permissions(), permission) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// HasPermission reports whether perms includes the permission or a wildcard
+// for its prefix, such as "sms:*" for "sms:received". A bare "*" matches nothing.
+func HasPermission(perms []string, permission string) bool {
+	for _, p := range perms {
+		if p == permission {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasSuffix(prefix, ":") && strings.HasPrefix(permission, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(w http.ResponseWriter, ra *auth.BasicRequestAuthenticator) {
+	if ra.BasicRealm != "" {
+		w.Header().Set("WWW-Authenticate", ra.BasicRealm)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
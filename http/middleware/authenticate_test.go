@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/therootcompany/golib/auth"
+)
+
+type testPrinciple struct {
+	name  string
+	perms []string
+}
+
+func (p *testPrinciple) ID() string            { return p.name }
+func (p *testPrinciple) Permissions() []string { return p.perms }
+
+// testTokens authenticates tokens as principles
+type testTokens map[string]*testPrinciple
+
+func (ts testTokens) Authenticate(_, token string) (auth.BasicPrinciple, error) {
+	if p, ok := ts[token]; ok {
+		return p, nil
+	}
+	return nil, errors.New("unauthorized")
+}
+
+func newTestRequestAuth() *auth.BasicRequestAuthenticator {
+	return auth.NewBasicRequestAuthenticator(testTokens{
+		"all-token":      {"root", []string{"*"}},
+		"sms-token":      {"sms-bot", []string{"sms:*"}},
+		"received-token": {"reader", []string{"sms:received"}},
+		"other-token":    {"other", []string{"smsx:sent", "mms:*"}},
+	})
+}
+
+func doAuth(h http.Handler, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthenticate(t *testing.T) {
+	ra := newTestRequestAuth()
+	h := Authenticate(ra)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := GetPrinciple(r.Context())
+		if !ok {
+			t.Error("expected a principle in the context")
+			return
+		}
+		tp, ok := GetPrincipleAs[*testPrinciple](r.Context())
+		if !ok || tp != p {
+			t.Errorf("GetPrincipleAs = %v %v", tp, ok)
+		}
+		_, _ = w.Write([]byte(p.ID()))
+	}))
+
+	for token, want := range map[string]int{"": 401, "nope": 401, "sms-token": 200} {
+		w := doAuth(h, token)
+		if w.Code != want {
+			t.Errorf("%q: status = %d, want %d", token, w.Code, want)
+		}
+		if want == 401 && w.Header().Get("WWW-Authenticate") != "Basic" {
+			t.Errorf("%q: WWW-Authenticate = %q", token, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+	if w := doAuth(h, "sms-token"); w.Body.String() != "sms-bot" {
+		t.Errorf("body = %q", w.Body.String())
+	}
+
+	ra.BasicRealm = ""
+	if w := doAuth(h, ""); w.Header().Values("WWW-Authenticate") != nil {
+		t.Errorf("an empty realm should send no challenge, got %q", w.Header().Values("WWW-Authenticate"))
+	}
+
+	if _, ok := GetPrinciple(httptest.NewRequest("GET", "/", nil).Context()); ok {
+		t.Error("expected no principle without Authenticate")
+	}
+	if _, ok := GetPrincipleAs[*testPrinciple](httptest.NewRequest("GET", "/", nil).Context()); ok {
+		t.Error("expected no principle without Authenticate")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	ra := newTestRequestAuth()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := GetPrinciple(r.Context()); !ok {
+			t.Error("expected a principle in the context")
+		}
+	})
+
+	received := RequirePermission(ra, "sms:received")(ok)
+	anySMS := RequirePermission(ra, "sms:sent", "sms:received")(ok)
+	tests := []struct {
+		name  string
+		h     http.Handler
+		token string
+		want  int
+	}{
+		{"no credentials", received, "", http.StatusUnauthorized},
+		{"bad token", received, "nope", http.StatusUnauthorized},
+		{"exact", received, "received-token", http.StatusOK},
+		{"wildcard", received, "sms-token", http.StatusOK},
+		{"bare wildcard", received, "all-token", http.StatusForbidden},
+		{"other prefix", received, "other-token", http.StatusForbidden},
+		{"any of", anySMS, "received-token", http.StatusOK},
+		{"after Authenticate", Authenticate(ra)(received), "received-token", http.StatusOK},
+		{"forbidden after Authenticate", Authenticate(ra)(RequirePermission(ra, "sms:sent")(ok)), "received-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doAuth(tt.h, tt.token)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			hasChallenge := w.Header().Get("WWW-Authenticate") != ""
+			if hasChallenge != (tt.want == http.StatusUnauthorized) {
+				t.Errorf("WWW-Authenticate = %q for %d", w.Header().Get("WWW-Authenticate"), w.Code)
+			}
+			if w.Code == http.StatusForbidden && !strings.Contains(w.Body.String(), "Forbidden") {
+				t.Errorf("body = %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		perms      []string
+		permission string
+		want       bool
+	}{
+		{[]string{"sms:received"}, "sms:received", true},
+		{[]string{"sms:*"}, "sms:received", true},
+		{[]string{"*"}, "sms:received", false},
+		{[]string{"sms:sent"}, "sms:received", false},
+		{[]string{"sm*"}, "sms:received", false},
+		{[]string{"sms:*"}, "smsx:received", false},
+		{nil, "sms:received", false},
+	}
+	for _, tt := range tests {
+		if got := HasPermission(tt.perms, tt.permission); got != tt.want {
+			t.Errorf("HasPermission(%q, %q) = %v", tt.perms, tt.permission, got)
+		}
+	}
+}
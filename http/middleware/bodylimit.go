@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// BodyLimit responds 413 Request Entity Too Large when the Content-Length is
+// over n bytes, and otherwise limits the body to n bytes, so that reading past
+// it fails with an *http.MaxBytesError (and the connection is closed).
+func BodyLimit(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
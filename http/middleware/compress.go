@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoder returns a writer that compresses to w at the level
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+// DefaultCompressTypes are the content types worth compressing
+var DefaultCompressTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-ndjson",
+	"application/jsonl",
+	"image/svg+xml",
+}
+
+// Compressor compresses responses with the best encoding that the client accepts.
+// zstd and gzip are built in. Others, such as br, can be added with SetEncoder.
+//
+// Server-Sent Events (text/event-stream) are never compressed.
+type Compressor struct {
+	Level int
+	// Types are content types, or prefixes ending in "/", to compress
+	Types []string
+	// MinSize skips responses with a smaller Content-Length
+	MinSize int
+
+	encodings []string
+	encoders  map[string]Encoder
+}
+
+// NewCompressor compresses the DefaultCompressTypes with zstd (preferred) or
+// gzip, at the level (such as gzip.DefaultCompression)
+func NewCompressor(level int) *Compressor {
+	c := &Compressor{
+		Level:    level,
+		Types:    DefaultCompressTypes,
+		MinSize:  256,
+		encoders: map[string]Encoder{},
+	}
+	pool := &sync.Pool{}
+	c.SetEncoder("gzip", func(w io.Writer, level int) (io.WriteCloser, error) {
+		if gz, ok := pool.Get().(*gzip.Writer); ok {
+			gz.Reset(w)
+			return &pooledGzip{gz, pool}, nil
+		}
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledGzip{gz, pool}, nil
+	})
+	zpool := &sync.Pool{}
+	c.SetEncoder("zstd", func(w io.Writer, level int) (io.WriteCloser, error) {
+		if zw, ok := zpool.Get().(*zstd.Encoder); ok {
+			zw.Reset(w)
+			return &pooledZstd{zw, zpool}, nil
+		}
+		zw, err := zstd.NewWriter(w,
+			zstd.WithEncoderLevel(zstdLevel(level)),
+			// one goroutine per response, and no more than the 8MB window that browsers accept
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(8<<20),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &pooledZstd{zw, zpool}, nil
+	})
+	return c
+}
+
+// zstdLevel is the zstd level nearest to a gzip level
+func zstdLevel(level int) zstd.EncoderLevel {
+	if level <= 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(level)
+}
+
+// Compress is NewCompressor(level).Handler
+func Compress(level int) Middleware {
+	return NewCompressor(level).Handler
+}
+
+// SetEncoder adds (or replaces) an encoding, preferred over those already set
+func (c *Compressor) SetEncoder(encoding string, enc Encoder) {
+	encoding = strings.ToLower(encoding)
+	c.encodings = slices.DeleteFunc(c.encodings, func(e string) bool { return e == encoding })
+	c.encodings = append([]string{encoding}, c.encodings...)
+	c.encoders[encoding] = enc
+}
+
+// Handler is a Middleware
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate returns the most preferred encoding with the highest q-value in Accept-Encoding, or ""
+func (c *Compressor) negotiate(accept string) string {
+	qs := map[string]float64{}
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		qs[name] = q
+	}
+
+	var best string
+	var bestQ float64
+	for _, encoding := range c.encodings {
+		q, ok := qs[encoding]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func (c *Compressor) compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < c.MinSize {
+		return false
+	}
+	ct, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	// proxies and clients (and EventSource) expect each event as soon as it's flushed
+	if ct == "text/event-stream" {
+		return false
+	}
+	for _, t := range c.Types {
+		if ct == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter decides whether to compress when the header is written
+type compressWriter struct {
+	http.ResponseWriter
+	c           *Compressor
+	encoding    string
+	enc         io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader || status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && cw.c.compressible(h) {
+		enc, err := cw.c.encoders[cw.encoding](cw.ResponseWriter, cw.c.Level)
+		if err == nil {
+			cw.enc = enc
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, flushing what's been compressed so far
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack implements http.Hijacker, for WebSockets
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(cw.ResponseWriter).Hijack()
+}
+
+// Unwrap is for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) Close() {
+	if cw.enc != nil {
+		_ = cw.enc.Close()
+	}
+}
+
+// pooledGzip returns the gzip.Writer to the pool when closed
+type pooledGzip struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (pg *pooledGzip) Close() error {
+	err := pg.Writer.Close()
+	pg.pool.Put(pg.Writer)
+	return err
+}
+
+// pooledZstd returns the zstd.Encoder to the pool when closed
+type pooledZstd struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (pz *pooledZstd) Close() error {
+	err := pz.Encoder.Close()
+	pz.pool.Put(pz.Encoder)
+	return err
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var compressBody = strings.Repeat(`{"hello":"world"},`, 100)
+
+func TestCompress(t *testing.T) {
+	c := NewCompressor(gzip.DefaultCompression)
+
+	serve := func(contentType, body string) http.Handler {
+		return c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			w.Header().Set("Content-Length", "999")
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+
+	tests := []struct {
+		name     string
+		accept   string
+		ct       string
+		body     string
+		encoding string
+	}{
+		{"none", "", "application/json", compressBody, ""},
+		{"gzip", "gzip", "application/json", compressBody, "gzip"},
+		{"zstd", "zstd", "application/json", compressBody, "zstd"},
+		{"prefers zstd", "gzip, deflate, br, zstd", "application/json", compressBody, "zstd"},
+		{"by q-value", "gzip;q=1.0, zstd;q=0.5", "application/json", compressBody, "gzip"},
+		{"refused", "gzip;q=0", "application/json", compressBody, ""},
+		{"any", "*", "text/csv; charset=utf-8", compressBody, "zstd"},
+		{"sniffed", "gzip", "", "<html><body>" + compressBody, "gzip"},
+		{"already compressed", "gzip", "image/png", compressBody, ""},
+		{"unknown encoding", "br", "application/json", compressBody, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			serve(tt.ct, tt.body).ServeHTTP(w, r)
+
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if w.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", w.Header().Get("Vary"))
+			}
+
+			var body io.Reader = w.Body
+			switch tt.encoding {
+			case "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			case "zstd":
+				zr, err := zstd.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer zr.Close()
+				body = zr
+			}
+			if tt.encoding != "" && w.Header().Get("Content-Length") != "" {
+				t.Error("Content-Length must be removed")
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.body {
+				t.Errorf("body = %.40q...", b)
+			}
+		})
+	}
+}
+
+func TestCompressSetEncoder(t *testing.T) {
+	c := NewCompressor(gzip.DefaultCompression)
+	c.SetEncoder("deflate", func(w io.Writer, level int) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	h := c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(compressBody))
+	}))
+
+	for accept, want := range map[string]string{"zstd, gzip, deflate": "deflate", "zstd, gzip": "zstd"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("%q: Content-Encoding = %q, want %q", accept, got, want)
+		}
+		if want != "deflate" {
+			continue
+		}
+		b, err := io.ReadAll(flate.NewReader(w.Body))
+		if err != nil || string(b) != compressBody {
+			t.Errorf("body = %.40q... %v", b, err)
+		}
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	h := Compress(gzip.BestSpeed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "2")
+			_, _ = w.Write([]byte("ok"))
+		case "/not-modified":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNotModified)
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte("pretend"))
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("data: " + compressBody + "\n\n"))
+		}
+	}))
+
+	for _, path := range []string{"/small", "/not-modified", "/encoded", "/events"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip, zstd")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got == "gzip" || got == "zstd" {
+			t.Errorf("%s: should not be compressed", path)
+		}
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			testCompressFlush(t, encoding)
+		})
+	}
+}
+
+func testCompressFlush(t *testing.T, encoding string) {
+	flushed := make(chan struct{})
+	srv := httptest.NewServer(Compress(gzip.DefaultCompression)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte("{\"first\":1}\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+		<-flushed
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	defer close(flushed)
+	if got := res.Header.Get("Content-Encoding"); got != encoding {
+		t.Fatalf("Content-Encoding = %q", got)
+	}
+
+	var body io.Reader
+	if encoding == "zstd" {
+		zr, err := zstd.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		body = zr
+	} else {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	b := make([]byte, len("{\"first\":1}\n"))
+	if _, err := io.ReadFull(body, b); err != nil || string(b) != "{\"first\":1}\n" {
+		t.Errorf("expected the flushed event before the handler returned: %q %v", b, err)
+	}
+}
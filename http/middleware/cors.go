@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS allows cross-origin requests from the AllowedOrigins, and answers their
+// preflight (OPTIONS) requests without calling the next handler.
+// Since the mux responds 405 to OPTIONS for a "GET /path" pattern, wrap the
+// mux itself (or register "OPTIONS /path") rather than a single route.
+type CORS struct {
+	// AllowedOrigins are exact ("https://example.com"), a subdomain wildcard
+	// ("https://*.example.com"), or "*" for any
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders may be "*" to allow whatever headers are requested
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// NewCORS allows the common methods and the Authorization and Content-Type headers from the origins
+func NewCORS(origins ...string) *CORS {
+	return &CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Handler is a Middleware
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if isPreflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+		if !c.allowsOrigin(origin) {
+			if isPreflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if slices.Contains(c.AllowedOrigins, "*") && !c.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !isPreflight {
+			if len(c.ExposedHeaders) > 0 {
+				h.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !slices.Contains(c.AllowedMethods, method) {
+			h.Del("Access-Control-Allow-Origin")
+			h.Del("Access-Control-Allow-Credentials")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		if headers := c.allowedHeaders(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if c.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c *CORS) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		// "https://*.example.com" allows "https://api.example.com", but not "https://example.com"
+		prefix, host, _ := strings.Cut(strings.ToLower(origin), "://")
+		if prefix == strings.ToLower(scheme) && strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedHeaders returns the requested headers that are allowed, comma-separated
+func (c *CORS) allowedHeaders(requested string) string {
+	var allowed []string
+	anyHeader := slices.Contains(c.AllowedHeaders, "*")
+	for name := range strings.SplitSeq(requested, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if anyHeader || slices.ContainsFunc(c.AllowedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			allowed = append(allowed, name)
+		}
+	}
+	return strings.Join(allowed, ", ")
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	c := NewCORS("https://app.example.com", "https://*.example.net")
+	c.ExposedHeaders = []string{"X-Request-ID"}
+	c.AllowCredentials = true
+	h := c.Handler(next)
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		wantOrigin  string
+		wantHeaders string
+		wantNext    bool
+	}{
+		{"same origin", "GET", "", "", "", "", "", true},
+		{"simple", "GET", "https://app.example.com", "", "", "https://app.example.com", "", true},
+		{"subdomain", "POST", "https://api.example.net", "", "", "https://api.example.net", "", true},
+		{"not the bare domain", "GET", "https://example.net", "", "", "", "", true},
+		{"other scheme", "GET", "http://api.example.net", "", "", "", "", true},
+		{"disallowed", "GET", "https://evil.example", "", "", "", "", true},
+		{"preflight", "OPTIONS", "https://app.example.com", "PUT", "content-type, x-custom", "https://app.example.com", "content-type", false},
+		{"preflight, disallowed origin", "OPTIONS", "https://evil.example", "PUT", "", "", "", false},
+		{"preflight, disallowed method", "OPTIONS", "https://app.example.com", "TRACE", "", "", "", false},
+		{"plain OPTIONS", "OPTIONS", "https://app.example.com", "", "", "https://app.example.com", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			if tt.reqHeaders != "" {
+				r.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if called != tt.wantNext {
+				t.Errorf("next called = %v", called)
+			}
+			if !tt.wantNext && w.Code != http.StatusNoContent {
+				t.Errorf("preflight status = %d", w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Allow-Headers = %q, want %q", got, tt.wantHeaders)
+			}
+			if tt.wantOrigin == "" {
+				return
+			}
+			if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("expected Allow-Credentials")
+			}
+			if tt.wantNext && w.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID" {
+				t.Errorf("Expose-Headers = %q", w.Header().Get("Access-Control-Expose-Headers"))
+			}
+			if !tt.wantNext && (w.Header().Get("Access-Control-Max-Age") != "600" || w.Header().Get("Access-Control-Allow-Methods") == "") {
+				t.Errorf("preflight headers: %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	c := &CORS{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, AllowedHeaders: []string{"*"}, MaxAge: time.Minute}
+	h := c.Handler(http.NotFoundHandler())
+
+	r := httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://anywhere.example")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	r.Header.Set("Access-Control-Request-Headers", "X-One, X-Two")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "X-One, X-Two" {
+		t.Errorf("Allow-Headers = %q", got)
+	}
+
+	// with credentials, the origin must be echoed rather than "*"
+	c.AllowCredentials = true
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://anywhere.example" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/therootcompany/golib/http/middleware/v2
 
-go 1.24.6
+go 1.25.0
+
+require (
+	github.com/klauspost/compress v1.20.1
+	github.com/therootcompany/golib/auth v1.1.1
+)
+
+replace github.com/therootcompany/golib/auth => ../../auth
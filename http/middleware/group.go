@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Route is a pattern registered through a MiddlewareMux
+type Route struct {
+	// Pattern is the full pattern, as registered with the mux, such as "GET /api/items/{id}"
+	Pattern string `json:"pattern"`
+	Method  string `json:"method,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Path    string `json:"path"`
+	// Middlewares is the number of middleware that wrap the handler
+	Middlewares int `json:"middlewares"`
+	// Mounted is true for a Mount, which serves everything under the Path
+	Mounted bool `json:"mounted,omitempty"`
+}
+
+func (r Route) String() string {
+	return r.Pattern
+}
+
+type routeTable struct {
+	mu     sync.Mutex
+	routes []Route
+}
+
+func (t *routeTable) add(pattern string, middlewares int, mounted bool) {
+	if t == nil {
+		return
+	}
+	method, host, path := splitPattern(pattern)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.routes = append(t.routes, Route{
+		Pattern:     pattern,
+		Method:      method,
+		Host:        host,
+		Path:        path,
+		Middlewares: middlewares,
+		Mounted:     mounted,
+	})
+}
+
+// splitPattern splits a Go 1.22 "[METHOD ][HOST]/[PATH]" pattern
+func splitPattern(pattern string) (method, host, path string) {
+	rest := pattern
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		method, rest = pattern[:i], strings.TrimLeft(pattern[i:], " \t")
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return method, rest, ""
+	}
+	return method, rest[:i], rest[i:]
+}
+
+// pattern joins the group's prefix to the pattern's path, keeping the method and host
+func (c MiddlewareMux) pattern(pattern string) string {
+	if c.prefix == "" {
+		return pattern
+	}
+	method, host, path := splitPattern(pattern)
+	if !strings.HasPrefix(path, "/") {
+		panic(fmt.Errorf("mw.Group(%q).Handle(%q) requires a path starting with /", c.prefix, pattern))
+	}
+	joined := host + c.prefix + path
+	if method != "" {
+		joined = method + " " + joined
+	}
+	return joined
+}
+
+// Group returns a copy of the mux that registers patterns under the prefix,
+// with the middlewares appended. Methods (and hosts) in patterns are kept, so
+// Group("/api").Handle("GET /items/{id}", h) registers "GET /api/items/{id}",
+// and "/" registers the whole "/api/" subtree.
+func (c MiddlewareMux) Group(prefix string, middlewares ...Middleware) MiddlewareMux {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(errors.New("mw.Group(-->this<--) requires a prefix starting with /"))
+	}
+	g := c.With(middlewares...)
+	g.prefix = c.prefix + strings.TrimSuffix(prefix, "/")
+	return g
+}
+
+// Mount serves everything under the prefix (of the group) with the handler,
+// such as another mux or a file server, with the prefix stripped from the path
+func (c MiddlewareMux) Mount(prefix string, handler http.Handler) {
+	if !strings.HasPrefix(prefix, "/") {
+		panic(errors.New("mw.Mount(-->this<--, handler) requires a prefix starting with /"))
+	}
+	if handler == nil {
+		panic(errors.New("mw.Mount(prefix, -->this<--) requires a handler"))
+	}
+	full := c.prefix + strings.TrimSuffix(prefix, "/")
+	pattern := full + "/"
+	c.mux.Handle(pattern, c.handle(http.StripPrefix(full, handler)))
+	c.routes.add(pattern, len(c.middlewares), true)
+}
+
+// Routes returns the routes registered through this mux (or any mux made from
+// it by With or Group), in the order they were registered
+func (c MiddlewareMux) Routes() []Route {
+	if c.routes == nil {
+		return nil
+	}
+	c.routes.mu.Lock()
+	defer c.routes.mu.Unlock()
+	routes := make([]Route, len(c.routes.routes))
+	copy(routes, c.routes.routes)
+	return routes
+}
+
+// RoutesHandler serves the route table as JSON, or as one pattern per line for text/plain
+func (c MiddlewareMux) RoutesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes := c.Routes()
+		if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, route := range routes {
+				_, _ = fmt.Fprintln(w, route.Pattern)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(routes)
+	})
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// tag appends its name to the X-Trace response header, to show the order of middleware
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func reply(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body+" "+r.URL.Path)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	mux := http.NewServeMux()
+	mw := WithMux(mux, tag("root"))
+	mw.HandleFunc("GET /health", reply("health"))
+
+	api := mw.Group("/api/", tag("api"))
+	api.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "item "+r.PathValue("id"))
+	})
+	api.HandleFunc("POST /items", reply("create"))
+	api.HandleFunc("/{$}", reply("api index"))
+
+	v2 := api.Group("/v2", tag("v2"))
+	v2.HandleFunc("GET example.com/items", reply("v2 items on example.com"))
+
+	files := http.NewServeMux()
+	files.HandleFunc("GET /{name}", reply("file"))
+	api.With(tag("files")).Mount("/files", files)
+
+	tests := []struct {
+		method, target string
+		status         int
+		body           string
+		trace          []string
+	}{
+		{"GET", "/health", 200, "health /health", []string{"root"}},
+		{"GET", "/api/items/42", 200, "item 42", []string{"root", "api"}},
+		{"POST", "/api/items", 200, "create /api/items", []string{"root", "api"}},
+		{"DELETE", "/api/items", 405, "", nil},
+		{"GET", "/api/", 200, "api index /api/", []string{"root", "api"}},
+		{"GET", "http://example.com/api/v2/items", 200, "v2 items on example.com /api/v2/items", []string{"root", "api", "v2"}},
+		{"GET", "http://other.example/api/v2/items", 404, "", nil},
+		{"GET", "/api/files/readme.md", 200, "file /readme.md", []string{"root", "api", "files"}},
+		{"GET", "/items/42", 404, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if tt.trace != nil && !slices.Equal(w.Header().Values("X-Trace"), tt.trace) {
+				t.Errorf("middleware = %q, want %q", w.Header().Values("X-Trace"), tt.trace)
+			}
+		})
+	}
+
+	var patterns []string
+	for _, r := range mw.Routes() {
+		patterns = append(patterns, r.Pattern)
+	}
+	want := []string{
+		"GET /health",
+		"GET /api/items/{id}",
+		"POST /api/items",
+		"/api/{$}",
+		"GET example.com/api/v2/items",
+		"/api/files/",
+	}
+	if !slices.Equal(patterns, want) {
+		t.Errorf("routes:\n got %q\nwant %q", patterns, want)
+	}
+
+	routes := v2.Routes()
+	if len(routes) != len(want) {
+		t.Errorf("every copy should share the route table, got %d routes", len(routes))
+	}
+	v2route := routes[4]
+	if v2route.Method != "GET" || v2route.Host != "example.com" || v2route.Path != "/api/v2/items" || v2route.Middlewares != 3 {
+		t.Errorf("route: %+v", v2route)
+	}
+	if mount := routes[5]; !mount.Mounted || mount.Path != "/api/files/" || mount.Middlewares != 3 {
+		t.Errorf("mount: %+v", mount)
+	}
+}
+
+func TestRoutesHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mw := WithMux(mux)
+	mw.Group("/api").HandleFunc("GET /items", reply("items"))
+	mw.Handle("GET /debug/routes", mw.RoutesHandler())
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/debug/routes", nil))
+	var routes []Route
+	if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].Pattern != "GET /api/items" || routes[1].Path != "/debug/routes" {
+		t.Errorf("routes: %+v", routes)
+	}
+
+	r := httptest.NewRequest("GET", "/debug/routes", nil)
+	r.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if got := w.Body.String(); got != "GET /api/items\nGET /debug/routes\n" {
+		t.Errorf("text: %q", got)
+	}
+}
+
+func TestGroupPanics(t *testing.T) {
+	mw := WithMux(http.NewServeMux())
+	for name, f := range map[string]func(){
+		"prefix":       func() { mw.Group("api") },
+		"mount prefix": func() { mw.Mount("files", http.NotFoundHandler()) },
+		"mount nil":    func() { mw.Mount("/files", nil) },
+		"pattern":      func() { mw.Group("/api").HandleFunc("GET items", reply("")) },
+	} {
+		func() {
+			defer func() {
+				if v := recover(); v == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
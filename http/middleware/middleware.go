@@ -54,6 +54,8 @@ func (c MiddlewareChain) Handle(handler http.Handler) http.Handler {
 type MiddlewareMux struct {
 	middlewares []Middleware
 	mux         Muxer
+	prefix      string
+	routes      *routeTable
 }
 
 // WithMux wraps a mux such so that Handle and HandleFunc apply the middleware chain
@@ -61,6 +63,7 @@ func WithMux(mux Muxer, middlewares ...Middleware) MiddlewareMux {
 	return MiddlewareMux{
 		middlewares: middlewares,
 		mux:         mux,
+		routes:      &routeTable{},
 	}
 }
 
@@ -73,15 +76,20 @@ func (c MiddlewareMux) With(middlewares ...Middleware) MiddlewareMux {
 	return MiddlewareMux{
 		mux:         c.mux,
 		middlewares: newMiddlewares,
+		prefix:      c.prefix,
+		routes:      c.routes,
 	}
 }
 
+// Handle registers the pattern, under the group's prefix (if any), with the middleware chain
 func (c MiddlewareMux) Handle(path string, handler http.Handler) {
-	c.mux.Handle(path, c.handle(handler))
+	pattern := c.pattern(path)
+	c.mux.Handle(pattern, c.handle(handler))
+	c.routes.add(pattern, len(c.middlewares), false)
 }
 
 func (c MiddlewareMux) HandleFunc(path string, handler http.HandlerFunc) {
-	c.mux.Handle(path, c.handle(handler))
+	c.Handle(path, handler)
 }
 
 // Handle composes middleware with the final handler
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover logs a panic in the handler, with its stack trace, to the logger
+// (or slog.Default), and responds 500 Internal Server Error if nothing has
+// been written yet. http.ErrAbortHandler is re-panicked, for the server to abort quietly.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				orDefault(logger).ErrorContext(r.Context(), "panic",
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("request_id", GetRequestID(r.Context())),
+					slog.String("panic", fmt.Sprint(v)),
+					slog.String("stack", string(debug.Stack())),
+				)
+				if sw.status == 0 && !sw.hijacked {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				}
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	t.Run("before writing", func(t *testing.T) {
+		buf.Reset()
+		h := Recover(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(errors.New("oops"))
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d", w.Code)
+		}
+		log := buf.String()
+		for _, want := range []string{"level=ERROR", "msg=panic", "panic=oops", "path=/boom", "recover_test.go"} {
+			if !strings.Contains(log, want) {
+				t.Errorf("log is missing %q:\n%s", want, log)
+			}
+		}
+	})
+
+	t.Run("after writing", func(t *testing.T) {
+		h := Recover(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("later")
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+			t.Errorf("the response was already started: %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		h := Recover(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("expected ErrAbortHandler to be re-panicked, got %v", v)
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
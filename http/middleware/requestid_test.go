@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = GetRequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"none", "", false},
+		{"uuid", "0b4ce1c8-2c0a-4c55-9a52-9a2b1e8f7f35", true},
+		{"load balancer", "Root=1-67891233-abcdef012345678912345678", true},
+		{"injection", "abc\nlevel=error", false},
+		{"spaces", "a b", false},
+		{"too long", string(make([]byte, 129)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if got == "" || w.Header().Get(RequestIDHeader) != got {
+				t.Fatalf("context %q, response header %q", got, w.Header().Get(RequestIDHeader))
+			}
+			if (got == tt.incoming) != tt.keep {
+				t.Errorf("got %q for %q, keep=%v", got, tt.incoming, tt.keep)
+			}
+		})
+	}
+
+	if id := GetRequestID(httptest.NewRequest("GET", "/", nil).Context()); id != "" {
+		t.Errorf("expected no ID without the middleware, got %q", id)
+	}
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeout cancels the request's context after d, and responds 503 Service
+// Unavailable if the handler hasn't finished by then (see http.TimeoutHandler).
+// The response is buffered, so don't use it for streams or WebSockets.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, "Service Unavailable: the request timed out\n")
+	}
+}
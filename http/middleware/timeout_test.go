@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+			_, _ = w.Write([]byte("too late"))
+		}
+	})
+	fast := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("expected a deadline")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	for name, tt := range map[string]struct {
+		h    http.Handler
+		want int
+	}{
+		"slow": {Timeout(20 * time.Millisecond)(slow), http.StatusServiceUnavailable},
+		"fast": {Timeout(time.Second)(fast), http.StatusCreated},
+	} {
+		w := httptest.NewRecorder()
+		tt.h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, tt.want)
+		}
+	}
+}
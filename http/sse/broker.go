@@ -0,0 +1,76 @@
+package sse
+
+import (
+	"sync"
+)
+
+// DefaultBufferSize is how many events a subscriber may fall behind
+const DefaultBufferSize = 64
+
+// Broker fans out each published event to every subscriber.
+//
+// A subscriber that falls BufferSize events behind is dropped (its channel is
+// closed) so that one slow client can't hold up the others. Clients are
+// expected to reconnect, and to resume from their Last-Event-ID.
+type Broker struct {
+	BufferSize int
+	mu         sync.Mutex
+	subs       map[<-chan Event]chan Event
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		BufferSize: DefaultBufferSize,
+		subs:       make(map[<-chan Event]chan Event),
+	}
+}
+
+// Subscribe returns a channel of the events published from now on,
+// which is closed by Unsubscribe, or if the subscriber falls behind
+func (b *Broker) Subscribe() <-chan Event {
+	size := b.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	ch := make(chan Event, size)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[<-chan Event]chan Event)
+	}
+	b.subs[ch] = ch
+	return ch
+}
+
+// Unsubscribe closes the channel (it's safe to call more than once)
+func (b *Broker) Unsubscribe(sub <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(ch)
+	}
+}
+
+// Publish sends the event to every subscriber, without blocking
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// the subscriber is backed up
+			delete(b.subs, sub)
+			close(ch)
+		}
+	}
+}
+
+// Len is the number of subscribers
+func (b *Broker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
@@ -0,0 +1,3 @@
+module github.com/therootcompany/golib/http/sse
+
+go 1.24.6
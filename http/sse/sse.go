@@ -0,0 +1,113 @@
+// Package sse writes Server-Sent Events (text/event-stream),
+// and fans them out to any number of subscribers.
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrStreamingUnsupported = errors.New("streaming unsupported: the ResponseWriter can't flush")
+
+const (
+	// LastEventIDHeader is sent by EventSource when it reconnects
+	LastEventIDHeader = "Last-Event-ID"
+	// LastEventIDParam is for clients that can't set headers on the first connection
+	LastEventIDParam = "lastEventId"
+	// DefaultHeartbeat is often enough to keep most proxies from timing out
+	DefaultHeartbeat = 15 * time.Second
+)
+
+// Event is a single Server-Sent Event
+type Event struct {
+	// ID is what the client sends back as Last-Event-ID when it reconnects
+	ID string
+	// Event is the type of event ("message", if empty)
+	Event string
+	// Data is sent as one "data:" line per line
+	Data []byte
+	// Retry, if set, tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+// WriteTo writes the event in the text/event-stream format
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: " + oneLine(e.ID) + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + oneLine(e.Event) + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(e.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := bytes.ReplaceAll(e.Data, []byte("\r\n"), []byte("\n"))
+	for line := range bytes.SplitSeq(data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimSuffix(line, []byte("\r")))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.WriteTo(w)
+}
+
+// oneLine keeps an id or event name from starting a new field
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Stream is an open text/event-stream response
+type Stream struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// NewStream sends the headers for an event stream, and clears the server's
+// write deadline (if any), since a stream is expected to outlive it.
+// The error is ErrStreamingUnsupported if the response can't be flushed.
+func NewStream(w http.ResponseWriter) (*Stream, error) {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// tells nginx (and some other proxies) not to buffer
+	h.Set("X-Accel-Buffering", "no")
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStreamingUnsupported, err)
+	}
+	return &Stream{w: w, rc: rc}, nil
+}
+
+// Send writes and flushes an event
+func (s *Stream) Send(e Event) error {
+	if _, err := e.WriteTo(s.w); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// Comment writes and flushes a comment, which clients ignore (such as a heartbeat)
+func (s *Stream) Comment(text string) error {
+	if _, err := io.WriteString(s.w, ": "+oneLine(text)+"\n\n"); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// LastEventID returns the Last-Event-ID header, or else the lastEventId query param
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get(LastEventIDHeader); id != "" {
+		return id
+	}
+	return r.URL.Query().Get(LastEventIDParam)
+}
@@ -0,0 +1,123 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventWriteTo(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data only", Event{Data: []byte("hello")}, "data: hello\n\n"},
+		{"all fields", Event{ID: "42", Event: "sms:received", Data: []byte(`{"a":1}`), Retry: 3 * time.Second},
+			"id: 42\nevent: sms:received\nretry: 3000\ndata: {\"a\":1}\n\n"},
+		{"multiline", Event{Data: []byte("one\r\ntwo\nthree")}, "data: one\ndata: two\ndata: three\n\n"},
+		{"empty", Event{Event: "ping"}, "event: ping\ndata: \n\n"},
+		{"no injection", Event{ID: "1\ndata: x", Data: []byte("y")}, "id: 1data: x\ndata: y\n\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if _, err := tt.event.WriteTo(&b); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, b.String(), tt.want)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	broker := NewBroker()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := broker.Subscribe()
+		defer broker.Unsubscribe(sub)
+
+		stream, err := NewStream(w)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = stream.Comment("resuming after " + LastEventID(r))
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-sub:
+				if !ok {
+					return
+				}
+				_ = stream.Send(e)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"?lastEventId=7", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type: %s", ct)
+	}
+
+	lines := bufio.NewReader(res.Body)
+	readEvent := func() string {
+		var event string
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			event += line
+			if line == "\n" {
+				return event
+			}
+		}
+	}
+	if got := readEvent(); got != ": resuming after 7\n\n" {
+		t.Errorf("comment: %q", got)
+	}
+	for broker.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Publish(Event{ID: "8", Data: []byte("hi")})
+	if got := readEvent(); got != "id: 8\ndata: hi\n\n" {
+		t.Errorf("event: %q", got)
+	}
+}
+
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := &Broker{BufferSize: 2}
+	slow := broker.Subscribe()
+	fast := broker.Subscribe()
+
+	for i := range 3 {
+		broker.Publish(Event{ID: string(rune('a' + i))})
+		<-fast
+	}
+	if broker.Len() != 1 {
+		t.Fatalf("subscribers: %d", broker.Len())
+	}
+	var ids string
+	for e := range slow {
+		ids += e.ID
+	}
+	if ids != "ab" {
+		t.Errorf("slow subscriber got %q before being dropped", ids)
+	}
+
+	broker.Unsubscribe(fast)
+	broker.Unsubscribe(fast)
+	if _, ok := <-fast; ok || broker.Len() != 0 {
+		t.Error("unsubscribe")
+	}
+}